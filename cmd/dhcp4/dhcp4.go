@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -78,10 +79,13 @@ func logic() error {
 			ack = dhcp
 		}
 	}
-	c := dhcp4.Client{
-		Interface: iface,
-		HWAddr:    hwaddr,
-		Ack:       ack,
+	c, err := dhcp4.NewClient(dhcp4.ClientConfig{
+		Interface:    iface,
+		HardwareAddr: hwaddr,
+		Ack:          ack,
+	})
+	if err != nil {
+		return err
 	}
 	usr2 := make(chan os.Signal, 1)
 	signal.Notify(usr2, syscall.SIGUSR2)
@@ -91,16 +95,17 @@ func logic() error {
 		Min:    10 * time.Second,
 		Max:    1 * time.Minute,
 	}
-	for c.ObtainOrRenew() {
-		if err := c.Err(); err != nil {
+	for {
+		cfg, err := c.ObtainOrRenewErr(context.Background())
+		if err != nil {
 			dur := backoff.Duration()
 			log.Printf("Temporary error: %v (waiting %v)", err, dur)
 			time.Sleep(dur)
 			continue
 		}
 		backoff.Reset()
-		log.Printf("lease: %+v", c.Config())
-		b, err := json.Marshal(c.Config())
+		log.Printf("lease: %+v", cfg)
+		b, err := json.Marshal(cfg)
 		if err != nil {
 			return err
 		}
@@ -122,7 +127,7 @@ func logic() error {
 			log.Printf("notifying netconfig: %v", err)
 		}
 		select {
-		case <-time.After(time.Until(c.Config().RenewAfter)):
+		case <-time.After(time.Until(cfg.RenewAfter)):
 			// fallthrough and renew the DHCP lease
 		case <-usr2:
 			log.Printf("SIGUSR2 received, sending DHCPRELEASE")
@@ -132,7 +137,6 @@ func logic() error {
 			os.Exit(125) // quit supervision by gokrazy
 		}
 	}
-	return c.Err() // permanent error
 }
 
 func main() {
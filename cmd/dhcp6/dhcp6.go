@@ -17,9 +17,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -34,58 +34,81 @@ import (
 
 var log = teelogger.NewConsole()
 
+var debug = flag.Bool("debug", false, "log debug messages, e.g. the DUID and skipped packets")
+
 func logic() error {
 	const leasePath = "/perm/dhcp6/wire/lease.json"
 	if err := os.MkdirAll(filepath.Dir(leasePath), 0755); err != nil {
 		return err
 	}
 
-	duid, err := ioutil.ReadFile("/perm/dhcp6/duid")
-	if err != nil {
-		log.Printf("could not read /perm/dhcp6/duid (%v), proceeding with DUID-LLT", err)
-	}
-
 	c, err := dhcp6.NewClient(dhcp6.ClientConfig{
 		InterfaceName: "uplink0",
-		DUID:          duid,
+		DUIDPath:      "/perm/dhcp6/duid",
+		// Renew the previous lease after a reboot instead of soliciting.
+		LeasePath: "/perm/dhcp6/wire/reply.json",
+		// Ask for the same prefix again should the saved lease have expired.
+		HintPrefixes: true,
+		// Retain the IAID which router7 has always used, so that upgrading
+		// does not change the delegated prefix.
+		IAID:              []byte{0, 0, 0, 1},
+		AcceptReconfigure: true,
+		// Avoid renewing in lockstep with other routers after a power outage.
+		RenewJitter: 0.2,
+		// Report a dead uplink instead of soliciting indefinitely; Run
+		// retries.
+		MaxDuration: 1 * time.Minute,
+		// Recover when the modem is rebooted.
+		WatchLink: true,
+		Logger:    dhcp6.StdLogger(log, *debug),
+		// Persist every lease, so that the lifetimes stay current.
+		OnLease: func(cfg dhcp6.Config) {
+			if err := writeLease(leasePath, cfg); err != nil {
+				log.Printf("writing lease: %v", err)
+			}
+		},
 	})
 	if err != nil {
 		return err
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	usr2 := make(chan os.Signal, 1)
 	signal.Notify(usr2, syscall.SIGUSR2)
-	for c.ObtainOrRenew() {
-		if err := c.Err(); err != nil {
-			log.Printf("Temporary error: %v", err)
-			time.Sleep(10 * time.Second)
-			continue
-		}
-		log.Printf("lease: %+v", c.Config())
-		b, err := json.Marshal(c.Config())
-		if err != nil {
-			return err
-		}
-		if err := renameio.WriteFile(leasePath, b, 0644); err != nil {
-			return err
-		}
-		if err := notify.Process("/user/netconfigd", syscall.SIGUSR1); err != nil {
-			log.Printf("notifying netconfig: %v", err)
-		}
-		if err := notify.Process("/user/radvd", syscall.SIGUSR1); err != nil {
-			log.Printf("notifying radvd: %v", err)
-		}
-		select {
-		case <-time.After(time.Until(c.Config().RenewAfter)):
-			// fallthrough and renew the DHCP lease
-		case <-usr2:
-			log.Printf("SIGUSR2 received, sending DHCPRELEASE")
-			if _, _, err := c.Release(); err != nil {
-				return err
-			}
-			os.Exit(125) // quit supervision by gokrazy
-		}
+	go func() {
+		<-usr2
+		cancel()
+	}()
+	if err := c.Run(ctx); err != context.Canceled {
+		return err
+	}
+	// Run returned, so the client can be used for releasing.
+	log.Printf("SIGUSR2 received, sending DHCPRELEASE")
+	if _, _, err := c.Release(); err != nil {
+		return err
+	}
+	os.Exit(125) // quit supervision by gokrazy
+	return nil
+}
+
+// writeLease persists cfg to leasePath and notifies the processes which
+// configure the network based on it.
+func writeLease(leasePath string, cfg dhcp6.Config) error {
+	log.Printf("lease: %+v", cfg)
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := renameio.WriteFile(leasePath, b, 0644); err != nil {
+		return err
+	}
+	if err := notify.Process("/user/netconfigd", syscall.SIGUSR1); err != nil {
+		log.Printf("notifying netconfig: %v", err)
+	}
+	if err := notify.Process("/user/radvd", syscall.SIGUSR1); err != nil {
+		log.Printf("notifying radvd: %v", err)
 	}
-	return c.Err() // permanent error
+	return nil
 }
 
 func main() {
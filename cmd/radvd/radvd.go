@@ -29,6 +29,33 @@ import (
 	"github.com/rtr7/router7/internal/radvd"
 )
 
+// raPrefixes returns the delegated prefixes of cfg for advertising them via
+// radvd.Server.UpdatePrefixes, which advertises their remaining lifetimes.
+// Prefixes with infinite lifetimes are advertised with the default lifetimes
+// of radvd.Server.SetPrefixes.
+func raPrefixes(cfg dhcp6.Config) []radvd.Prefix {
+	prefixes := make([]radvd.Prefix, 0, len(cfg.Prefixes))
+	for _, prefix := range cfg.Prefixes {
+		p := radvd.Prefix{Prefix: prefix}
+		for _, l := range cfg.Leases {
+			if !sameNetwork(l.Prefix, prefix) || l.ValidUntil.IsZero() {
+				continue
+			}
+			p.ValidUntil = l.ValidUntil
+			p.PreferredUntil = l.PreferredUntil
+			if p.PreferredUntil.IsZero() {
+				p.PreferredUntil = l.ValidUntil
+			}
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
+
+func sameNetwork(a, b net.IPNet) bool {
+	return a.IP.Mask(a.Mask).Equal(b.IP.Mask(b.Mask)) && a.Mask.String() == b.Mask.String()
+}
+
 func logic() error {
 	srv, err := radvd.NewServer()
 	if err != nil {
@@ -51,7 +78,13 @@ func logic() error {
 			}
 		}
 
-		srv.SetPrefixes(append(cfg.Prefixes, additional...))
+		// Advertise the remaining lifetimes of the delegated prefixes, which
+		// dhcp6 updates with every renewal.
+		prefixes := raPrefixes(cfg)
+		for _, prefix := range additional {
+			prefixes = append(prefixes, radvd.Prefix{Prefix: prefix})
+		}
+		srv.UpdatePrefixes(prefixes)
 		return nil
 	}
 	if err := readConfig(); err != nil {
@@ -0,0 +1,67 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rtr7/router7/internal/dhcp6"
+	"github.com/rtr7/router7/internal/radvd"
+)
+
+func mustParseCIDR(s string) net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *n
+}
+
+func TestRAPrefixes(t *testing.T) {
+	now := time.Now()
+	delegated := mustParseCIDR("2a02:168:4a00::/48")
+	infinite := mustParseCIDR("2a02:168:4b00::/48")
+	addr := mustParseCIDR("2a02:168:4c00::1/128")
+	cfg := dhcp6.Config{
+		Prefixes:  []net.IPNet{delegated, infinite},
+		Addresses: []net.IPNet{addr},
+		Leases: []dhcp6.Lease{
+			{
+				Prefix:         delegated,
+				PreferredUntil: now.Add(1 * time.Hour),
+				ValidUntil:     now.Add(2 * time.Hour),
+			},
+			{Prefix: infinite},
+			{
+				Prefix:     addr,
+				ValidUntil: now.Add(2 * time.Hour),
+			},
+		},
+	}
+	want := []radvd.Prefix{
+		{
+			Prefix:         delegated,
+			PreferredUntil: now.Add(1 * time.Hour),
+			ValidUntil:     now.Add(2 * time.Hour),
+		},
+		{Prefix: infinite},
+	}
+	if diff := cmp.Diff(want, raPrefixes(cfg)); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+}
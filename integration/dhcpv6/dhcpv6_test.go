@@ -25,6 +25,7 @@ import (
 	"github.com/rtr7/router7/internal/testing/dnsmasq"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 var v6AddrRe = regexp.MustCompile(`2001:db8::[^ ]+`)
@@ -111,9 +112,17 @@ func TestDHCPv6(t *testing.T) {
 	}
 	got := c.Config()
 	want := dhcp6.Config{
-		DNS: []string{"2001:db8::1"},
+		DNS:        []string{"2001:db8::1"},
+		Transition: dhcp6.TransitionSolicit,
 	}
-	if diff := cmp.Diff(want, got); diff != "" {
+	if len(got.Addresses) != 1 || !v6AddrRe.MatchString(got.Addresses[0].IP.String()) {
+		t.Fatalf("unexpected IA_NA addresses: got %v, want one address from 2001:db8::/64", got.Addresses)
+	}
+	// The address, its lifetimes and timers and the server DUID are chosen
+	// by dnsmasq; TransitionAt is the time of the exchange.
+	ignore := cmpopts.IgnoreFields(dhcp6.Config{},
+		"RenewAfter", "RebindAfter", "T1", "T2", "ServerID", "Leases", "Addresses", "TransitionAt")
+	if diff := cmp.Diff(want, got, ignore); diff != "" {
 		t.Fatalf("unexpected config: diff (-want +got):\n%s", diff)
 	}
 
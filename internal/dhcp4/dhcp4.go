@@ -13,10 +13,16 @@
 // limitations under the License.
 
 // Package dhcp4 implements a DHCPv4 client.
+//
+// Packets are encoded and decoded as gopacket layers.DHCPv4 and sent via the
+// raw socket transport of github.com/rtr7/dhcp4. Only the classless static
+// route and domain search options are decoded with the insomniacslk/dhcp
+// packages.
 package dhcp4
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -25,6 +31,8 @@ import (
 	"time"
 
 	"github.com/google/gopacket/layers"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
 	"github.com/mdlayher/raw"
 	"github.com/rtr7/dhcp4"
 	"golang.org/x/sys/unix"
@@ -36,6 +44,47 @@ type Config struct {
 	SubnetMask string    `json:"subnet_mask"` // e.g. 255.255.255.128
 	Router     string    `json:"router"`      // e.g. 85.195.207.1
 	DNS        []string  `json:"dns"`         // e.g. 77.109.128.2, 213.144.129.20
+
+	// LeaseExpiry is when the lease ends (option 51), or zero if the server
+	// did not specify a lease time.
+	LeaseExpiry time.Time `json:"lease_expiry"`
+
+	// Routes are the classless static routes (option 121, RFC 3442). If
+	// they contain a default route, its router replaces the Router option,
+	// which RFC 3442 requires clients to ignore in this case.
+	Routes []Route `json:"routes"`
+
+	// DomainSearch is the domain search list (option 119, RFC 3397), e.g.
+	// example.net.
+	DomainSearch []string `json:"domain_search"`
+}
+
+// Route is a classless static route.
+type Route struct {
+	Destination string `json:"destination"` // e.g. 10.0.0.0/8
+	Router      string `json:"router"`      // e.g. 85.195.207.1
+}
+
+// ClientConfig contains configuration for NewClient.
+type ClientConfig struct {
+	// Interface is the network interface to obtain a lease on, e.g.
+	// net.InterfaceByName("uplink0"). Required unless Conn is specified.
+	Interface *net.Interface
+
+	// HardwareAddr overrides the hardware address of Interface, e.g. when
+	// netconfigd spoofs the hardware address of the interface.
+	HardwareAddr net.HardwareAddr
+
+	// Hostname is sent in the Host Name option. It defaults to the node name
+	// of the system (uname -n).
+	Hostname string
+
+	// Ack, if non-nil, is the DHCPACK of a previous lease, which is then
+	// renewed instead of starting over at DHCPDISCOVER.
+	Ack *layers.DHCPv4
+
+	// Conn, if non-nil, replaces the raw socket on Interface (for testing).
+	Conn net.PacketConn
 }
 
 type Client struct {
@@ -44,6 +93,7 @@ type Client struct {
 
 	err          error
 	once         sync.Once
+	initErr      error // permanent error of init
 	connection   net.PacketConn
 	hardwareAddr net.HardwareAddr
 	hostname     string
@@ -82,92 +132,247 @@ func (c *Client) packet(xid uint32, opts []layers.DHCPOption) *layers.DHCPv4 {
 
 var errNAK = errors.New("received DHCPNAK")
 
-// ObtainOrRenew returns false when encountering a permanent error.
-func (c *Client) ObtainOrRenew() bool {
-	var onceErr error
+// NewClient returns a Client configured by cfg, like the DHCPv6 client's
+// constructor. It opens the raw socket on cfg.Interface (unless cfg.Conn is
+// specified).
+func NewClient(cfg ClientConfig) (*Client, error) {
+	c := &Client{
+		Interface:  cfg.Interface,
+		HWAddr:     cfg.HardwareAddr,
+		Ack:        cfg.Ack,
+		connection: cfg.Conn,
+		hostname:   cfg.Hostname,
+	}
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// init sets defaults and opens the socket on the first call, and returns the
+// resulting permanent error on every call.
+func (c *Client) init() error {
 	c.once.Do(func() {
-		if c.timeNow == nil {
-			c.timeNow = time.Now
-		}
-		if c.connection == nil && c.Interface != nil {
-			conn, err := raw.ListenPacket(c.Interface, syscall.ETH_P_IP, &raw.Config{
-				LinuxSockDGRAM: true,
-			})
-			if err != nil {
-				onceErr = err
-				return
-			}
-			c.connection = conn
-		}
-		if c.connection == nil && c.Interface == nil {
-			onceErr = fmt.Errorf("c.Interface is nil")
-			return
-		}
-		if c.hardwareAddr == nil && c.HWAddr != nil {
-			c.hardwareAddr = c.HWAddr
-		}
-		if c.hardwareAddr == nil {
-			c.hardwareAddr = c.Interface.HardwareAddr
-		}
-		if c.generateXID == nil {
-			c.generateXID = dhcp4.XIDGenerator(c.hardwareAddr)
+		c.initErr = c.setup()
+	})
+	return c.initErr
+}
+
+func (c *Client) setup() error {
+	if c.timeNow == nil {
+		c.timeNow = time.Now
+	}
+	if c.connection == nil && c.Interface != nil {
+		conn, err := raw.ListenPacket(c.Interface, syscall.ETH_P_IP, &raw.Config{
+			LinuxSockDGRAM: true,
+		})
+		if err != nil {
+			return err
 		}
-		if c.hostname == "" {
-			var utsname unix.Utsname
-			if err := unix.Uname(&utsname); err != nil {
-				onceErr = err
-				return
-			}
-			c.hostname = string(utsname.Nodename[:bytes.IndexByte(utsname.Nodename[:], 0)])
+		c.connection = conn
+	}
+	if c.connection == nil && c.Interface == nil {
+		return fmt.Errorf("c.Interface is nil")
+	}
+	if c.hardwareAddr == nil && c.HWAddr != nil {
+		c.hardwareAddr = c.HWAddr
+	}
+	if c.hardwareAddr == nil {
+		c.hardwareAddr = c.Interface.HardwareAddr
+	}
+	if c.generateXID == nil {
+		c.generateXID = dhcp4.XIDGenerator(c.hardwareAddr)
+	}
+	if c.hostname == "" {
+		var utsname unix.Utsname
+		if err := unix.Uname(&utsname); err != nil {
+			return err
 		}
-	})
-	if onceErr != nil {
-		c.err = onceErr
+		c.hostname = string(utsname.Nodename[:bytes.IndexByte(utsname.Nodename[:], 0)])
+	}
+	return nil
+}
+
+// ObtainOrRenew returns false when encountering a permanent error.
+func (c *Client) ObtainOrRenew() bool {
+	if err := c.init(); err != nil {
+		c.err = err
 		return false // permanent error
 	}
-	c.err = nil // clear previous error
-	ack, err := c.dhcpRequest()
+	c.ObtainOrRenewErr(context.Background())
+	return true
+}
+
+// ObtainOrRenewErr obtains a DHCPv4 lease via DHCPDISCOVER/DHCPOFFER/
+// DHCPREQUEST/DHCPACK, or renews the current lease via DHCPREQUEST/DHCPACK,
+// and returns the resulting configuration. The error is also available via
+// c.Err() until the next call. After a DHCPNAK, the next call starts over at
+// DHCPDISCOVER.
+//
+// Cancelling ctx aborts any in-flight exchange promptly and returns ctx.Err().
+func (c *Client) ObtainOrRenewErr(ctx context.Context) (Config, error) {
+	if err := c.init(); err != nil {
+		c.err = err
+		return Config{}, err
+	}
+	cfg, err := c.obtainOrRenew(ctx)
+	c.err = err // clears any previous error
+	return cfg, err
+}
+
+func (c *Client) obtainOrRenew(ctx context.Context) (Config, error) {
+	stop := c.abortReads(ctx)
+	defer stop()
+	ack, err := c.dhcpRequest(ctx)
 	if err != nil {
+		if err := ctx.Err(); err != nil {
+			return Config{}, err
+		}
 		if errno, ok := err.(syscall.Errno); ok && errno == syscall.EAGAIN {
-			c.err = fmt.Errorf("DHCP: timeout (server(s) unreachable)")
-			return true // temporary error
+			return Config{}, fmt.Errorf("DHCP: timeout (server(s) unreachable)")
 		}
 		if err == errNAK {
 			c.Ack = nil // start over at DHCPDISCOVER
 		}
-		c.err = fmt.Errorf("DHCP: %v", err)
-		return true // temporary error
+		return Config{}, fmt.Errorf("DHCP: %v", err)
 	}
 	c.Ack = ack
-	c.cfg.ClientIP = ack.YourClientIP.String()
+	c.cfg = configFromAck(ack, c.timeNow())
+	return c.cfg, nil
+}
+
+// Renew extends the current lease via DHCPREQUEST/DHCPACK. Unlike
+// ObtainOrRenewErr, it fails if there is no current lease.
+func (c *Client) Renew(ctx context.Context) (Config, error) {
+	if c.Ack == nil {
+		c.err = fmt.Errorf("DHCP: no lease to renew")
+		return Config{}, c.err
+	}
+	return c.ObtainOrRenewErr(ctx)
+}
+
+// configFromAck returns the configuration of ack, which was received at now.
+// Malformed classless static route and domain search options are ignored.
+func configFromAck(ack *layers.DHCPv4, now time.Time) Config {
+	cfg := Config{
+		ClientIP: ack.YourClientIP.String(),
+	}
 	lease := dhcp4.LeaseFromACK(ack)
 	if mask := lease.Netmask; len(mask) > 0 {
-		c.cfg.SubnetMask = fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3])
+		cfg.SubnetMask = fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3])
 	}
 	if len(lease.Router) > 0 {
-		c.cfg.Router = lease.Router.String()
+		cfg.Router = lease.Router.String()
 	}
 	if len(lease.DNS) > 0 {
-		c.cfg.DNS = make([]string, len(lease.DNS))
+		cfg.DNS = make([]string, len(lease.DNS))
 		for idx, ip := range lease.DNS {
-			c.cfg.DNS[idx] = ip.String()
+			cfg.DNS[idx] = ip.String()
+		}
+	}
+	cfg.RenewAfter = now.Add(lease.RenewalTime)
+	for _, opt := range dhcp4.ParseOptions(ack.Options) {
+		if o, ok := opt.(*dhcp4.OptLeaseTime); ok {
+			cfg.LeaseExpiry = now.Add(o.LeaseTime)
 		}
 	}
-	c.cfg.RenewAfter = c.timeNow().Add(lease.RenewalTime)
+
+	if b := optionData(ack, layers.DHCPOptClasslessStaticRoute); b != nil && validRoutes(b) {
+		var routes dhcpv4.Routes
+		if err := routes.FromBytes(b); err == nil {
+			for _, r := range routes {
+				if ones, _ := r.Dest.Mask.Size(); ones == 0 {
+					cfg.Router = r.Router.String()
+				}
+				cfg.Routes = append(cfg.Routes, Route{
+					Destination: r.Dest.String(),
+					Router:      r.Router.String(),
+				})
+			}
+		}
+	}
+	if b := optionData(ack, layers.DHCPOptDomainSearch); b != nil {
+		if labels, err := rfc1035label.FromBytes(b); err == nil {
+			cfg.DomainSearch = labels.Labels
+		}
+	}
+	return cfg
+}
+
+// optionData returns the concatenated data of all options of type typ in pkt,
+// as long options are split into multiple options (RFC 3396), or nil if pkt
+// contains no such option.
+func optionData(pkt *layers.DHCPv4, typ layers.DHCPOpt) []byte {
+	var b []byte
+	for _, o := range pkt.Options {
+		if o.Type == typ {
+			b = append(b, o.Data...)
+		}
+	}
+	return b
+}
+
+// validRoutes returns whether b is a well-formed classless static route
+// option (RFC 3442, section 3), which dhcpv4.Routes does not fully verify.
+func validRoutes(b []byte) bool {
+	for len(b) > 0 {
+		ones := int(b[0])
+		if ones > 32 {
+			return false
+		}
+		n := 1 + (ones+7)/8 + net.IPv4len
+		if len(b) < n {
+			return false
+		}
+		b = b[n:]
+	}
 	return true
 }
 
+// abortReads interrupts reads from c.connection when ctx is done, by setting
+// a read deadline in the past. The returned function must be called to stop
+// watching ctx.
+func (c *Client) abortReads(ctx context.Context) (stop func()) {
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			c.connection.SetReadDeadline(time.Unix(1, 0))
+		case <-finished:
+		}
+	}()
+	return func() { close(finished) }
+}
+
+// setReadDeadline is like c.connection.SetReadDeadline, but does not override
+// the deadline set by abortReads once ctx is done.
+func (c *Client) setReadDeadline(ctx context.Context, t time.Time) {
+	c.connection.SetReadDeadline(t)
+	if ctx.Err() != nil {
+		c.connection.SetReadDeadline(time.Unix(1, 0))
+	}
+}
+
+// Release releases the current lease via DHCPRELEASE and discards it: Config
+// returns the zero Config, and the next exchange starts over at
+// DHCPDISCOVER.
 func (c *Client) Release() error {
+	if c.Ack == nil {
+		return fmt.Errorf("no lease to release")
+	}
 	release := c.packet(c.generateXID(), append([]layers.DHCPOption{
 		dhcp4.MessageTypeOpt(layers.DHCPMsgTypeRelease),
 	}, serverID(c.Ack)...))
 	release.ClientIP = c.Ack.YourClientIP
-	if err := dhcp4.Write(c.connection, release); err != nil {
-		return err
-	}
-
+	// The lease is discarded even if sending fails: the server reclaims it
+	// once it expires.
 	c.Ack = nil
-	return nil
+	c.cfg = Config{}
+	return dhcp4.Write(c.connection, release)
 }
 
 func (c *Client) Err() error {
@@ -178,7 +383,16 @@ func (c *Client) Config() Config {
 	return c.cfg
 }
 
-func (c *Client) dhcpRequest() (*layers.DHCPv4, error) {
+// requestedParams are the options requested via the Parameter Request List.
+var requestedParams = []layers.DHCPOpt{
+	layers.DHCPOptDNS,
+	layers.DHCPOptRouter,
+	layers.DHCPOptSubnetMask,
+	layers.DHCPOptDomainSearch,
+	layers.DHCPOptClasslessStaticRoute,
+}
+
+func (c *Client) dhcpRequest(ctx context.Context) (*layers.DHCPv4, error) {
 	var last *layers.DHCPv4
 
 	if c.Ack != nil {
@@ -188,17 +402,14 @@ func (c *Client) dhcpRequest() (*layers.DHCPv4, error) {
 			dhcp4.MessageTypeOpt(layers.DHCPMsgTypeDiscover),
 			dhcp4.HostnameOpt(c.hostname),
 			dhcp4.ClientIDOpt(layers.LinkTypeEthernet, c.hardwareAddr),
-			dhcp4.ParamsRequestOpt(
-				layers.DHCPOptDNS,
-				layers.DHCPOptRouter,
-				layers.DHCPOptSubnetMask),
+			dhcp4.ParamsRequestOpt(requestedParams...),
 		})
 		if err := dhcp4.Write(c.connection, discover); err != nil {
 			return nil, err
 		}
 
 		// Look for DHCPOFFER packet (described in RFC2131 4.3.1):
-		c.connection.SetReadDeadline(time.Now().Add(10 * time.Second))
+		c.setReadDeadline(ctx, time.Now().Add(10*time.Second))
 		for {
 			offer, err := dhcp4.Read(c.connection)
 			if err != nil {
@@ -224,16 +435,13 @@ func (c *Client) dhcpRequest() (*layers.DHCPv4, error) {
 		dhcp4.RequestIPOpt(last.YourClientIP),
 		dhcp4.HostnameOpt(c.hostname),
 		dhcp4.ClientIDOpt(layers.LinkTypeEthernet, c.hardwareAddr),
-		dhcp4.ParamsRequestOpt(
-			layers.DHCPOptDNS,
-			layers.DHCPOptRouter,
-			layers.DHCPOptSubnetMask),
+		dhcp4.ParamsRequestOpt(requestedParams...),
 	}, serverID(last)...))
 	if err := dhcp4.Write(c.connection, request); err != nil {
 		return nil, err
 	}
 
-	c.connection.SetReadDeadline(time.Now().Add(10 * time.Second))
+	c.setReadDeadline(ctx, time.Now().Add(10*time.Second))
 	for {
 		// Look for DHCPACK packet (described in RFC2131 4.3.1):
 		ack, err := dhcp4.Read(c.connection)
@@ -22,6 +22,9 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/gopacket/layers"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
 	"github.com/rtr7/router7/internal/testing/pcapreplayer"
 )
 
@@ -66,8 +69,70 @@ func TestDHCP4(t *testing.T) {
 			"77.109.128.2",
 			"213.144.129.20",
 		},
+		LeaseExpiry: now.Add(26*time.Minute + 48*time.Second),
 	}
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Fatalf("unexpected config: diff (-want +got):\n%s", diff)
 	}
+
+	if err := c.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if c.Ack != nil {
+		t.Errorf("Ack retained after Release")
+	}
+	if diff := cmp.Diff(Config{}, c.Config()); diff != "" {
+		t.Errorf("unexpected config after Release: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigFromAck(t *testing.T) {
+	routes := dhcpv4.Routes{
+		{
+			Dest:   &net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+			Router: net.IPv4(192, 168, 0, 2).To4(),
+		},
+		{
+			Dest:   &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			Router: net.IPv4(192, 168, 0, 254).To4(),
+		},
+	}.ToBytes()
+	search := (&rfc1035label.Labels{Labels: []string{"example.net", "example.com"}}).ToBytes()
+	ack := &layers.DHCPv4{
+		YourClientIP: net.IPv4(192, 168, 0, 10).To4(),
+		Options: []layers.DHCPOption{
+			layers.NewDHCPOption(layers.DHCPOptSubnetMask, []byte{255, 255, 255, 0}),
+			layers.NewDHCPOption(layers.DHCPOptRouter, []byte{192, 168, 0, 1}),
+			// A long option split into multiple options (RFC 3396).
+			layers.NewDHCPOption(layers.DHCPOptClasslessStaticRoute, routes[:3]),
+			layers.NewDHCPOption(layers.DHCPOptClasslessStaticRoute, routes[3:]),
+			layers.NewDHCPOption(layers.DHCPOptDomainSearch, search),
+		},
+	}
+	now := time.Now()
+	got := configFromAck(ack, now)
+	want := Config{
+		RenewAfter: now.Add(5 * time.Minute),
+		ClientIP:   "192.168.0.10",
+		SubnetMask: "255.255.255.0",
+		// The default route of the classless static routes replaces the
+		// Router option.
+		Router: "192.168.0.254",
+		Routes: []Route{
+			{Destination: "10.0.0.0/8", Router: "192.168.0.2"},
+			{Destination: "0.0.0.0/0", Router: "192.168.0.254"},
+		},
+		DomainSearch: []string{"example.net", "example.com"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected config: diff (-want +got):\n%s", diff)
+	}
+
+	// Malformed classless static routes are ignored.
+	ack.Options[2] = layers.NewDHCPOption(layers.DHCPOptClasslessStaticRoute, []byte{33, 10, 0, 0, 0, 0})
+	ack.Options[3] = layers.NewDHCPOption(layers.DHCPOptClasslessStaticRoute, nil)
+	got = configFromAck(ack, now)
+	if got.Routes != nil || got.Router != "192.168.0.1" {
+		t.Errorf("malformed routes: got routes %v, router %v, want none, 192.168.0.1", got.Routes, got.Router)
+	}
 }
@@ -0,0 +1,228 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestAdvertisePreference(t *testing.T) {
+	backupDUID := dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02},
+	}
+	withPreference := func(pref uint8) dhcpv6.Modifier {
+		return dhcpv6.WithOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionPreference,
+			OptionData: []byte{pref},
+		})
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		backup, err := dhcpv6.NewAdvertiseFromSolicit(msg,
+			dhcpv6.WithServerID(backupDUID),
+			withPreference(10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		preferred := srv.Respond(msg)[0]
+		withPreference(50)(preferred)
+		// The backup server answers first.
+		return []*dhcpv6.Message{backup, preferred}
+	})
+	c := newTestClient(t, srv)
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requested := requestedServerID(srv)
+	if requested == nil || !requested.Equal(dhcp6test.DefaultServerID) {
+		t.Fatalf("Request sent to server %v, want %v", requested, dhcp6test.DefaultServerID)
+	}
+}
+
+// requestedServerID returns the Server Identifier of the last Request received
+// by srv, or nil if it received none.
+func requestedServerID(srv *dhcp6test.Server) *dhcpv6.Duid {
+	var requested *dhcpv6.Duid
+	for _, msg := range srv.Received() {
+		if msg.MessageType == dhcpv6.MessageTypeRequest {
+			requested = msg.Options.ServerID()
+		}
+	}
+	return requested
+}
+
+func TestAdvertiseMissingOptions(t *testing.T) {
+
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		// Malformed Advertises with the maximum preference answer first,
+		// which the client would otherwise select immediately.
+		var malformed []*dhcpv6.Message
+		for _, code := range []dhcpv6.OptionCode{dhcpv6.OptionServerID, dhcpv6.OptionClientID} {
+			adv := srv.Respond(msg)[0]
+			adv.Options.Del(code)
+			adv.AddOption(&dhcpv6.OptionGeneric{
+				OptionCode: dhcpv6.OptionPreference,
+				OptionData: []byte{255},
+			})
+			malformed = append(malformed, adv)
+		}
+		return append(malformed, replies...)
+	})
+	c := newTestClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Errorf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPreferServerID(t *testing.T) {
+	backupDUID := dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02},
+	}
+	unknownDUID := dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x03},
+	}
+	for _, tt := range []struct {
+		name   string
+		prefer dhcpv6.Duid
+		want   dhcpv6.Duid
+	}{
+		{"preferred", dhcp6test.DefaultServerID, dhcp6test.DefaultServerID},
+		{"fallback", unknownDUID, backupDUID},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := mustParseCIDR("2a02:168:4a00::/48")
+			srv := newTestServer(prefix)
+			srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+				// The backup server answers first, with the maximum
+				// preference.
+				backup, err := dhcpv6.NewAdvertiseFromSolicit(msg,
+					dhcpv6.WithServerID(backupDUID),
+					dhcpv6.WithOption(&dhcpv6.OptionGeneric{
+						OptionCode: dhcpv6.OptionPreference,
+						OptionData: []byte{255},
+					}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return append([]*dhcpv6.Message{backup}, srv.Respond(msg)...)
+			})
+			srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+				replies := srv.Respond(msg)
+				for _, reply := range replies {
+					reply.UpdateOption(dhcpv6.OptServerID(*msg.Options.ServerID()))
+				}
+				return replies
+			})
+			c := newTestClientConfig(t, ClientConfig{
+				Conn:           srv,
+				PreferServerID: tt.prefer.ToBytes(),
+			})
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			requested := requestedServerID(srv)
+			if requested == nil || !requested.Equal(tt.want) {
+				t.Fatalf("Request sent to server %v, want %v", requested, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdvertiseMaxPreference(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		replies[0].AddOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionPreference,
+			OptionData: []byte{255},
+		})
+		return replies
+	})
+	c := newTestClient(t, srv)
+	// Collecting Advertises for the first RT (1s) would exceed the timeout.
+	c.retransmission = copyRetransmission(defaultRetransmission)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := c.ObtainOrRenewErr(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRapidCommit(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, tt := range []struct {
+		name      string
+		supported bool // whether the server supports Rapid Commit
+		want      []dhcpv6.MessageType
+	}{
+		{
+			name:      "supported",
+			supported: true,
+			want:      []dhcpv6.MessageType{dhcpv6.MessageTypeSolicit},
+		},
+
+		{
+			name: "ignored",
+			want: []dhcpv6.MessageType{
+				dhcpv6.MessageTypeSolicit,
+				dhcpv6.MessageTypeRequest,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+				Prefixes:    []net.IPNet{prefix},
+				RapidCommit: tt.supported,
+			})
+			c := newTestClientConfig(t, ClientConfig{
+				Conn:        srv,
+				RapidCommit: true,
+			})
+			cfg, err := c.ObtainOrRenewErr(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, srv.ReceivedTypes()); diff != "" {
+				t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+				t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
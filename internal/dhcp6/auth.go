@@ -0,0 +1,162 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// This file implements the Authentication option (RFC 8415, section 21.11),
+// the Reconfigure Key Authentication Protocol (RFC 8415, section 20.4) and
+// validation of delayed authentication (RFC 3315, section 21.4).
+
+const (
+	authProtocolDelayed        = 2 // RFC 3315, section 21.4
+	authProtocolReconfigureKey = 3
+	authAlgorithmHMACMD5       = 1
+	authRDMMonotonicCounter    = 0
+
+	// types of the authentication information of the Reconfigure Key
+	// Authentication Protocol
+	reconfigureKeyValue   = 1
+	reconfigureKeyHMACMD5 = 2
+
+	// offset of the authentication information within the option data
+	authInfoOffset = 11
+)
+
+// authentication is a decoded Authentication option.
+type authentication struct {
+	Protocol        uint8
+	Algorithm       uint8
+	RDM             uint8 // replay detection method
+	ReplayDetection uint64
+	Info            []byte
+}
+
+func parseAuth(data []byte) (*authentication, error) {
+	if len(data) < authInfoOffset {
+		return nil, fmt.Errorf("Authentication option too short: %d bytes", len(data))
+	}
+	return &authentication{
+		Protocol:        data[0],
+		Algorithm:       data[1],
+		RDM:             data[2],
+		ReplayDetection: binary.BigEndian.Uint64(data[3:11]),
+		Info:            data[authInfoOffset:],
+	}, nil
+}
+
+// reconfigureKey returns the reconfigure key and replay detection value which
+// the server sent in reply, or nil if reply does not contain a reconfigure key.
+func reconfigureKey(reply *dhcpv6.Message) ([]byte, uint64, error) {
+	opt := reply.GetOneOption(dhcpv6.OptionAuth)
+	if opt == nil {
+		return nil, 0, nil
+	}
+	auth, err := parseAuth(opt.ToBytes())
+	if err != nil {
+		return nil, 0, err
+	}
+	if auth.Protocol != authProtocolReconfigureKey ||
+		(len(auth.Info) > 0 && auth.Info[0] != reconfigureKeyValue) {
+		// e.g. a digest authenticating reply
+		return nil, 0, nil
+	}
+	if auth.Algorithm != authAlgorithmHMACMD5 ||
+		auth.RDM != authRDMMonotonicCounter ||
+		len(auth.Info) != 1+md5.Size ||
+		auth.Info[0] != reconfigureKeyValue {
+		return nil, 0, fmt.Errorf("malformed reconfigure key: %+v", auth)
+	}
+	return auth.Info[1:], auth.ReplayDetection, nil
+}
+
+// validateAuth verifies the HMAC-MD5 digest in the Authentication option of
+// the message raw, and that its replay detection value is greater than
+// lastReplay. The digest is accepted as delayed authentication information
+// (RFC 3315, section 21.4.1), which ends in the digest, if delayedKey is
+// non-nil, and as Reconfigure Key Authentication Protocol information (RFC
+// 8415, section 20.4.3) if reconfigureKey is non-nil, i.e. for Reconfigure
+// messages. The replay detection value of raw is returned.
+func validateAuth(raw, delayedKey, reconfigureKey []byte, lastReplay uint64) (uint64, error) {
+	// Locate the Authentication option: the digest is computed over the
+	// entire message, with the digest itself set to zero.
+	const headerLen = 4 // msg-type and transaction-id
+	for off := headerLen; off+4 <= len(raw); {
+		code := dhcpv6.OptionCode(binary.BigEndian.Uint16(raw[off:]))
+		length := int(binary.BigEndian.Uint16(raw[off+2:]))
+		data := raw[off+4:]
+		if len(data) < length {
+			return 0, fmt.Errorf("option %v truncated", code)
+		}
+		data = data[:length]
+		if code != dhcpv6.OptionAuth {
+			off += 4 + length
+			continue
+		}
+		auth, err := parseAuth(data)
+		if err != nil {
+			return 0, err
+		}
+		if auth.Algorithm != authAlgorithmHMACMD5 ||
+			auth.RDM != authRDMMonotonicCounter {
+			return 0, fmt.Errorf("unsupported authentication: %+v", auth)
+		}
+		var key []byte
+		switch auth.Protocol {
+		case authProtocolReconfigureKey:
+			if reconfigureKey == nil {
+				return 0, fmt.Errorf("reconfigure key authentication without a reconfigure key (only valid for Reconfigure messages)")
+			}
+			if len(auth.Info) != 1+md5.Size || auth.Info[0] != reconfigureKeyHMACMD5 {
+				return 0, fmt.Errorf("unsupported reconfigure key authentication: %+v", auth)
+			}
+			key = reconfigureKey
+		case authProtocolDelayed:
+			if delayedKey == nil {
+				return 0, fmt.Errorf("delayed authentication without ClientConfig.AuthKey")
+			}
+			// DHCP realm, key ID (4 bytes) and digest
+			if len(auth.Info) < 4+md5.Size {
+				return 0, fmt.Errorf("delayed authentication information too short: %+v", auth)
+			}
+			key = delayedKey
+		default:
+			return 0, fmt.Errorf("unsupported authentication protocol %d", auth.Protocol)
+		}
+		if auth.ReplayDetection <= lastReplay {
+			return 0, fmt.Errorf("replayed message: replay detection %d <= %d", auth.ReplayDetection, lastReplay)
+		}
+		digest := append([]byte(nil), auth.Info[len(auth.Info)-md5.Size:]...)
+		zeroed := append([]byte(nil), raw...)
+		digestOffset := off + 4 + length - md5.Size
+		for i := 0; i < md5.Size; i++ {
+			zeroed[digestOffset+i] = 0
+		}
+		mac := hmac.New(md5.New, key)
+		mac.Write(zeroed)
+		if !hmac.Equal(mac.Sum(nil), digest) {
+			return 0, fmt.Errorf("HMAC-MD5 digest mismatch")
+		}
+		return auth.ReplayDetection, nil
+	}
+	return 0, fmt.Errorf("Authentication option missing")
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestAuthKey(t *testing.T) {
+	key := []byte("shared secret")
+	// sign adds an Authentication option (delayed authentication, with
+	// DHCP realm "router7" and key ID 1) authenticating msg using key.
+	sign := func(msg *dhcpv6.Message, replay uint64, key []byte) {
+		data := []byte{authProtocolDelayed, authAlgorithmHMACMD5, authRDMMonotonicCounter}
+		data = append(data, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(data[3:], replay)
+		data = append(data, "router7"...)
+		data = append(data, 0, 0, 0, 1)
+		data = append(data, make([]byte, md5.Size)...)
+		opt := &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: data}
+		msg.AddOption(opt)
+		mac := hmac.New(md5.New, key)
+		mac.Write(msg.ToBytes())
+		copy(data[len(data)-md5.Size:], mac.Sum(nil))
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	var replayed []byte
+	srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		unsigned := srv.Respond(msg)[0]
+		forged := srv.Respond(msg)[0]
+		sign(forged, 5, []byte("wrong key"))
+		valid := srv.Respond(msg)[0]
+		sign(valid, 1, key)
+		replay := srv.Respond(msg)[0]
+		sign(replay, 1, key)
+		replayed = replay.ToBytes()
+		return []*dhcpv6.Message{unsigned, forged, valid, replay}
+	})
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:    srv,
+		AuthKey: key,
+	})
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := c.reply.GetOneOption(dhcpv6.OptionAuth) != nil, true; got != want {
+		t.Fatalf("unauthenticated Reply accepted")
+	}
+	if got, want := c.replayDetection, uint64(1); got != want {
+		t.Fatalf("unexpected replay detection: got %d, want %d", got, want)
+	}
+	// The replayed message must not be accepted in a later exchange.
+	if _, err := validateAuth(replayed, key, nil, c.replayDetection); err == nil {
+		t.Fatalf("replayed Reply accepted")
+	}
+}
+
+func TestAuthKeyReconfigureKey(t *testing.T) {
+	authKey := []byte("shared secret")
+	reconfigureKey := []byte("0123456789abcdef")
+	rkap := func(replay uint64, typ byte, value []byte) dhcpv6.Option {
+		data := []byte{authProtocolReconfigureKey, authAlgorithmHMACMD5, authRDMMonotonicCounter}
+		data = append(data, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(data[3:], replay)
+		data = append(data, typ)
+		data = append(data, value...)
+		return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: data}
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		for _, reply := range replies {
+			reply.AddOption(rkap(1, reconfigureKeyValue, reconfigureKey))
+		}
+		return replies
+	})
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:              srv,
+		AuthKey:           authKey,
+		AcceptReconfigure: true,
+	})
+	// The Reply to the Request carries the reconfigure key instead of a
+	// digest, and is accepted nevertheless.
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(c.reconfigureKey, reconfigureKey) {
+		t.Fatalf("unexpected reconfigure key: got %x, want %x", c.reconfigureKey, reconfigureKey)
+	}
+
+	// A digest computed with the reconfigure key authenticates Reconfigure
+	// messages only.
+	sign := func(mt dhcpv6.MessageType) []byte {
+		msg := &dhcpv6.Message{MessageType: mt}
+		msg.AddOption(rkap(2, reconfigureKeyHMACMD5, make([]byte, md5.Size)))
+		b := msg.ToBytes()
+		mac := hmac.New(md5.New, reconfigureKey)
+		mac.Write(b)
+		copy(b[len(b)-md5.Size:], mac.Sum(nil))
+		return b
+	}
+	if _, err := validateAuth(sign(dhcpv6.MessageTypeReconfigure), c.authKey, c.reconfigureKey, c.replayDetection); err != nil {
+		t.Errorf("Reconfigure not authenticated: %v", err)
+	}
+	if _, err := validateAuth(sign(dhcpv6.MessageTypeReply), c.authKey, nil, c.replayDetection); err == nil {
+		t.Errorf("Reply authenticated with the reconfigure key")
+	}
+}
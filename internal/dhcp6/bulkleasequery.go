@@ -0,0 +1,217 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// This file implements the requestor side of DHCPv6 Bulk Leasequery (RFC
+// 5460), which transfers the bindings over a TCP connection.
+
+// BulkLeasequeryConfig contains configuration for NewBulkLeasequeryClient.
+type BulkLeasequeryConfig struct {
+	// RemoteAddr is the address of the server. If the port is omitted, the
+	// DHCPv6 server port (547) is used.
+	RemoteAddr string
+
+	// DUID identifies the requestor. Required.
+	DUID *dhcpv6.Duid
+
+	// Conn is used instead of dialing RemoteAddr (for testing).
+	Conn net.Conn
+}
+
+// BulkLeasequeryClient sends Bulk Leasequery messages over a TCP connection.
+// Queries must not be sent concurrently.
+type BulkLeasequeryClient struct {
+	conn net.Conn
+	duid *dhcpv6.Duid
+}
+
+// NewBulkLeasequeryClient connects to cfg.RemoteAddr.
+func NewBulkLeasequeryClient(ctx context.Context, cfg BulkLeasequeryConfig) (*BulkLeasequeryClient, error) {
+	if cfg.DUID == nil {
+		return nil, fmt.Errorf("BulkLeasequeryConfig.DUID not set")
+	}
+	conn := cfg.Conn
+	if conn == nil {
+		addr := cfg.RemoteAddr
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, strconv.Itoa(dhcpv6.DefaultServerPort))
+		}
+		var d net.Dialer
+		var err error
+		conn, err = d.DialContext(ctx, "tcp6", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &BulkLeasequeryClient{
+		conn: conn,
+		duid: cfg.DUID,
+	}, nil
+}
+
+// Close closes the TCP connection.
+func (b *BulkLeasequeryClient) Close() error {
+	return b.conn.Close()
+}
+
+// Query sends a LEASEQUERY message for q and calls fn for each
+// OPTION_CLIENT_DATA the server streams back (RFC 5460, section 6.3), until
+// the server signals the end of the response with LEASEQUERY-DONE. If fn
+// returns an error, the connection is closed (per RFC 5460, the only way to
+// abort a query) and the error is returned.
+//
+// A nil error means the server sent all bindings matching q. If the server
+// closes the connection before sending LEASEQUERY-DONE, the response is
+// incomplete and io.ErrUnexpectedEOF is returned. Servers decline a query via
+// a *StatusError.
+func (b *BulkLeasequeryClient) Query(ctx context.Context, q LeasequeryQuery, fn func(ClientData) error) error {
+	msg, err := newLeasequery(b.duid, q)
+	if err != nil {
+		return err
+	}
+	defer b.abortOn(ctx)()
+	if err := writeTCPMessage(b.conn, msg.ToBytes()); err != nil {
+		return err
+	}
+	for first := true; ; first = false {
+		raw, err := readTCPMessage(b.conn)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		resp, err := dhcpv6.MessageFromBytes(raw)
+		if err != nil {
+			return err
+		}
+		if resp.TransactionID != msg.TransactionID {
+			return fmt.Errorf("unexpected transaction ID %v in %v, want %v", resp.TransactionID, resp.MessageType, msg.TransactionID)
+		}
+		switch resp.MessageType {
+		case dhcpv6.MessageTypeLeaseQueryReply:
+			if !first {
+				return fmt.Errorf("unexpected second %v", resp.MessageType)
+			}
+			reply, err := parseLeasequeryReply(resp, time.Now())
+			if err != nil {
+				// The server sends no further messages for a failed query.
+				return err
+			}
+			for _, cd := range reply.Clients {
+				if err := b.call(fn, cd); err != nil {
+					return err
+				}
+			}
+		case dhcpv6.MessageTypeLeaseQueryData:
+			if first {
+				return fmt.Errorf("%v before LEASEQUERY-REPLY", resp.MessageType)
+			}
+			for _, opt := range resp.GetOption(dhcpv6.OptionClientData) {
+				cd, err := parseClientData(opt.ToBytes(), time.Now())
+				if err != nil {
+					return err
+				}
+				if err := b.call(fn, *cd); err != nil {
+					return err
+				}
+			}
+		case dhcpv6.MessageTypeLeaseQueryDone:
+			if sc := resp.Options.Status(); sc != nil && sc.StatusCode != iana.StatusSuccess {
+				return &StatusError{Code: sc.StatusCode, Message: sc.StatusMessage}
+			}
+			return nil
+		default:
+			return fmt.Errorf("unexpected message type %v", resp.MessageType)
+		}
+	}
+}
+
+// call calls fn with cd and closes the connection if fn fails.
+func (b *BulkLeasequeryClient) call(fn func(ClientData) error, cd ClientData) error {
+	if err := fn(cd); err != nil {
+		b.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// abortOn aborts blocking reads and writes as soon as ctx is done, like
+// Client.abortReads.
+func (b *BulkLeasequeryClient) abortOn(ctx context.Context) (stop func()) {
+	if dl, ok := ctx.Deadline(); ok {
+		b.conn.SetDeadline(dl)
+	} else {
+		b.conn.SetDeadline(time.Time{})
+	}
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			b.conn.SetDeadline(time.Unix(1, 0))
+		case <-finished:
+		}
+	}()
+	return func() { close(finished) }
+}
+
+// writeTCPMessage writes msg preceded by its 2 byte length (RFC 5460, section
+// 5.2).
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > 0xffff {
+		return fmt.Errorf("message too large for TCP framing: %d bytes", len(msg))
+	}
+	b := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	_, err := w.Write(append(b, msg...))
+	return err
+}
+
+// readTCPMessage reads one length-prefixed message. io.EOF is returned only if
+// the connection was closed between messages.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/jpillora/backoff"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// SocketError is returned when sending or receiving a message failed for
+// reasons other than a timeout, e.g. because the interface disappeared.
+// Retrying the exchange is unlikely to succeed before the socket is reopened
+// (see Client.Reconnect).
+type SocketError struct {
+	Op  string // "read" or "write"
+	Err error
+}
+
+func (e *SocketError) Error() string {
+	return "dhcp6: " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *SocketError) Unwrap() error { return e.Err }
+
+// zoneIndex returns the interface index identified by zone (an interface
+// name or index), or ifindex if zone is empty. Unlike the net package, which
+// caches the mapping of interface names to indexes for up to 60s, names are
+// resolved on every call, so that interfaces which were just (re-)created
+// (e.g. veth interfaces in tests) are found.
+func zoneIndex(zone string, ifindex int) (int, error) {
+	if zone == "" {
+		return ifindex, nil
+	}
+	if idx, err := strconv.Atoi(zone); err == nil {
+		return idx, nil
+	}
+	iface, err := net.InterfaceByName(zone)
+	if err != nil {
+		return 0, err
+	}
+	return iface.Index, nil
+}
+
+// joinGroup explicitly joins the All_DHCP_Relay_Agents_and_Servers multicast
+// group (ff02::1:2) on the interface with index ifindex, instead of relying on
+// the interface being a member for other reasons. Some drivers and MLD
+// snooping switches only deliver messages sent to the group to members.
+func joinGroup(conn net.PacketConn, ifindex int) error {
+	iface, err := net.InterfaceByIndex(ifindex)
+	if err != nil {
+		return err
+	}
+	group := &net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers}
+	return ipv6.NewPacketConn(conn).JoinGroup(iface, group)
+}
+
+// tentativeTimeout bounds for how long listenUDP6Tentative retries binding to
+// a tentative address. Duplicate Address Detection takes about 1s with the
+// Linux defaults (1 probe, 1s retransmit timer).
+const tentativeTimeout = 5 * time.Second
+
+// listenUDP6Tentative is like listenUDP6, but retries with exponential backoff
+// for up to timeout while binding fails with EADDRNOTAVAIL, which is the case
+// while laddr is tentative, i.e. Duplicate Address Detection (RFC 4862,
+// section 5.4) has not yet completed after the interface came up.
+func listenUDP6Tentative(laddr *net.UDPAddr, ifindex int, timeout time.Duration) (net.PacketConn, error) {
+	return retryTentative(func() (net.PacketConn, error) {
+		return listenUDP6(laddr, ifindex)
+	}, timeout)
+}
+
+func retryTentative(listen func() (net.PacketConn, error), timeout time.Duration) (net.PacketConn, error) {
+	b := backoff.Backoff{
+		Factor: 2,
+		Min:    50 * time.Millisecond,
+		Max:    1 * time.Second,
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := listen()
+		if err == nil || !errors.Is(err, unix.EADDRNOTAVAIL) {
+			return conn, err
+		}
+		dur := b.Duration()
+		if time.Now().Add(dur).After(deadline) {
+			return nil, err
+		}
+		time.Sleep(dur)
+	}
+}
+
+// listen returns the client socket, bound to laddr, or to the unspecified
+// address if unspecified is true (see ClientConfig.BindUnspecified).
+func listen(laddr *net.UDPAddr, ifindex int, unspecified bool) (net.PacketConn, error) {
+	if unspecified {
+		return listenUnspecified(laddr.Port, ifindex, laddr.IP)
+	}
+	return listenUDP6Tentative(laddr, ifindex, tentativeTimeout)
+}
+
+// pktinfoConn is a socket bound to the unspecified address (::), which
+// receives messages addressed to any address of the host. It only returns
+// messages which arrived on the interface with index ifindex, as reported via
+// IPV6_PKTINFO, and sends on that interface: from src to multicast and
+// link-local destinations, and from an address selected per RFC 6724 (see
+// selectSource) to other destinations, e.g. a global Server Unicast address.
+type pktinfoConn struct {
+	*net.UDPConn
+	p       *ipv6.PacketConn
+	ifindex int
+	src     net.IP
+	addrs   func() ([]sourceAddr, error) // source address candidates
+}
+
+// listenUnspecified returns a pktinfoConn bound to port.
+func listenUnspecified(port, ifindex int, src net.IP) (net.PacketConn, error) {
+	conn, err := listenUDP6(&net.UDPAddr{IP: net.IPv6unspecified, Port: port}, ifindex)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected connection type %T", conn)
+	}
+	p := ipv6.NewPacketConn(udpConn)
+	if err := p.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &pktinfoConn{
+		UDPConn: udpConn,
+		p:       p,
+		ifindex: ifindex,
+		src:     src,
+		addrs:   func() ([]sourceAddr, error) { return interfaceAddrs(ifindex) },
+	}, nil
+}
+
+func (c *pktinfoConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, cm, addr, err := c.p.ReadFrom(b)
+		if err != nil || cm == nil || cm.IfIndex == c.ifindex {
+			return n, addr, err
+		}
+		// A message which arrived on another interface, e.g. for another
+		// client on the same port; reading continues until the deadline.
+	}
+}
+
+func (c *pktinfoConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.p.WriteTo(b, &ipv6.ControlMessage{Src: c.source(addr), IfIndex: c.ifindex}, addr)
+}
+
+// source returns the source address for messages to addr. The addresses of
+// the interface are looked up on every call, as they change with the leases,
+// and messages to non-link-local destinations are rare (e.g. unicast Renews).
+func (c *pktinfoConn) source(addr net.Addr) net.IP {
+	dst, ok := addr.(*net.UDPAddr)
+	if !ok || dst.IP.IsMulticast() || dst.IP.IsLinkLocalUnicast() {
+		return c.src
+	}
+	candidates, err := c.addrs()
+	if err != nil {
+		return c.src
+	}
+	if src := selectSource(dst.IP, candidates); src != nil {
+		return src
+	}
+	return c.src
+}
+
+// listenUDP6 is like net.ListenUDP("udp6", laddr), but uses ifindex as the
+// scope of a link-local laddr instead of resolving laddr.Zone via the net
+// package’s interface cache (see zoneIndex). Binding to a link-local address
+// also binds the socket to the interface, so that link-local destinations
+// (e.g. the All_DHCP_Relay_Agents_and_Servers multicast address) do not
+// require a zone. Multicast loopback is disabled, so that the client does not
+// receive its own multicast messages on interfaces which loop them back.
+func listenUDP6(laddr *net.UDPAddr, ifindex int) (net.PacketConn, error) {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.IPPROTO_UDP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "udp6 "+laddr.String())
+	defer f.Close() // net.FilePacketConn duplicates the file descriptor
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, 0); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	sa := &unix.SockaddrInet6{Port: laddr.Port}
+	copy(sa.Addr[:], laddr.IP.To16())
+	if laddr.IP.IsLinkLocalUnicast() {
+		sa.ZoneId = uint32(ifindex)
+	}
+	// SO_REUSEADDR is not set, so binding fails while another socket (e.g.
+	// a second client on the interface) uses the port.
+	if err := unix.Bind(fd, sa); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	return net.FilePacketConn(f)
+}
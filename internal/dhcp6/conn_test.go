@@ -0,0 +1,286 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+func TestListenUDP6(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	for _, zone := range []string{"", "lo", strconv.Itoa(lo.Index)} {
+		if got, err := zoneIndex(zone, lo.Index); err != nil || got != lo.Index {
+			t.Errorf("zoneIndex(%q) = %d, %v, want %d", zone, got, err, lo.Index)
+		}
+	}
+	if _, err := zoneIndex("nonexistent0", lo.Index); err == nil {
+		t.Errorf("zoneIndex(nonexistent0) unexpectedly succeeded")
+	}
+
+	conn, err := listenUDP6(&net.UDPAddr{IP: net.IPv6loopback}, lo.Index)
+	if err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo([]byte("ping"), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	buf := make([]byte, 4)
+	if n, _, err := conn.ReadFrom(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("ReadFrom() = %q, %v, want ping", buf[:n], err)
+	}
+	if loop, err := ipv6.NewPacketConn(conn).MulticastLoopback(); err != nil || loop {
+		t.Errorf("MulticastLoopback() = %v, %v, want false", loop, err)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if err := joinGroup(conn, iface.Index); err != nil {
+			t.Errorf("joinGroup(%s): %v", iface.Name, err)
+		}
+		break
+	}
+}
+
+func TestListenUnspecified(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	peer, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+	}
+	defer peer.Close()
+
+	for _, tt := range []struct {
+		name    string
+		ifindex int
+		want    bool
+	}{
+		{"arrival interface", lo.Index, true},
+		{"other interface", lo.Index + 1000, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := listenUnspecified(0, tt.ifindex, net.IPv6loopback)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// The socket is bound to ::, but receives messages to ::1.
+			port := conn.LocalAddr().(*net.UDPAddr).Port
+			if _, err := peer.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.IPv6loopback, Port: port}); err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			buf := make([]byte, 4)
+			n, _, err := conn.ReadFrom(buf)
+			if !tt.want {
+				if err == nil {
+					t.Fatalf("ReadFrom() = %q, want timeout for a message from another interface", buf[:n])
+				}
+				return
+			}
+			if err != nil || string(buf[:n]) != "ping" {
+				t.Fatalf("ReadFrom() = %q, %v, want ping", buf[:n], err)
+			}
+			if _, err := conn.WriteTo([]byte("pong"), peer.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			peer.SetReadDeadline(time.Now().Add(1 * time.Second))
+			n, from, err := peer.ReadFrom(buf)
+			if err != nil || string(buf[:n]) != "pong" {
+				t.Fatalf("ReadFrom() = %q, %v, want pong", buf[:n], err)
+			}
+			if got := from.(*net.UDPAddr).IP; !got.Equal(net.IPv6loopback) {
+				t.Errorf("unexpected source address: got %v, want %v", got, net.IPv6loopback)
+			}
+		})
+	}
+}
+
+func TestRetryTentative(t *testing.T) {
+	var attempts int
+	conn, err := retryTentative(func() (net.PacketConn, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, os.NewSyscallError("bind", unix.EADDRNOTAVAIL)
+		}
+		return dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}), nil
+	}, 1*time.Second)
+	if err != nil || conn == nil {
+		t.Fatalf("retryTentative() = %v, %v, want a connection", conn, err)
+	}
+	if got, want := attempts, 3; got != want {
+		t.Errorf("unexpected number of attempts: got %d, want %d", got, want)
+	}
+
+	// Other errors are returned immediately.
+	attempts = 0
+	if _, err := retryTentative(func() (net.PacketConn, error) {
+		attempts++
+		return nil, os.NewSyscallError("bind", unix.EADDRINUSE)
+	}, 1*time.Second); !errors.Is(err, unix.EADDRINUSE) || attempts != 1 {
+		t.Errorf("retryTentative() = %v after %d attempts, want EADDRINUSE after 1", err, attempts)
+	}
+
+	// The address stays tentative (e.g. DAD failed).
+	start := time.Now()
+	if _, err := retryTentative(func() (net.PacketConn, error) {
+		return nil, os.NewSyscallError("bind", unix.EADDRNOTAVAIL)
+	}, 200*time.Millisecond); !errors.Is(err, unix.EADDRNOTAVAIL) {
+		t.Errorf("retryTentative() = %v, want EADDRNOTAVAIL", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1*time.Second {
+		t.Errorf("retryTentative took %v, want at most the timeout", elapsed)
+	}
+}
+
+func TestSourcePort(t *testing.T) {
+	server, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+	}
+	defer server.Close()
+	handler := newTestServer(mustParseCIDR("2a02:168:4a00::/48"))
+	go func() {
+		buf := make([]byte, maxUDPReceivedPacketSize)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg, err := dhcpv6.MessageFromBytes(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, reply := range handler.Respond(msg) {
+				server.WriteTo(reply.ToBytes(), addr)
+			}
+		}
+	}()
+
+	const sourcePort = 10546
+	c, err := NewClient(ClientConfig{
+		InterfaceName: "lo",
+		LocalAddr:     &net.UDPAddr{IP: net.IPv6loopback, Port: dhcpv6.DefaultClientPort},
+		SourcePort:    sourcePort,
+		RemoteAddr:    server.LocalAddr().(*net.UDPAddr),
+		HardwareAddr:  []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.Conn.LocalAddr().(*net.UDPAddr).Port; got != sourcePort {
+		t.Errorf("unexpected source port: got %d, want %d", got, sourcePort)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.ObtainOrRenewErr(ctx); err != nil {
+		t.Fatalf("ObtainOrRenewErr: %v", err)
+	}
+
+	if _, err := NewClient(ClientConfig{InterfaceName: "lo", SourcePort: 65536}); err == nil {
+		t.Errorf("NewClient unexpectedly accepted SourcePort 65536")
+	}
+}
+
+func TestInterfaceIndex(t *testing.T) {
+	laddr, err := net.ResolveUDPAddr("udp6", "[fe80::42:aff:fea5:966e]:546")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	cfg := ClientConfig{
+		// Neither the name nor the index exist in this network namespace.
+		InterfaceName:  "wan0",
+		InterfaceIndex: 4242,
+		LocalAddr:      laddr,
+		HardwareAddr:   []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		Conn:           newTestServer(prefix),
+	}
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, got.Prefixes); diff != "" {
+		t.Errorf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	if got, want := c.zone, "4242"; got != want {
+		t.Errorf("unexpected zone: got %q, want %q", got, want)
+	}
+
+	noLocalAddr := cfg
+	noLocalAddr.LocalAddr = nil
+	if _, err := NewClient(noLocalAddr); err == nil {
+		t.Errorf("NewClient without LocalAddr unexpectedly succeeded")
+	}
+}
+
+// failingConn is a dhcp6test.Server whose writes fail with err.
+type failingConn struct {
+	*dhcp6test.Server
+	err error
+}
+
+func (fc *failingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return 0, fc.err
+}
+
+func TestSocketError(t *testing.T) {
+	errUnreachable := errors.New("network is unreachable")
+	c := newTestClient(t, &failingConn{
+		Server: newTestServer(mustParseCIDR("2a02:168:4a00::/48")),
+		err:    errUnreachable,
+	})
+	_, err := c.ObtainOrRenewErr(context.Background())
+	var se *SocketError
+	if !errors.As(err, &se) || se.Op != "write" {
+		t.Fatalf("ObtainOrRenewErr() = %v, want *SocketError", err)
+	}
+	if !errors.Is(err, errUnreachable) {
+		t.Errorf("errors.Is(%v, %v) = false, want true", err, errUnreachable)
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Errorf("errors.Is(%v, ErrTimeout) = true, want false", err)
+	}
+}
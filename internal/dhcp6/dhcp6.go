@@ -16,28 +16,80 @@
 package dhcp6
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"math/rand"
 	"net"
+	"os"
+	"reflect"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/client6"
 	"github.com/insomniacslk/dhcp/iana"
+	"github.com/jpillora/backoff"
+	"github.com/vishvananda/netlink"
 )
 
 type ClientConfig struct {
 	InterfaceName string // e.g. eth0
 
+	// InterfaceIndex, if non-zero, identifies the interface instead of
+	// InterfaceName, which is then only used in log messages. The interface
+	// is not looked up by name, so that it may reside in another network
+	// namespace than the calling process or be enslaved to a VRF, in which
+	// case the caller creates the socket in the right context (see Conn).
+	// LocalAddr and HardwareAddr must be specified, as they cannot be
+	// determined without looking up the interface, and the zone of LocalAddr
+	// should be empty or numeric. WatchLink only works for
+	// interfaces in the network namespace of the calling process.
+	InterfaceIndex int
+
 	// LocalAddr allows overwriting the source address used for sending DHCPv6
 	// packets. It defaults to the first link-local address of InterfaceName.
 	LocalAddr *net.UDPAddr
 
+	// SourcePort, if non-zero, overrides the port of LocalAddr, which
+	// defaults to the DHCPv6 client port 546. Binding to an unprivileged port
+	// allows running the client without CAP_NET_BIND_SERVICE and next to
+	// another DHCPv6 client, provided the server replies to the source port
+	// of the client's messages (as opposed to port 546). Replies are matched
+	// by transaction ID only, regardless of the port they were sent from.
+	SourcePort int
+
+	// BindUnspecified binds the socket created by NewClient to the
+	// unspecified address (::) instead of LocalAddr. Some servers reply to a
+	// global address of the interface rather than to the link-local source
+	// address of the client's messages; such replies never reach a socket
+	// bound to the link-local address, i.e. the Advertise seems lost. With
+	// BindUnspecified, replies to any address are received, messages which
+	// arrived on other interfaces are discarded (as identified via
+	// IPV6_PKTINFO), and multicast messages are still sent from LocalAddr.
+	// Messages to a global or unique local server address (see RemoteAddr and
+	// the Server Unicast option) are sent from the most appropriate address of
+	// the interface (RFC 6724 source address selection, preferring stable over
+	// temporary addresses), as some servers reject unicast messages from a
+	// link-local source. A socket bound to LocalAddr always sends from
+	// LocalAddr, as replies to other addresses would not reach it.
+	BindUnspecified bool
+
 	// RemoteAddr allows addressing a specific DHCPv6 server. It defaults to
 	// the dhcpv6.AllDHCPRelayAgentsAndServers multicast address.
 	RemoteAddr *net.UDPAddr
 
+	// PreferServerID, if non-nil, contains the DUID (in the same format as
+	// DUID) of the server to obtain leases from on links with multiple
+	// servers. An Advertise from this server is selected as soon as it
+	// arrives, regardless of the Preference option of other Advertises
+	// (which then no longer end the selection early). If the preferred
+	// server does not answer during the first retransmission timeout of the
+	// Solicit, the Advertise is selected by preference as usual.
+	PreferServerID []byte
+
 	// DUID contains all bytes (including the prefixing uint16 type field) for a
 	// DHCP Unique Identifier (e.g. []byte{0x00, 0x0a, 0x00, 0x03, 0x00, 0x01,
 	// 0x4c, 0x5e, 0xc, 0x41, 0xbf, 0x39}).
@@ -46,8 +98,224 @@ type ClientConfig struct {
 	// be able to carry it around between devices.
 	DUID []byte
 
-	Conn           net.PacketConn         // for testing
-	TransactionIDs []dhcpv6.TransactionID // for testing
+	// DUIDType selects the type of DUID to generate if DUID is nil. It
+	// defaults to dhcpv6.DUID_LL, which is derived from the hardware address
+	// only and hence stable, unlike the timestamp-based dhcpv6.DUID_LLT.
+	// dhcpv6.DUID_EN requires DUIDEnterpriseNumber and DUIDIdentifier,
+	// dhcpv6.DUID_UUID requires DUIDIdentifier to contain a 16 byte UUID.
+	DUIDType             dhcpv6.DuidType
+	DUIDEnterpriseNumber uint32
+	DUIDIdentifier       []byte
+
+	// DUIDPath, if non-empty and DUID is nil, is the path of a file storing
+	// the DUID (in wire format), e.g. /perm/dhcp6/duid. If the file does not
+	// exist, a DUID is generated (see DUIDType) and written to the file, so
+	// that it remains the same across restarts.
+	DUIDPath string
+
+	// DUIDImportPath, if non-empty and DUID is nil, is the path of a DUID
+	// file written by another DHCPv6 client in DUIDImportFormat, e.g. the
+	// dhclient lease file of the system router7 replaces. Its DUID is used
+	// instead of generating one (and written to DUIDPath, if it does not
+	// exist yet), so that servers keep delegating the same prefix.
+	DUIDImportPath   string
+	DUIDImportFormat DUIDFormat
+
+	// LeasePath, if non-empty, is the path of a file in which the current
+	// lease is saved (see SaveLease). When obtaining the first lease, a valid
+	// saved lease is renewed (or rebound) before falling back to Solicit, so
+	// that restarts keep the delegated prefix.
+	LeasePath string
+
+	// RapidCommit requests the two-message exchange of RFC 8415, section
+	// 18.2.1: servers which support it reply to the Solicit with a Reply
+	// directly, skipping the Advertise/Request round trip.
+	RapidCommit bool
+
+	// DisableIANA omits the IA_NA option from the Solicit, i.e. no address
+	// for the WAN interface itself is requested (only prefixes via IA_PD).
+	DisableIANA bool
+
+	// DisableIAPD omits the IA_PD option from the Solicit, i.e. only an
+	// address for the WAN interface is requested (via IA_NA), e.g. for
+	// uplinks without prefix delegation. It must not be combined with
+	// DisableIANA or IAPDs.
+	DisableIAPD bool
+
+	// IAID is the 4-byte identity association identifier of the IA_PD. It
+	// defaults to the last 4 bytes of the hardware address, which is stable
+	// across reboots. Servers may key delegations on DUID and IAID, so changing
+	// the IAID may change the delegated prefix.
+	IAID []byte
+
+	// IAPDs is the number of IA_PD options (i.e. separate prefix delegations)
+	// to request. It defaults to 1. The IAIDs of additional IA_PDs are
+	// consecutive, starting at IAID.
+	IAPDs int
+
+	// PrefixLength, if non-zero, is sent as a prefix-length hint in each IA_PD
+	// of the Solicit (RFC 8415, section 18.2.1), e.g. 48 to ask for a /48.
+	// Servers may ignore the hint; differing prefixes are accepted.
+	PrefixLength int
+
+	// HintPrefixes includes the prefixes of the most recent lease as hints in
+	// the IA_PDs of the Solicit (instead of the PrefixLength hint), so that
+	// servers which honor hints delegate the same prefixes again, e.g. after
+	// the lease expired during a long outage. After a restart, the most
+	// recent lease is the one saved in LeasePath, even if it expired. The
+	// prefixes of a released lease are not hinted.
+	HintPrefixes bool
+
+	// RenewJitter, if non-zero, delays Config.RenewAfter by a random fraction
+	// of up to RenewJitter of the time between T1 and T2, so that clients which
+	// obtained their leases at the same time (e.g. after a power outage) do not
+	// renew in lockstep. It must be within [0, 1).
+	RenewJitter float64
+
+	// MaxDuration, if non-zero, bounds the Solicit/Request exchange by
+	// which ObtainOrRenewErr (and Reconnect) obtain a new lease. Once it
+	// elapses, the exchange fails with a *TimeoutError (errors.Is(err,
+	// ErrTimeout)) instead of retransmitting the Solicit indefinitely, e.g.
+	// to report that no DHCPv6 server answers on the uplink.
+	MaxDuration time.Duration
+
+	// Retransmission replaces the retransmission parameters of the specified
+	// message types, which default to the values of RFC 8415, section 7.6,
+	// e.g. {IRT: time.Second, MRC: 2} for Request to fail fast. IRT must be
+	// positive; a zero MRT, MRC or MRD means that the timeout, the number of
+	// transmissions or the duration is unbounded. SOL_MAX_RT and INF_MAX_RT
+	// options received from the server still replace the MRT of Solicit and
+	// Information-Request.
+	Retransmission map[dhcpv6.MessageType]Retransmission
+
+	// ORO contains the option codes to request from the server via the
+	// Option Request Option. It defaults to DNS servers, domain search list,
+	// SNTP and NTP servers, Prefix Exclude, SOL_MAX_RT and the Boot File URL
+	// and Parameters.
+	ORO []dhcpv6.OptionCode
+
+	// VendorClass, if non-nil, is sent in the Solicit and Request (RFC 8415,
+	// section 21.16). Some providers delegate larger prefixes to specific CPE
+	// vendors (identified by their enterprise number and class data).
+	VendorClass *dhcpv6.OptVendorClass
+
+	// UserClass contains opaque user classes (e.g. []byte("router7")) to send
+	// in the User Class option (RFC 8415, section 21.15) of the Solicit and
+	// Request.
+	UserClass [][]byte
+
+	// VendorOpts are sent in Vendor-specific Information options (RFC 8415,
+	// section 21.17), one per enterprise number.
+	VendorOpts []VendorOption
+
+	// FQDN, if non-empty, is sent in the Client FQDN option (RFC 4704) of the
+	// Solicit, Request, Renew and Rebind, so that the server can register it
+	// in the DNS. A single label (e.g. router7) is completed by the server with
+	// its domain. FQDNFlags (e.g. FQDNFlagS) control which updates the server
+	// performs.
+	FQDN      string
+	FQDNFlags uint8
+
+	// AuthKey, if non-nil, is the key shared with the server for
+	// authenticating its messages via the HMAC-MD5 digest of delayed
+	// authentication in the Authentication option (RFC 3315, section 21.4).
+	// Replies and Reconfigure messages which lack a valid digest are dropped,
+	// except for Replies to Solicit and Request which carry the reconfigure
+	// key (RFC 8415, section 20.4.1). Reconfigure messages may also be
+	// authenticated with the reconfigure key the server sent, which is the
+	// only authentication if AuthKey is nil.
+	AuthKey []byte
+
+	// AcceptReconfigure announces that the client is willing to accept
+	// Reconfigure messages (RFC 8415, section 18.2.11), for which Listen must
+	// be called.
+	AcceptReconfigure bool
+
+	// Relay, if non-nil, makes the client operate across a relay hop: all
+	// messages are sent wrapped in Relay-Forward messages, and only messages
+	// relayed in Relay-Reply messages are accepted. As servers send Relay-Reply
+	// messages to the relay port, LocalAddr defaults to port 547 in this mode.
+	Relay *RelayConfig
+
+	// WatchLink subscribes to link state changes of InterfaceName via
+	// netlink, so that the client can recover when the link comes back up
+	// after a modem reboot: see Client.LinkUp and Client.Reconnect.
+	WatchLink bool
+
+	// ReceiveBufferSize is the size of the largest message the client
+	// accepts, within [0, 65535]. It defaults to 65535 bytes, the largest UDP
+	// payload. Larger messages are discarded with a log message, as they
+	// could only be read truncated.
+	ReceiveBufferSize int
+
+	// Logger receives the client's log messages. It defaults to
+	// StdLogger(nil, false), i.e. the standard logger without debug messages.
+	Logger Logger
+
+	// OnConfigChange, if non-nil, is called with the new configuration when
+	// ObtainOrRenewErr, Renew, Rebind or Listen result in a configuration
+	// which differs from the previous one in more than its timers (e.g. a new
+	// prefix was delegated, or the DNS servers changed). When the lease is
+	// discarded (Release, or expiry before OnExpired), it is called with the
+	// empty Config. Like OnExpired, it is called synchronously by the
+	// goroutine which called the client method.
+	OnConfigChange func(Config)
+
+	// OnLease, if non-nil, is called with the configuration of every lease
+	// which was obtained or extended, including renewals which only move the
+	// lifetimes forward (e.g. to persist the lease with its current
+	// lifetimes). It is called before OnConfigChange.
+	OnLease func(Config)
+
+	// OnExpired, if non-nil, is called when an exchange fails after the valid
+	// lifetimes of all bound IAs expired. The lease is discarded, so the
+	// caller should obtain a new one via ObtainOrRenewErr.
+	OnExpired func()
+
+	// OnPrefixChange, if non-nil, is called with the previously and the newly
+	// delegated prefixes when a lease delegates different prefixes than the
+	// previous lease (e.g. so that the caller deprecates the old prefixes on
+	// downstream links), but not when the lifetimes are merely extended. The
+	// previous lease is the last one obtained, even if it expired since. It
+	// is not called for the first lease, or for the first lease after
+	// Release. It is called after OnConfigChange.
+	OnPrefixChange func(old, new []net.IPNet)
+
+	// AddressInstaller, if non-nil, is called to assign the addresses of
+	// each lease (IA_NA) with their current lifetimes, e.g. a
+	// NetlinkAddressInstaller. Addresses which are no longer part of the
+	// lease (e.g. after renumbering), expired or released are removed.
+	AddressInstaller AddressInstaller
+
+	// Conn, if non-nil, is used instead of a socket created by NewClient,
+	// e.g. a dhcp6test.Server for testing, or a socket created in another
+	// network namespace (see InterfaceIndex). Conn must receive the messages
+	// sent to LocalAddr: a UDP socket must be bound to the client port (546,
+	// unless SourcePort is set) and scoped to the interface, and have joined
+	// ff02::1:2 on the interface for Solicits to be answered by some
+	// servers. Conn must support read deadlines and sending to a
+	// *net.UDPAddr. It is closed by Close and not recreated by Reconnect.
+	Conn net.PacketConn
+
+	// TransactionIDs, if non-nil, replaces the random transaction IDs (for
+	// testing, e.g. replaying a packet capture). One ID is used per exchange
+	// (e.g. a Solicit and all of its retransmissions), in order. Once all IDs
+	// were used, further exchanges fail instead of using a random ID, which
+	// would not match the expected replies.
+	TransactionIDs []dhcpv6.TransactionID
+
+	// TransactionIDFunc, if non-nil, is called to generate the transaction ID
+	// of each exchange instead of using a random ID, e.g. to make the IDs
+	// recognizable in packet captures. Errors fail the exchange. It must not
+	// be combined with TransactionIDs.
+	TransactionIDFunc func() (dhcpv6.TransactionID, error)
+
+	// Clock, if non-nil, replaces the system clock for lease timers (e.g.
+	// Config.RenewAfter) and retransmission timers (for testing). As the
+	// retransmission timers are implemented via read deadlines, Conn must
+	// evaluate them against the same clock, like a dhcp6test.Server created
+	// with a dhcp6test.Clock does.
+	Clock Clock
 
 	// HardwareAddr allows overriding the hardware address in tests. If nil,
 	// defaults to the hardware address of the interface identified by
@@ -55,26 +323,454 @@ type ClientConfig struct {
 	HardwareAddr net.HardwareAddr
 }
 
+// Clock is a source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Flags of the Client FQDN option (RFC 4704, section 4.1).
+const (
+	FQDNFlagS = 1 << 0 // the server should update the AAAA record
+	FQDNFlagO = 1 << 1 // set by the server: it overrode the S flag
+	FQDNFlagN = 1 << 2 // the server should not perform any DNS updates
+)
+
+// VendorOption is a sub-option of a Vendor-specific Information option.
+type VendorOption struct {
+	EnterpriseNumber uint32 `json:"enterprise_number"`
+	Code             uint16 `json:"code"`
+	Data             []byte `json:"data"`
+}
+
+// vendorOptsFromConfig groups opts into one Vendor-specific Information option
+// per enterprise number.
+func vendorOptsFromConfig(opts []VendorOption) []*dhcpv6.OptVendorOpts {
+	var result []*dhcpv6.OptVendorOpts
+	byEnterprise := make(map[uint32]*dhcpv6.OptVendorOpts)
+	for _, opt := range opts {
+		vo, ok := byEnterprise[opt.EnterpriseNumber]
+		if !ok {
+			vo = &dhcpv6.OptVendorOpts{EnterpriseNumber: opt.EnterpriseNumber}
+			byEnterprise[opt.EnterpriseNumber] = vo
+			result = append(result, vo)
+		}
+		vo.VendorOpts.Add(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionCode(opt.Code),
+			OptionData: opt.Data,
+		})
+	}
+	return result
+}
+
+// Delegation contains the prefixes delegated in one IA_PD.
+type Delegation struct {
+	IAID     [4]byte     `json:"iaid"`
+	Prefixes []net.IPNet `json:"prefixes"` // e.g. 2a02:168:4a00::/56
+}
+
+// Lease describes one address (IA_NA) or delegated prefix (IA_PD) together
+// with the lifetimes the server assigned.
+type Lease struct {
+	Prefix            net.IPNet     `json:"prefix"` // addresses have a /128 mask
+	PreferredLifetime time.Duration `json:"preferred_lifetime"`
+	ValidLifetime     time.Duration `json:"valid_lifetime"`
+
+	// PreferredUntil and ValidUntil are the absolute times at which the
+	// lifetimes expire. Addresses derived from Prefix should be deprecated
+	// after PreferredUntil, and must no longer be used after ValidUntil
+	// (RFC 8415, section 21.22). They are zero if the respective lifetime is
+	// Infinity, i.e. never expires.
+	PreferredUntil time.Time `json:"preferred_until"`
+	ValidUntil     time.Time `json:"valid_until"`
+}
+
+// newLease returns a Lease for prefix with the specified lifetimes, starting
+// at now.
+func newLease(prefix net.IPNet, preferred, valid time.Duration, now time.Time) Lease {
+	return Lease{
+		Prefix:            prefix,
+		PreferredLifetime: preferred,
+		ValidLifetime:     valid,
+		PreferredUntil:    expiry(now, preferred),
+		ValidUntil:        expiry(now, valid),
+	}
+}
+
+// expiry returns when lifetime, starting at now, expires, or the zero time if
+// lifetime is Infinity.
+func expiry(now time.Time, lifetime time.Duration) time.Time {
+	if lifetime == Infinity {
+		return time.Time{}
+	}
+	return now.Add(lifetime)
+}
+
+// LeaseState is the state of a Lease at a specific time (RFC 4862, section
+// 5.5.4).
+type LeaseState int
+
+const (
+	LeasePreferred  LeaseState = iota // may be used for new communication
+	LeaseDeprecated                   // valid, but past its preferred lifetime
+	LeaseExpired                      // past its valid lifetime (or withdrawn)
+)
+
+func (s LeaseState) String() string {
+	switch s {
+	case LeasePreferred:
+		return "preferred"
+	case LeaseDeprecated:
+		return "deprecated"
+	case LeaseExpired:
+		return "expired"
+	}
+	return fmt.Sprintf("unknown lease state %d", int(s))
+}
+
+// LeaseStatus is a Lease as of a specific time, e.g. for advertising a
+// delegated prefix downstream with its remaining lifetimes.
+type LeaseStatus struct {
+	Prefix net.IPNet
+	// Preferred and Valid are the remaining lifetimes: Infinity for
+	// infinite lifetimes, 0 once expired.
+	Preferred, Valid time.Duration
+	State            LeaseState
+}
+
+// Status returns the state and remaining lifetimes of l at now.
+func (l Lease) Status(now time.Time) LeaseStatus {
+	remaining := func(lifetime time.Duration, until time.Time) time.Duration {
+		switch {
+		case lifetime == Infinity:
+			return Infinity
+		case !until.After(now):
+			return 0
+		}
+		return until.Sub(now)
+	}
+	st := LeaseStatus{
+		Prefix:    l.Prefix,
+		Preferred: remaining(l.PreferredLifetime, l.PreferredUntil),
+		Valid:     remaining(l.ValidLifetime, l.ValidUntil),
+	}
+	if st.Preferred > st.Valid {
+		st.Preferred = st.Valid
+	}
+	switch {
+	case st.Valid == 0:
+		st.State = LeaseExpired
+	case st.Preferred == 0:
+		st.State = LeaseDeprecated
+	}
+	return st
+}
+
+// PrefixLeases returns the status of the delegated prefixes (Prefixes) at
+// now, in the order of Leases.
+func (c Config) PrefixLeases(now time.Time) []LeaseStatus {
+	return c.leaseStatus(now, c.Prefixes)
+}
+
+// AddressLeases returns the status of the assigned addresses (Addresses) at
+// now, in the order of Leases.
+func (c Config) AddressLeases(now time.Time) []LeaseStatus {
+	return c.leaseStatus(now, c.Addresses)
+}
+
+func (c Config) leaseStatus(now time.Time, networks []net.IPNet) []LeaseStatus {
+	keys := make(map[string]bool, len(networks))
+	for _, n := range networks {
+		keys[networkKey(n)] = true
+	}
+	var result []LeaseStatus
+	for _, l := range c.Leases {
+		if keys[networkKey(l.Prefix)] {
+			result = append(result, l.Status(now))
+		}
+	}
+	return result
+}
+
+// Infinity is the lifetime (and T1/T2) value 0xffffffff, which RFC 8415,
+// section 7.7 defines as infinite. Lease lifetimes and Config.T1/T2 are
+// compared against it to find infinite values, e.g. to advertise the prefix
+// with an infinite lifetime downstream.
+const Infinity = 0xffffffff * time.Second
+
+// iaTimers returns the T1 and T2 to use for an IA with the specified leases,
+// given the T1 and T2 sent by the server (RFC 8415, sections 14.2 and 21.4):
+//
+// A T1 or T2 of 0 leaves the choice to the client, which uses 0.5 and 0.8
+// times the shortest preferred lifetime, respectively. Values which exceed
+// the shortest valid lifetime would only renew after the first lease expired,
+// so they are derived from the valid lifetime instead. Finally, T1 is clamped
+// to T2. Infinite values are retained if all lifetimes are infinite. Leases
+// with a valid lifetime of 0, which the server withdraws (e.g. after
+// renumbering), are disregarded unless all leases are withdrawn.
+func iaTimers(t1, t2 time.Duration, leases []Lease) (time.Duration, time.Duration) {
+	var current []Lease
+	for _, l := range leases {
+		if l.ValidLifetime > 0 {
+			current = append(current, l)
+		}
+	}
+	if len(current) > 0 {
+		leases = current
+	}
+	if len(leases) == 0 {
+		return t1, t2
+	}
+	preferred, valid := leases[0].PreferredLifetime, leases[0].ValidLifetime
+	for _, l := range leases[1:] {
+		if l.PreferredLifetime < preferred {
+			preferred = l.PreferredLifetime
+		}
+		if l.ValidLifetime < valid {
+			valid = l.ValidLifetime
+		}
+	}
+	scale := func(d time.Duration, num, denom int64) time.Duration {
+		if d == Infinity {
+			return Infinity
+		}
+		return d / time.Duration(denom) * time.Duration(num)
+	}
+	if preferred == 0 || preferred > valid {
+		// A deprecated (or inconsistent) lease: base the timers on the
+		// valid lifetime, so that the client does not renew in a loop.
+		preferred = valid
+	}
+	if t1 == 0 {
+		t1 = scale(preferred, 1, 2)
+	}
+	if t2 == 0 {
+		t2 = scale(preferred, 4, 5)
+	}
+	if t1 > valid {
+		t1 = scale(valid, 1, 2)
+	}
+	if t2 > valid {
+		t2 = scale(valid, 4, 5)
+	}
+	if t1 > t2 {
+		t1 = t2
+	}
+	return t1, t2
+}
+
 // Config contains the obtained network configuration.
 type Config struct {
-	RenewAfter time.Time   `json:"valid_until"`
-	Prefixes   []net.IPNet `json:"prefixes"` // e.g. 2a02:168:4a00::/48
-	DNS        []string    `json:"dns"`      // e.g. 2001:1620:2777:1::10, 2001:1620:2777:2::20
+	RenewAfter  time.Time `json:"valid_until"`  // T1: Renew with the granting server
+	RebindAfter time.Time `json:"rebind_after"` // T2: Rebind with any server
+
+	// T1 and T2 are the shortest T1 and T2 of all bound IAs, as sent by the
+	// server or, where the server left them to the client or sent
+	// inconsistent values, as derived by iaTimers. RenewAfter and RebindAfter
+	// are derived from them.
+	T1 time.Duration `json:"t1"`
+	T2 time.Duration `json:"t2"`
+
+	// Infinite is true if T1 (and hence T2) is Infinity: the lease never
+	// needs to be renewed. RenewAfter and RebindAfter then lie 136 years in
+	// the future and should not be interpreted; they are retained so that
+	// consumers which only compare against them keep working.
+	Infinite bool `json:"infinite"`
+
+	// ServerID is the DUID of the server which granted the lease, as sent in
+	// its Server Identifier option.
+	ServerID []byte `json:"server_id"`
+
+	// Leases contains all Addresses and Prefixes with their lifetimes. See
+	// PrefixLeases and AddressLeases for their state at a specific time.
+	Leases []Lease `json:"leases"`
+
+	Prefixes  []net.IPNet `json:"prefixes"`  // e.g. 2a02:168:4a00::/48 (all delegations)
+	Addresses []net.IPNet `json:"addresses"` // e.g. 2a02:168:2000:5::1f/128
+	DNS       []string    `json:"dns"`       // e.g. 2001:1620:2777:1::10, 2001:1620:2777:2::20
+
+	// DomainSearch contains the domain search list (RFC 3646), e.g. init7.net.
+	DomainSearch []string `json:"domain_search"`
+
+	// NTP contains the time sources from the SNTP Servers (RFC 4075) and NTP
+	// Server (RFC 5908) options: IPv6 unicast or multicast addresses, or
+	// FQDNs, e.g. ntp.init7.net.
+	NTP []string `json:"ntp"`
+
+	// VendorOpts contains the sub-options of all Vendor-specific Information
+	// options the server sent.
+	VendorOpts []VendorOption `json:"vendor_opts"`
+
+	// FQDN and FQDNFlags contain the Client FQDN option the server sent, i.e.
+	// the name it registered (or will register) in the DNS for the client.
+	FQDN      string `json:"fqdn"`
+	FQDNFlags uint8  `json:"fqdn_flags"`
+
+	// BootFileURL and BootFileParams contain the Boot File URL and Boot File
+	// Parameters options (RFC 5970), with which servers point network-booted
+	// devices to their image, e.g. tftp://[2001:db8::1]/router7.img. Most
+	// servers do not send them.
+	BootFileURL    string   `json:"boot_file_url"`
+	BootFileParams []string `json:"boot_file_params"`
+
+	// InformationRefreshTime is set by InformationRequest to the Information
+	// Refresh Time (RFC 8415, section 21.23, originally RFC 4242, whose
+	// drafts called it the Lifetime option) or its default, IRT_DEFAULT. It
+	// determines RenewAfter. Option code 52, which is sometimes mistaken for
+	// a lifetime, is the CAPWAP Access Controller option (RFC 5417) and is
+	// not interpreted.
+	InformationRefreshTime time.Duration `json:"information_refresh_time"`
+
+	// Delegations contains Prefixes, grouped by the IA_PD they were delegated
+	// in.
+	Delegations []Delegation `json:"delegations"`
+
+	// Excluded contains sub-prefixes of Prefixes which the server excluded
+	// from the delegation via the Prefix Exclude option (RFC 6603), e.g.
+	// because the /64 is used on the WAN link. They must not be assigned
+	// downstream.
+	Excluded []net.IPNet `json:"excluded"`
+
+	// Transition is the exchange which last bound or confirmed the lease, at
+	// TransitionAt. It explains prefix changes: a Solicit can yield a
+	// different prefix than a Renew.
+	Transition   Transition `json:"transition"`
+	TransitionAt time.Time  `json:"transition_at"`
+}
+
+// Transition identifies the exchange by which a lease was bound.
+type Transition int
+
+const (
+	TransitionNone    Transition = iota
+	TransitionSolicit            // a new lease via Solicit (and Request)
+	TransitionRenew
+	TransitionRebind
+	TransitionConfirm
+	// TransitionLoad is set for leases loaded from ClientConfig.LeasePath;
+	// Config.TransitionAt is the time at which the saved Reply was received.
+	TransitionLoad
+	// TransitionRequest is set for leases reinstated via Request after the
+	// server replied NoBinding to a Renew (see Client.Renew).
+	TransitionRequest
+)
+
+var transitionNames = map[Transition]string{
+	TransitionNone:    "",
+	TransitionSolicit: "solicit",
+	TransitionRenew:   "renew",
+	TransitionRebind:  "rebind",
+	TransitionConfirm: "confirm",
+	TransitionLoad:    "load",
+	TransitionRequest: "request",
+}
+
+func (t Transition) String() string {
+	if name, ok := transitionNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown transition %d", int(t))
+}
+
+// MarshalText encodes t by name, for readable lease files.
+func (t Transition) MarshalText() ([]byte, error) {
+	name, ok := transitionNames[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown transition %d", int(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes a name produced by MarshalText.
+func (t *Transition) UnmarshalText(b []byte) error {
+	for tr, name := range transitionNames {
+		if name == string(b) {
+			*t = tr
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown transition %q", b)
 }
 
 type Client struct {
 	interfaceName string
+	ifindex       int
+	zone          string // of link-local destinations
 	hardwareAddr  net.HardwareAddr
 	raddr         *net.UDPAddr
 	timeNow       func() time.Time
+	timerNow      func() time.Time // of the retransmission timers (read deadlines)
 	duid          *dhcpv6.Duid
+	preferServer  *dhcpv6.Duid // see ClientConfig.PreferServerID
+	ephemeralDUID bool         // generated DUID-LLT which is not persisted
 	advertise     *dhcpv6.Message
+	reply         *dhcpv6.Message // containing the currently bound IAs
+	boundAt       time.Time       // when reply was received
+	unicast       net.IP          // from the Server Unicast option of reply
+	validUntil    time.Time       // of the longest-lived bound IA
+	leasePath     string
+	rapidCommit   bool
+	disableIANA   bool
+	iaids         [][4]byte // of the requested IA_PDs, empty if ClientConfig.DisableIAPD
+	prefixLength  int
+	hintPrefixes  bool
+	prefixHints   map[[4]byte][]net.IPNet // by IAID, see ClientConfig.HintPrefixes
+	renewJitter   float64
+	maxDuration   time.Duration
+	retryBackoff  backoff.Backoff // for Run
+
+	oro         []dhcpv6.OptionCode
+	vendorClass *dhcpv6.OptVendorClass
+	vendorOpts  []*dhcpv6.OptVendorOpts
+	userClass   *dhcpv6.OptUserClass
+	fqdn        *dhcpv6.OptionGeneric
+
+	acceptReconfigure bool
+	authKey           []byte
+	reconfigureKey    []byte // sent by the server, see RFC 8415, section 20.4
+	replayDetection   uint64 // last seen replay detection value
 
-	cfg Config
-	err error
+	relay *RelayConfig // nil unless operating across a relay hop
+	// receivedInterfaceID is the Interface-ID of the Relay-Reply which
+	// carried the message most recently returned by receive.
+	receivedInterfaceID []byte
 
-	Conn           net.PacketConn // TODO: unexport
-	transactionIDs []dhcpv6.TransactionID
+	// mu guards the fields which are read by Config, Err,
+	// LastAdvertiseOptions, LastReplyOptions, LastExchange and
+	// AcquisitionDuration, which may be called concurrently with the
+	// exchanges.
+	mu            sync.Mutex
+	cfg           Config
+	err           error
+	lastAdvertise *dhcpv6.Message
+	lastReply     *dhcpv6.Message
+	lastExchange  Exchange
+	acquisition   time.Duration // of the most recently obtained lease
+
+	log  Logger
+	prom metrics
+
+	onConfigChange func(Config)
+	onLease        func(Config)
+	onExpired      func()
+	onPrefixChange func(old, new []net.IPNet)
+	lastPrefixes   []net.IPNet // of the last lease, nil before the first one
+	installer      AddressInstaller
+	installed      map[string]net.IPNet // addresses passed to installer, by networkKey
+
+	Conn            net.PacketConn // TODO: unexport
+	ownConn         bool           // whether Conn was created by NewClient
+	laddr           *net.UDPAddr   // to which Conn is bound, if ownConn
+	scope           int            // interface index of laddr
+	laddrConfigured bool           // whether laddr was ClientConfig.LocalAddr
+	bindUnspecified bool           // see ClientConfig.BindUnspecified
+	linkUp          chan struct{}  // if ClientConfig.WatchLink
+	linkDone        chan struct{}  // closed by Close to stop link watching
+	forceRenew      chan struct{}  // see ForceRenew
+	rcvbufSize      int            // see ClientConfig.ReceiveBufferSize
+	transactionIDs  []dhcpv6.TransactionID
+	newXID          func() (dhcpv6.TransactionID, error) // see ClientConfig.TransactionIDFunc
+	retransmission  map[dhcpv6.MessageType]Retransmission
+	randFloat64     func() float64
 
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
@@ -83,7 +779,7 @@ type Client struct {
 }
 
 func NewClient(cfg ClientConfig) (*Client, error) {
-	iface, err := net.InterfaceByName(cfg.InterfaceName)
+	iface, err := clientInterface(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -91,20 +787,32 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 	// if no LocalAddr is specified, get the interface's link-local address
 	laddr := cfg.LocalAddr
 	if laddr == nil {
+		port := dhcpv6.DefaultClientPort
+		if cfg.Relay != nil {
+			port = dhcpv6.DefaultServerPort
+		}
 		llAddr, err := dhcpv6.GetLinkLocalAddr(cfg.InterfaceName)
 		if err != nil {
 			return nil, err
 		}
 		laddr = &net.UDPAddr{
 			IP:   llAddr,
-			Port: dhcpv6.DefaultClientPort,
-			// HACK: Zone should ideally be cfg.InterfaceName, but Go’s
-			// ipv6ZoneCache is only updated every 60s, so the addition of the
-			// veth interface will not be picked up for all tests after the
-			// first test.
-			Zone: strconv.Itoa(iface.Index),
+			Port: port,
+			Zone: cfg.InterfaceName,
 		}
 	}
+	if cfg.SourcePort < 0 || cfg.SourcePort > 65535 {
+		return nil, fmt.Errorf("SourcePort must be within [0, 65535], got %d", cfg.SourcePort)
+	}
+	if cfg.SourcePort != 0 {
+		l := *laddr
+		l.Port = cfg.SourcePort
+		laddr = &l
+	}
+	scope, err := zoneIndex(laddr.Zone, iface.Index)
+	if err != nil {
+		return nil, err
+	}
 
 	// if no RemoteAddr is specified, use AllDHCPRelayAgentsAndServers
 	raddr := cfg.RemoteAddr
@@ -120,194 +828,1808 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 		hardwareAddr = cfg.HardwareAddr
 	}
 
+	var iaid [4]byte
+	if cfg.IAID != nil {
+		if got, want := len(cfg.IAID), len(iaid); got != want {
+			return nil, fmt.Errorf("IAID must be %d bytes long, got %d", want, got)
+		}
+		copy(iaid[:], cfg.IAID)
+	} else {
+		if len(hardwareAddr) < len(iaid) {
+			return nil, fmt.Errorf("hardware address %v too short to derive IAID", hardwareAddr)
+		}
+		copy(iaid[:], hardwareAddr[len(hardwareAddr)-len(iaid):])
+	}
+
+	if cfg.PrefixLength < 0 || cfg.PrefixLength > 128 {
+		return nil, fmt.Errorf("PrefixLength must be within [0, 128], got %d", cfg.PrefixLength)
+	}
+
+	if cfg.RenewJitter < 0 || cfg.RenewJitter >= 1 {
+		return nil, fmt.Errorf("RenewJitter must be within [0, 1), got %v", cfg.RenewJitter)
+	}
+
+	if cfg.TransactionIDs != nil && cfg.TransactionIDFunc != nil {
+		return nil, fmt.Errorf("TransactionIDs and TransactionIDFunc must not be combined")
+	}
+
+	retransmission, err := retransmissionFromConfig(cfg.Retransmission)
+	if err != nil {
+		return nil, err
+	}
+
+	receiveBufferSize := cfg.ReceiveBufferSize
+	if receiveBufferSize == 0 {
+		receiveBufferSize = maxUDPReceivedPacketSize
+	}
+	if receiveBufferSize < 0 || receiveBufferSize > 65535 {
+		return nil, fmt.Errorf("ReceiveBufferSize must be within [0, 65535], got %d", cfg.ReceiveBufferSize)
+	}
+
+	numIAPD := cfg.IAPDs
+	if numIAPD < 0 {
+		return nil, fmt.Errorf("IAPDs must not be negative, got %d", numIAPD)
+	}
+	if numIAPD == 0 {
+		numIAPD = 1
+	}
+	if cfg.DisableIAPD {
+		switch {
+		case cfg.DisableIANA:
+			return nil, fmt.Errorf("DisableIANA and DisableIAPD must not be combined")
+		case cfg.IAPDs != 0:
+			return nil, fmt.Errorf("DisableIAPD and IAPDs must not be combined")
+		}
+		numIAPD = 0
+	}
+	iaids := make([][4]byte, numIAPD)
+	for idx := range iaids {
+		binary.BigEndian.PutUint32(iaids[idx][:], binary.BigEndian.Uint32(iaid[:])+uint32(idx))
+	}
+
+	var fqdn *dhcpv6.OptionGeneric
+	if cfg.FQDN != "" || cfg.FQDNFlags != 0 {
+		if fqdn, err = fqdnOption(cfg.FQDN, cfg.FQDNFlags); err != nil {
+			return nil, err
+		}
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = StdLogger(nil, false)
+	}
+
 	var duid *dhcpv6.Duid
+	var ephemeralDUID bool
 	if cfg.DUID != nil {
 		var err error
 		duid, err = dhcpv6.DuidFromBytes(cfg.DUID)
 		if err != nil {
 			return nil, err
 		}
-		fmt.Printf("duid: %T, %v, %#v", duid, duid, duid)
 	} else {
-		duid = &dhcpv6.Duid{
-			Type:          dhcpv6.DUID_LLT,
-			HwType:        iana.HWTypeEthernet,
-			Time:          dhcpv6.GetTime(),
-			LinkLayerAddr: hardwareAddr,
+		typ := cfg.DUIDType
+		if typ == 0 {
+			typ = dhcpv6.DUID_LL
+		}
+		generate := func() (*dhcpv6.Duid, error) {
+			return newDUID(typ, hardwareAddr, cfg.DUIDEnterpriseNumber, cfg.DUIDIdentifier)
+		}
+		if cfg.DUIDImportPath != "" {
+			generate = func() (*dhcpv6.Duid, error) {
+				return readDUIDFile(cfg.DUIDImportPath, cfg.DUIDImportFormat)
+			}
+		}
+		if cfg.DUIDPath != "" {
+			duid, err = loadOrCreateDUID(cfg.DUIDPath, generate)
+		} else {
+			duid, err = generate()
+		}
+		if err != nil {
+			return nil, err
+		}
+		// A DUID-LLT contains the time it was generated at, so unless it is
+		// persisted, the client has a new identity (and servers likely
+		// delegate a different prefix) after every restart.
+		if cfg.DUIDPath == "" && cfg.DUIDImportPath == "" && duid.Type == dhcpv6.DUID_LLT {
+			ephemeralDUID = true
+			logger.Printf("generated a DUID-LLT which is not persisted: the delegated prefix may change whenever the client restarts; set ClientConfig.DUIDPath to keep the DUID")
+		}
+	}
+	var preferServerID *dhcpv6.Duid
+	if cfg.PreferServerID != nil {
+		if preferServerID, err = dhcpv6.DuidFromBytes(cfg.PreferServerID); err != nil {
+			return nil, fmt.Errorf("PreferServerID: %v", err)
+		}
+	}
+
+	// Servers commonly key delegated prefixes by DUID, so it is logged for
+	// troubleshooting a prefix which changes unexpectedly.
+	logger.Debugf("DUID: %v (%x)", duid, duid.ToBytes())
+
+	var userClass *dhcpv6.OptUserClass
+	if len(cfg.UserClass) > 0 {
+		for _, uc := range cfg.UserClass {
+			if len(uc) > 0xffff {
+				return nil, fmt.Errorf("user class must be at most %d bytes long, got %d", 0xffff, len(uc))
+			}
 		}
+		userClass = &dhcpv6.OptUserClass{UserClasses: cfg.UserClass}
+	}
+
+	oro := defaultORO
+	if cfg.ORO != nil {
+		oro = append([]dhcpv6.OptionCode(nil), cfg.ORO...)
+	}
+
+	var relay *RelayConfig
+	if cfg.Relay != nil {
+		r := *cfg.Relay
+		if r.PeerAddr == nil {
+			r.PeerAddr = laddr.IP
+		}
+		relay = &r
 	}
 
 	// prepare the socket to listen on for replies
 	conn := cfg.Conn
 	if conn == nil {
-		udpConn, err := net.ListenUDP("udp6", laddr)
+		udpConn, err := listen(laddr, scope, cfg.BindUnspecified)
 		if err != nil {
 			return nil, err
 		}
+		if err := joinGroup(udpConn, scope); err != nil {
+			logger.Printf("joining %v on %s: %v", dhcpv6.AllDHCPRelayAgentsAndServers, cfg.InterfaceName, err)
+		}
 		conn = udpConn
 	}
 
-	return &Client{
-		interfaceName:  cfg.InterfaceName,
-		hardwareAddr:   hardwareAddr,
-		timeNow:        time.Now,
-		raddr:          raddr,
-		Conn:           conn,
-		duid:           duid,
-		transactionIDs: cfg.TransactionIDs,
-		ReadTimeout:    client6.DefaultReadTimeout,
-		WriteTimeout:   client6.DefaultWriteTimeout,
+	zone := cfg.InterfaceName
+	if cfg.InterfaceIndex != 0 {
+		// The name may not resolve in the network namespace of the process.
+		zone = strconv.Itoa(cfg.InterfaceIndex)
+	}
+
+	timeNow := time.Now
+	if cfg.Clock != nil {
+		timeNow = cfg.Clock.Now
+	}
+	c := &Client{
+		interfaceName:     cfg.InterfaceName,
+		ifindex:           iface.Index,
+		zone:              zone,
+		hardwareAddr:      hardwareAddr,
+		timeNow:           timeNow,
+		timerNow:          timeNow,
+		raddr:             raddr,
+		Conn:              conn,
+		ownConn:           cfg.Conn == nil,
+		laddr:             laddr,
+		scope:             scope,
+		laddrConfigured:   cfg.LocalAddr != nil,
+		bindUnspecified:   cfg.BindUnspecified,
+		forceRenew:        make(chan struct{}, 1),
+		duid:              duid,
+		ephemeralDUID:     ephemeralDUID,
+		preferServer:      preferServerID,
+		rapidCommit:       cfg.RapidCommit,
+		disableIANA:       cfg.DisableIANA,
+		iaids:             iaids,
+		prefixLength:      cfg.PrefixLength,
+		hintPrefixes:      cfg.HintPrefixes,
+		renewJitter:       cfg.RenewJitter,
+		maxDuration:       cfg.MaxDuration,
+		rcvbufSize:        receiveBufferSize,
+		oro:               oro,
+		vendorClass:       cfg.VendorClass,
+		vendorOpts:        vendorOptsFromConfig(cfg.VendorOpts),
+		userClass:         userClass,
+		fqdn:              fqdn,
+		acceptReconfigure: cfg.AcceptReconfigure,
+		authKey:           cfg.AuthKey,
+		relay:             relay,
+		leasePath:         cfg.LeasePath,
+		transactionIDs:    cfg.TransactionIDs,
+		newXID:            cfg.TransactionIDFunc,
+		retransmission:    retransmission,
+		randFloat64:       rand.Float64,
+		log:               logger,
+		onConfigChange:    cfg.OnConfigChange,
+		onLease:           cfg.OnLease,
+		onExpired:         cfg.OnExpired,
+		onPrefixChange:    cfg.OnPrefixChange,
+		installer:         cfg.AddressInstaller,
+		prom:              newMetrics(),
+		ReadTimeout:       client6.DefaultReadTimeout,
+		WriteTimeout:      client6.DefaultWriteTimeout,
+	}
+	if c.installer == nil {
+		c.installer = NopAddressInstaller{}
+	}
+	c.retryBackoff = backoff.Backoff{
+		Factor: 2,
+		Jitter: true,
+		Min:    10 * time.Second,
+		Max:    5 * time.Minute,
+	}
+	if cfg.WatchLink {
+		updates := make(chan netlink.LinkUpdate)
+		c.linkDone = make(chan struct{})
+		if err := netlink.LinkSubscribe(updates, c.linkDone); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.linkUp = make(chan struct{}, 1)
+		go c.watchLink(updates)
+	}
+	return c, nil
+}
+
+// clientInterface returns the interface identified by cfg. With
+// ClientConfig.InterfaceIndex, it is populated from cfg instead of being
+// looked up.
+func clientInterface(cfg ClientConfig) (*net.Interface, error) {
+	if cfg.InterfaceIndex == 0 {
+		return net.InterfaceByName(cfg.InterfaceName)
+	}
+	if cfg.InterfaceIndex < 0 {
+		return nil, fmt.Errorf("InterfaceIndex must not be negative, got %d", cfg.InterfaceIndex)
+	}
+	if cfg.LocalAddr == nil {
+		return nil, fmt.Errorf("LocalAddr must be set with InterfaceIndex")
+	}
+	if cfg.HardwareAddr == nil {
+		return nil, fmt.Errorf("HardwareAddr must be set with InterfaceIndex")
+	}
+	return &net.Interface{
+		Index:        cfg.InterfaceIndex,
+		Name:         cfg.InterfaceName,
+		HardwareAddr: cfg.HardwareAddr,
 	}, nil
 }
 
 func (c *Client) Close() error {
+	if c.linkDone != nil {
+		close(c.linkDone)
+	}
 	return c.Conn.Close()
 }
 
-const maxUDPReceivedPacketSize = 8192 // arbitrary size. Theoretically could be up to 65kb
+// maxUDPReceivedPacketSize is the default ClientConfig.ReceiveBufferSize.
+const maxUDPReceivedPacketSize = 65535
+
+// newReceiveBuffer returns a buffer for reading messages. It is one byte larger
+// than the largest accepted message, so that truncated reads can be detected:
+// a datagram which fills the buffer was truncated.
+func (c *Client) newReceiveBuffer() []byte {
+	return make([]byte, c.rcvbufSize+1)
+}
+
+// truncated returns whether a read of n bytes into a buffer from
+// newReceiveBuffer was truncated, logging it if so.
+func (c *Client) truncated(n int) bool {
+	if n <= c.rcvbufSize {
+		return false
+	}
+	c.log.Printf("discarding message larger than ReceiveBufferSize (%d bytes)", c.rcvbufSize)
+	return true
+}
+
+// SendReceive sends msg, which the caller built, and returns the first valid
+// response of expectedType, retransmitting msg with the parameters of its
+// message type. It allows exchanges this package does not implement, e.g.
+// with vendor-specific message types.
+//
+// If expectedType is dhcpv6.MessageTypeNone, it is inferred from the type of
+// msg (e.g. Reply for Renew), and the first response is accepted for message
+// types without a defined response. A rapid commit Reply is accepted as the
+// response to a Solicit with the Rapid Commit option.
+//
+// Like the other exchanges, SendReceive must not be called concurrently with
+// other exchanges of the client.
+func (c *Client) SendReceive(ctx context.Context, msg *dhcpv6.Message, expectedType dhcpv6.MessageType) (*dhcpv6.Message, error) {
+	return c.sendReceive(ctx, msg, expectedType)
+}
+
+// isClientMessage returns whether t is sent by clients (instead of servers),
+// i.e. never a response to a message of the client.
+func isClientMessage(t dhcpv6.MessageType) bool {
+	switch t {
+	case dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeConfirm,
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind,
+		dhcpv6.MessageTypeRelease,
+		dhcpv6.MessageTypeDecline,
+		dhcpv6.MessageTypeInformationRequest,
+		dhcpv6.MessageTypeRelayForward,
+		dhcpv6.MessageTypeLeaseQuery:
+		return true
+	default:
+		return false
+	}
+}
+
+// expectedResponseType returns the message type a server responds to a client
+// message of type t with (RFC 8415, section 7.3, and RFC 5007, section 4.2),
+// or dhcpv6.MessageTypeNone if t has no defined response.
+func expectedResponseType(t dhcpv6.MessageType) dhcpv6.MessageType {
+	switch t {
+	case dhcpv6.MessageTypeSolicit:
+		return dhcpv6.MessageTypeAdvertise
+	case dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeConfirm,
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind,
+		dhcpv6.MessageTypeRelease,
+		dhcpv6.MessageTypeDecline,
+		dhcpv6.MessageTypeInformationRequest:
+		return dhcpv6.MessageTypeReply
+	case dhcpv6.MessageTypeRelayForward:
+		return dhcpv6.MessageTypeRelayReply
+	case dhcpv6.MessageTypeLeaseQuery:
+		return dhcpv6.MessageTypeLeaseQueryReply
+	default:
+		return dhcpv6.MessageTypeNone
+	}
+}
 
-func (c *Client) sendReceive(packet *dhcpv6.Message, expectedType dhcpv6.MessageType) (*dhcpv6.Message, error) {
+func (c *Client) sendReceive(ctx context.Context, packet *dhcpv6.Message, expectedType dhcpv6.MessageType) (*dhcpv6.Message, error) {
 	if packet == nil {
 		return nil, fmt.Errorf("packet to send cannot be nil")
 	}
+	params, ok := c.retransmission[packet.Type()]
+	if !ok {
+		// Message types without retransmission parameters (e.g. LeaseQuery) are
+		// transmitted exactly once.
+		params = Retransmission{IRT: c.ReadTimeout, MRC: 1}
+	}
+	return c.sendReceiveParams(ctx, packet, expectedType, params)
+}
+
+// abortReads aborts a blocking c.Conn.ReadFrom as soon as ctx is cancelled by
+// setting a read deadline in the past. The returned function must be called
+// to stop watching ctx.
+func (c *Client) abortReads(ctx context.Context) (stop func()) {
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			c.Conn.SetReadDeadline(time.Unix(1, 0))
+		case <-finished:
+		}
+	}()
+	return func() { close(finished) }
+}
+
+// sendReceiveParams is like sendReceive, but uses the specified retransmission
+// parameters instead of the defaults for the message type.
+func (c *Client) sendReceiveParams(ctx context.Context, packet *dhcpv6.Message, expectedType dhcpv6.MessageType, params Retransmission) (_ *dhcpv6.Message, err error) {
 	if expectedType == dhcpv6.MessageTypeNone {
-		// infer the expected type from the packet being sent
-		if packet.Type() == dhcpv6.MessageTypeSolicit {
-			expectedType = dhcpv6.MessageTypeAdvertise
-		} else if packet.Type() == dhcpv6.MessageTypeRequest {
-			expectedType = dhcpv6.MessageTypeReply
-		} else if packet.Type() == dhcpv6.MessageTypeRelayForward {
-			expectedType = dhcpv6.MessageTypeRelayReply
-		} else if packet.Type() == dhcpv6.MessageTypeLeaseQuery {
-			expectedType = dhcpv6.MessageTypeLeaseQueryReply
-		} // and probably more
-	}
-
-	// send the packet out
-	c.Conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
-	if _, err := c.Conn.WriteTo(packet.ToBytes(), c.raddr); err != nil {
-		return nil, err
+		expectedType = expectedResponseType(packet.Type())
 	}
+	defer c.abortReads(ctx)()
 
-	// wait for a reply
-	c.Conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
-	var (
-		adv *dhcpv6.Message
-	)
-	for {
-		buf := make([]byte, maxUDPReceivedPacketSize)
-		n, _, err := c.Conn.ReadFrom(buf)
+	ex := &Exchange{MessageType: packet.Type()}
+	defer func() {
+		if ex == nil {
+			return // recorded by the nested exchange
+		}
+		ex.Err = err
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.lastExchange = *ex
+	}()
+
+	// buf is reused for all messages read during the exchange; receive
+	// copies the messages it returns.
+	buf := c.newReceiveBuffer()
+	start := c.timerNow()
+	rt := c.initialRT(packet.Type(), params)
+	for transmissions := 1; ; transmissions++ {
+		// send the packet out, retaining the transaction ID of the first
+		// transmission
+		packet.UpdateOption(elapsedTime(c.timerNow().Sub(start)))
+		b, err := c.wrap(packet)
 		if err != nil {
 			return nil, err
 		}
-		adv, err = dhcpv6.MessageFromBytes(buf[:n])
-		if err != nil {
-			log.Printf("non-DHCP: %v", err)
-			// skip non-DHCP packets
-			continue
+		c.Conn.SetWriteDeadline(c.timerNow().Add(c.WriteTimeout))
+		dst := c.destination(packet)
+		ex.Sent = c.timerNow()
+		ex.SentBytes = len(packet.ToBytes())
+		ex.Transmissions = transmissions
+		if _, err := c.Conn.WriteTo(b, dst); err != nil {
+			return nil, &SocketError{Op: "write", Err: err}
 		}
-		if packet.TransactionID != adv.TransactionID {
-			log.Printf("different XID: got %v, want %v", adv.TransactionID, packet.TransactionID)
-			// different XID, we don't want this packet for sure
-			continue
+		c.prom.sent.WithLabelValues(packet.Type().String()).Inc()
+		if transmissions > 1 {
+			c.prom.retransmissions.Inc()
 		}
-		if expectedType == dhcpv6.MessageTypeNone {
-			// just take whatever arrived
-			break
-		} else if adv.MessageType == expectedType {
-			break
+
+		// wait for a reply until the retransmission timeout expires
+		deadline := c.timerNow().Add(rt)
+		if params.MRD > 0 {
+			if mrd := start.Add(params.MRD); mrd.Before(deadline) {
+				deadline = mrd
+			}
+		}
+		c.Conn.SetReadDeadline(deadline)
+		if err := ctx.Err(); err != nil {
+			// ctx was cancelled before the deadline was set, so the deadline
+			// of the watcher goroutine might have been overwritten.
+			return nil, err
+		}
+		var adv *dhcpv6.Message
+		if packet.Type() == dhcpv6.MessageTypeSolicit && transmissions == 1 {
+			adv, err = c.collectAdvertises(packet, buf)
+		} else {
+			adv, err = c.receive(packet, expectedType, buf)
+		}
+		if err == nil {
+			ex.Received = c.timerNow()
+			ex.ReceivedType = adv.Type()
+			ex.ReceivedBytes = len(adv.ToBytes())
+			ex.ServerID = adv.Options.ServerID()
+			ex.InterfaceID = c.receivedInterfaceID
+			c.prom.received.WithLabelValues(adv.Type().String()).Inc()
+			c.mu.Lock()
+			switch adv.Type() {
+			case dhcpv6.MessageTypeAdvertise:
+				c.lastAdvertise = adv
+			case dhcpv6.MessageTypeReply:
+				c.lastReply = adv
+			}
+			c.mu.Unlock()
+			c.updateMaxRT(adv)
+			if dst != c.raddr && hasStatus(adv, iana.StatusUseMulticast) {
+				// RFC 8415, section 18.2.10: the server withdrew its
+				// permission to unicast, so the message is resent via
+				// multicast.
+				c.log.Printf("server asked to use multicast instead of %v, resending", dst)
+				c.unicast = nil
+				ex = nil
+				return c.sendReceiveParams(ctx, packet, expectedType, params)
+			}
+			return adv, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, &SocketError{Op: "read", Err: err}
 		}
-	}
-	return adv, nil
-}
 
-func (c *Client) solicit(solicit *dhcpv6.Message) (*dhcpv6.Message, *dhcpv6.Message, error) {
-	var err error
-	if solicit == nil {
-		solicit, err = dhcpv6.NewSolicit(c.hardwareAddr, dhcpv6.WithClientID(*c.duid))
-		if err != nil {
-			return nil, nil, err
+		elapsed := c.timerNow().Sub(start)
+		if (params.MRC > 0 && transmissions >= params.MRC) ||
+			(params.MRD > 0 && elapsed >= params.MRD) {
+			return nil, &TimeoutError{
+				MessageType:   packet.Type(),
+				Transmissions: transmissions,
+				Elapsed:       elapsed,
+			}
 		}
+		rt = c.nextRT(rt, params)
 	}
-	if len(c.transactionIDs) > 0 {
-		id := c.transactionIDs[0]
-		c.transactionIDs = c.transactionIDs[1:]
-		solicit.TransactionID = id
-	}
-	solicit.AddOption(&dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 1}})
-	advertise, err := c.sendReceive(solicit, dhcpv6.MessageTypeNone)
-	return solicit, advertise, err
 }
 
-func (c *Client) request(advertise *dhcpv6.Message) (*dhcpv6.Message, *dhcpv6.Message, error) {
-	request, err := dhcpv6.NewRequestFromAdvertise(advertise, dhcpv6.WithClientID(*c.duid))
-	if err != nil {
-		return nil, nil, err
+// destination returns the address to send packet to: the address from the
+// Server Unicast option of the current lease for Renew and Release messages
+// (RFC 8415, section 18.2.10), c.raddr otherwise.
+func (c *Client) destination(packet *dhcpv6.Message) net.Addr {
+	if c.unicast == nil || c.relay != nil {
+		return c.raddr
 	}
-	if iapd := advertise.Options.OneIAPD(); iapd != nil {
-		request.AddOption(iapd)
+	switch packet.MessageType {
+	case dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRelease:
+		dst := &net.UDPAddr{IP: c.unicast, Port: dhcpv6.DefaultServerPort}
+		if c.unicast.IsLinkLocalUnicast() {
+			dst.Zone = c.zone
+		}
+		return dst
+	default:
+		return c.raddr
 	}
+}
 
-	if len(c.transactionIDs) > 0 {
-		id := c.transactionIDs[0]
-		c.transactionIDs = c.transactionIDs[1:]
-		request.TransactionID = id
+// receive reads from c.Conn into buf until a message of expectedType matching
+// the transaction ID of packet arrives, or the read deadline expires. Messages
+// are only copied out of buf once their transaction ID matches, so that
+// unrelated traffic does not cause allocations.
+func (c *Client) receive(packet *dhcpv6.Message, expectedType dhcpv6.MessageType, buf []byte) (*dhcpv6.Message, error) {
+	for {
+		n, _, err := c.Conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if c.truncated(n) {
+			continue
+		}
+		raw, interfaceID, err := c.unwrap(buf[:n])
+		if err != nil {
+			c.log.Debugf("not relayed: %v", err)
+			continue
+		}
+		if len(raw) < 4 { // msg-type and transaction-id
+			c.log.Debugf("non-DHCP: %d bytes", len(raw))
+			continue
+		}
+		if typ := dhcpv6.MessageType(raw[0]); isClientMessage(typ) {
+			// Our own message, looped back or sent by another client.
+			c.log.Debugf("ignoring client message %v", typ)
+			continue
+		}
+		var xid dhcpv6.TransactionID
+		copy(xid[:], raw[1:4])
+		if packet.TransactionID != xid {
+			c.log.Debugf("different XID: got %v, want %v", xid, packet.TransactionID)
+			// different XID, we don't want this packet for sure
+			continue
+		}
+		raw = append([]byte(nil), raw...)
+		adv, err := dhcpv6.MessageFromBytes(raw)
+		if err != nil {
+			c.log.Debugf("non-DHCP: %v", err)
+			// skip non-DHCP packets
+			continue
+		}
+		if want := packet.Options.ServerID(); want != nil {
+			// Messages addressed to a specific server (e.g. Request, Renew)
+			// must be answered by that server (RFC 8415, section 18.2.10).
+			if got := adv.Options.ServerID(); got == nil || !got.Equal(*want) {
+				c.log.Debugf("different Server ID: got %v, want %v", got, want)
+				continue
+			}
+		}
+		if packet.MessageType == dhcpv6.MessageTypeSolicit {
+			if err := validAdvertise(adv); err != nil {
+				c.log.Printf("discarding %v: %v", adv.MessageType, err)
+				continue
+			}
+		}
+		if c.authKey != nil && adv.MessageType == dhcpv6.MessageTypeReply && !sendsReconfigureKey(packet, adv) {
+			replay, err := validateAuth(raw, c.authKey, nil, c.replayDetection)
+			if err != nil {
+				c.log.Printf("dropping unauthenticated Reply: %v", err)
+				continue
+			}
+			c.replayDetection = replay
+		}
+		if expectedType == dhcpv6.MessageTypeNone ||
+			adv.MessageType == expectedType ||
+			isRapidCommitReply(packet, adv) {
+			c.receivedInterfaceID = interfaceID
+			return adv, nil
+		}
+	}
+}
+
+// sendsReconfigureKey returns whether reply, a response to msg, carries the
+// reconfigure key instead of a digest. Servers send the key in the Reply to a
+// Solicit or Request (RFC 8415, section 20.4.1); bind stores it for
+// authenticating Reconfigure messages.
+func sendsReconfigureKey(msg, reply *dhcpv6.Message) bool {
+	if msg.MessageType != dhcpv6.MessageTypeSolicit && msg.MessageType != dhcpv6.MessageTypeRequest {
+		return false
+	}
+	key, _, err := reconfigureKey(reply)
+	return err == nil && key != nil
+}
+
+// validAdvertise returns an error if the client must discard adv, a response
+// to its Solicit, because a mandatory option is missing (RFC 8415, section
+// 16.3). Such an Advertise could not be answered with a Request.
+func validAdvertise(adv *dhcpv6.Message) error {
+	if adv.Options.ServerID() == nil {
+		return fmt.Errorf("Server ID missing")
+	}
+	if adv.Options.ClientID() == nil {
+		return fmt.Errorf("Client ID missing")
+	}
+	return nil
+}
+
+// collectAdvertises receives Advertise messages in response to solicit until
+// the read deadline expires, and returns the one with the highest preference
+// (RFC 8415, section 18.2.9). A rapid commit Reply or an Advertise with the
+// maximum preference of 255 is returned immediately (RFC 8415, section
+// 18.2.1). With ClientConfig.PreferServerID, an Advertise from that server is
+// returned immediately instead.
+func (c *Client) collectAdvertises(solicit *dhcpv6.Message, buf []byte) (*dhcpv6.Message, error) {
+	var best *dhcpv6.Message
+	var bestInterfaceID []byte
+	for {
+		adv, err := c.receive(solicit, dhcpv6.MessageTypeAdvertise, buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && best != nil {
+				c.receivedInterfaceID = bestInterfaceID
+				return best, nil
+			}
+			return nil, err
+		}
+		if isRapidCommitReply(solicit, adv) {
+			return adv, nil
+		}
+		if c.preferServer != nil {
+			if sid := adv.Options.ServerID(); sid != nil && sid.Equal(*c.preferServer) {
+				return adv, nil
+			}
+		} else if preference(adv) == maxPreference {
+			return adv, nil
+		}
+		// Among Advertises of the same preference, the first one wins.
+		if best == nil || preference(adv) > preference(best) {
+			best = adv
+			bestInterfaceID = c.receivedInterfaceID
+		}
+	}
+}
+
+// isRapidCommitReply returns whether reply completes a rapid commit exchange
+// (RFC 8415, section 18.2.1) started by sending solicit.
+func isRapidCommitReply(solicit, reply *dhcpv6.Message) bool {
+	return solicit.MessageType == dhcpv6.MessageTypeSolicit &&
+		reply.MessageType == dhcpv6.MessageTypeReply &&
+		solicit.GetOneOption(dhcpv6.OptionRapidCommit) != nil &&
+		reply.GetOneOption(dhcpv6.OptionRapidCommit) != nil
+}
+
+func (c *Client) solicit(ctx context.Context, params Retransmission) (*dhcpv6.Message, *dhcpv6.Message, error) {
+	solicit, err := c.newSolicit()
+	if err != nil {
+		return nil, nil, err
+	}
+	advertise, err := c.sendReceiveParams(ctx, solicit, dhcpv6.MessageTypeAdvertise, params)
+	return solicit, advertise, err
+}
+
+// newSolicit returns a Solicit message for the configured identity
+// associations and options.
+func (c *Client) newSolicit() (*dhcpv6.Message, error) {
+	solicit, err := dhcpv6.NewSolicit(c.hardwareAddr, dhcpv6.WithClientID(*c.duid))
+	if err != nil {
+		return nil, err
+	}
+	solicit.UpdateOption(dhcpv6.OptRequestedOption(c.requestedOptions()...))
+	if err := c.setTransactionID(solicit); err != nil {
+		return nil, err
+	}
+	if c.disableIANA {
+		solicit.Options.Del(dhcpv6.OptionIANA)
+	}
+	hints := c.solicitPrefixHints()
+	for _, iaid := range c.iaids {
+		iapd := &dhcpv6.OptIAPD{IaId: iaid}
+		for _, prefix := range hints[iaid] {
+			prefix := prefix
+			iapd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: &prefix})
+		}
+		if len(hints[iaid]) == 0 && c.prefixLength > 0 {
+			iapd.Options.Add(&dhcpv6.OptIAPrefix{
+				Prefix: &net.IPNet{
+					IP:   net.IPv6zero,
+					Mask: net.CIDRMask(c.prefixLength, 128),
+				},
+			})
+		}
+		solicit.AddOption(iapd)
+	}
+	if c.rapidCommit {
+		dhcpv6.WithRapidCommit(solicit)
+	}
+	if c.vendorClass != nil {
+		solicit.UpdateOption(c.vendorClass)
+	}
+	if c.userClass != nil {
+		solicit.UpdateOption(c.userClass)
+	}
+	c.addVendorOpts(solicit)
+	c.addFQDN(solicit)
+	c.addReconfigureAccept(solicit)
+	return solicit, nil
+}
+
+// addReconfigureAccept adds the Reconfigure Accept option to msg if the
+// client accepts Reconfigure messages.
+func (c *Client) addReconfigureAccept(msg *dhcpv6.Message) {
+	if c.acceptReconfigure {
+		msg.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfAccept})
+	}
+}
+
+// addFQDN adds the Client FQDN option to msg if ClientConfig.FQDN or
+// ClientConfig.FQDNFlags is set.
+func (c *Client) addFQDN(msg *dhcpv6.Message) {
+	if c.fqdn != nil {
+		msg.AddOption(c.fqdn)
+	}
+}
+
+// addVendorOpts adds the configured Vendor-specific Information options to
+// msg.
+func (c *Client) addVendorOpts(msg *dhcpv6.Message) {
+	for _, vo := range c.vendorOpts {
+		msg.AddOption(vo)
+	}
+}
+
+// setTransactionID overrides the transaction ID of msg with the result of
+// ClientConfig.TransactionIDFunc or the next configured transaction ID (for
+// testing), if ClientConfig.TransactionIDs was set, and returns an error once
+// all of them were used.
+func (c *Client) setTransactionID(msg *dhcpv6.Message) error {
+	if c.newXID != nil {
+		xid, err := c.newXID()
+		if err != nil {
+			return fmt.Errorf("TransactionIDFunc: %v", err)
+		}
+		msg.TransactionID = xid
+		return nil
+	}
+	if c.transactionIDs == nil {
+		return nil
+	}
+	if len(c.transactionIDs) == 0 {
+		return fmt.Errorf("no TransactionIDs left for %v", msg.MessageType)
+	}
+	msg.TransactionID = c.transactionIDs[0]
+	c.transactionIDs = c.transactionIDs[1:]
+	return nil
+}
+
+// newMessage returns a message of type typ carrying all identity
+// associations (IA_NA and IA_PD) of from, which is an Advertise or Reply. If
+// serverID is true, the message is addressed to the server which sent from.
+// Unlike dhcpv6.NewRequestFromAdvertise, the IA_NA is optional.
+func (c *Client) newMessage(typ dhcpv6.MessageType, from *dhcpv6.Message, serverID bool) (*dhcpv6.Message, error) {
+	if from == nil {
+		return nil, fmt.Errorf("no Advertise received yet")
+	}
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageType = typ
+	msg.AddOption(dhcpv6.OptClientID(*c.duid))
+	if serverID {
+		sid := from.GetOneOption(dhcpv6.OptionServerID)
+		if sid == nil {
+			return nil, fmt.Errorf("Server ID missing in %v", from.MessageType)
+		}
+		msg.AddOption(sid)
 	}
-	reply, err := c.sendReceive(request, dhcpv6.MessageTypeNone)
+	msg.AddOption(dhcpv6.OptElapsedTime(0))
+	for _, ia := range identityAssociations(from, false) {
+		msg.AddOption(ia)
+	}
+	return msg, nil
+}
+
+// identityAssociations returns the IA_NA and IA_PD options of from, reduced
+// to their IAID and their addresses or prefixes: the client sets T1 and T2 to
+// 0 and sends no Status Code options (RFC 8415, section 18.2.4). If
+// boundOnly is true, identity associations without addresses or prefixes
+// (e.g. those the server declined with a status code) are omitted.
+func identityAssociations(from *dhcpv6.Message, boundOnly bool) []dhcpv6.Option {
+	var ias []dhcpv6.Option
+	for _, ia := range from.Options.IANA() {
+		copied := &dhcpv6.OptIANA{IaId: ia.IaId}
+		for _, addr := range ia.Options.Addresses() {
+			copied.Options.Add(addr)
+		}
+		if boundOnly && len(copied.Options.Options) == 0 {
+			continue
+		}
+		ias = append(ias, copied)
+	}
+	for _, ia := range from.Options.IAPD() {
+		copied := &dhcpv6.OptIAPD{IaId: ia.IaId}
+		for _, prefix := range ia.Options.Prefixes() {
+			copied.Options.Add(prefix)
+		}
+		if boundOnly && len(copied.Options.Options) == 0 {
+			continue
+		}
+		ias = append(ias, copied)
+	}
+	return ias
+}
+
+// defaultORO contains the option codes requested when ClientConfig.ORO is nil.
+var defaultORO = []dhcpv6.OptionCode{
+	dhcpv6.OptionDNSRecursiveNameServer,
+	dhcpv6.OptionDomainSearchList,
+	dhcpv6.OptionSNTPServerList,
+	dhcpv6.OptionNTPServer,
+	dhcpv6.OptionPDExclude,
+	dhcpv6.OptionSolMaxRT,
+	dhcpv6.OptionBootfileURL,
+	dhcpv6.OptionBootfileParam,
+}
+
+// requestedOptions returns the option codes for the Option Request Option.
+func (c *Client) requestedOptions() []dhcpv6.OptionCode {
+	return c.oro
+}
+
+func (c *Client) request(ctx context.Context, advertise *dhcpv6.Message, params Retransmission) (*dhcpv6.Message, *dhcpv6.Message, error) {
+	request, err := c.newMessage(dhcpv6.MessageTypeRequest, advertise, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	request.AddOption(dhcpv6.OptRequestedOption(c.requestedOptions()...))
+	c.addVendorOpts(request)
+	c.addFQDN(request)
+	c.addReconfigureAccept(request)
+	if c.vendorClass != nil {
+		request.AddOption(c.vendorClass)
+	} else if vc := advertise.GetOneOption(dhcpv6.OptionVendorClass); vc != nil {
+		request.AddOption(vc)
+	}
+	if c.userClass != nil {
+		request.AddOption(c.userClass)
+	}
+
+	if err := c.setTransactionID(request); err != nil {
+		return nil, nil, err
+	}
+	reply, err := c.sendReceiveParams(ctx, request, dhcpv6.MessageTypeNone, params)
 	return request, reply, err
 }
 
+// ObtainOrRenew obtains a DHCPv6 lease and stores any error in c.Err().
+//
+// Unless ClientConfig.MaxDuration is set, obtaining a new lease is bounded by
+// ReadTimeout for each of the Solicit and Request (as before the exchanges
+// were retransmitted), after which c.Err() matches ErrTimeout.
+//
+// Deprecated: use ObtainOrRenewErr, which returns the error directly. The
+// returned bool is always true.
 func (c *Client) ObtainOrRenew() bool {
-	c.err = nil // clear previous error
-	_, advertise, err := c.solicit(nil)
+	maxDuration := c.maxDuration
+	if maxDuration == 0 {
+		maxDuration = 2 * c.ReadTimeout
+	}
+	c.obtainOrRenewErr(context.Background(), maxDuration)
+	return true
+}
+
+// ObtainOrRenewErr obtains a DHCPv6 lease via Solicit/Advertise/Request/Reply
+// and returns the resulting configuration. The error is also available via
+// c.Err() until the next call.
+//
+// Cancelling ctx aborts any in-flight exchange promptly and returns ctx.Err().
+// If the server declined to grant a lease (e.g. NoPrefixAvail), the error is
+// a *StatusError, and ErrNoPrefixes if it replied with IA_PD options without
+// a usable prefix. If no server answered, errors.Is(err, ErrTimeout) holds,
+// and socket failures are reported as a *SocketError.
+func (c *Client) ObtainOrRenewErr(ctx context.Context) (Config, error) {
+	return c.obtainOrRenewErr(ctx, c.maxDuration)
+}
+
+// obtainOrRenewErr implements ObtainOrRenewErr, bounding a Solicit exchange
+// by maxDuration if non-zero.
+func (c *Client) obtainOrRenewErr(ctx context.Context, maxDuration time.Duration) (Config, error) {
+	if c.reply == nil && c.leasePath != "" {
+		cfg, err := c.resume(ctx)
+		if err == nil {
+			return c.result(cfg, nil)
+		}
+		if ctx.Err() != nil {
+			return c.result(Config{}, ctx.Err())
+		}
+		c.log.Printf("resuming saved lease: %v, soliciting a new lease", err)
+	}
+	return c.result(c.obtainOrRenew(ctx, maxDuration))
+}
+
+// result records the outcome of an exchange for c.Config() and c.Err().
+func (c *Client) result(cfg Config, err error) (Config, error) {
+	c.mu.Lock()
+	c.err = err // clears any previous error
+	c.mu.Unlock()
 	if err != nil {
-		c.err = err
-		return true
+		if c.reply != nil && !c.validUntil.IsZero() && !c.timeNow().Before(c.validUntil) {
+			c.log.Printf("lease expired at %v", c.validUntil)
+			c.unbind()
+			c.uninstallAddresses()
+			if c.onExpired != nil {
+				c.onExpired()
+			}
+		}
+		return Config{}, err
+	}
+	changed := !equalIgnoringTimers(c.cfg, cfg)
+	c.setConfig(cfg)
+	c.prom.lastLease.Set(float64(c.timeNow().Unix()))
+	c.prom.renewAfter.Set(float64(cfg.RenewAfter.Unix()))
+	c.prom.prefixes.Set(float64(len(cfg.Prefixes)))
+	if c.onLease != nil {
+		c.onLease(cfg)
+	}
+	if changed && c.onConfigChange != nil {
+		c.onConfigChange(cfg)
+	}
+	old := c.lastPrefixes
+	c.lastPrefixes = append([]net.IPNet{}, cfg.Prefixes...)
+	if old != nil && !samePrefixes(old, cfg.Prefixes) && c.onPrefixChange != nil {
+		c.onPrefixChange(old, cfg.Prefixes)
+	}
+	c.installAddresses(cfg)
+	return cfg, nil
+}
+
+// samePrefixes returns whether a and b contain the same networks, in any
+// order.
+func samePrefixes(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	networks := make(map[string]bool)
+	for _, n := range a {
+		networks[networkKey(n)] = true
+	}
+	for _, n := range b {
+		if !networks[networkKey(n)] {
+			return false
+		}
+	}
+	return true
+}
+
+// unbind discards the current lease. If the lease was reported via
+// OnConfigChange, the empty configuration is reported, too.
+func (c *Client) unbind() {
+	bound := !equalIgnoringTimers(c.Config(), Config{})
+	c.advertise = nil
+	c.reply = nil
+	c.unicast = nil
+	c.validUntil = time.Time{}
+	c.setConfig(Config{})
+	// The lease gauges describe the current lease, of which there is none.
+	c.prom.renewAfter.Set(0)
+	c.prom.prefixes.Set(0)
+	if bound && c.onConfigChange != nil {
+		c.onConfigChange(Config{})
+	}
+}
+
+// setConfig updates the configuration returned by c.Config().
+func (c *Client) setConfig(cfg Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+}
+
+// equalIgnoringTimers returns whether a and b are equal, apart from the
+// points in time which move with every Renew (e.g. RenewAfter).
+func equalIgnoringTimers(a, b Config) bool {
+	strip := func(cfg Config) Config {
+		cfg.RenewAfter = time.Time{}
+		cfg.RebindAfter = time.Time{}
+		cfg.Transition = TransitionNone
+		cfg.TransitionAt = time.Time{}
+		leases := make([]Lease, len(cfg.Leases))
+		for idx, l := range cfg.Leases {
+			l.PreferredUntil = time.Time{}
+			l.ValidUntil = time.Time{}
+			leases[idx] = l
+		}
+		cfg.Leases = leases
+		return cfg
+	}
+	return reflect.DeepEqual(strip(a), strip(b))
+}
+
+// obtainOrRenew obtains a new lease via Solicit. If maxDuration is non-zero,
+// the exchange fails with a *TimeoutError once it elapsed.
+func (c *Client) obtainOrRenew(ctx context.Context, maxDuration time.Duration) (Config, error) {
+	start := c.timerNow()
+	var deadline time.Time
+	if maxDuration > 0 {
+		deadline = c.timerNow().Add(maxDuration)
+	}
+	params := c.withDeadline(c.retransmission[dhcpv6.MessageTypeSolicit], deadline)
+	solicit, advertise, err := c.solicit(ctx, params)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := bindingError(advertise); err != nil {
+		return Config{}, err
+	}
+
+	if isRapidCommitReply(solicit, advertise) {
+		// The server committed the lease without an Advertise/Request round
+		// trip. The Reply carries the same server and IA options as an
+		// Advertise, so Release can be built from it.
+		c.acquired(start)
+		return c.bind(advertise, TransitionSolicit), nil
 	}
 
 	c.advertise = advertise
-	_, reply, err := c.request(advertise)
+	params = c.withDeadline(c.retransmission[dhcpv6.MessageTypeRequest], deadline)
+	_, reply, err := c.request(ctx, advertise, params)
 	if err != nil {
-		c.err = err
-		return true
+		return Config{}, err
+	}
+	if err := bindingError(reply); err != nil {
+		return Config{}, err
+	}
+	c.acquired(start)
+	return c.bind(reply, TransitionSolicit), nil
+}
+
+// acquired records the duration of obtaining a lease via an exchange which
+// started at start, ending now, when the final Reply arrived.
+func (c *Client) acquired(start time.Time) {
+	d := c.timerNow().Sub(start)
+	c.mu.Lock()
+	c.acquisition = d
+	c.mu.Unlock()
+	c.prom.acquisition.Set(d.Seconds())
+}
+
+// withDeadline returns params with the MRD shortened to end at deadline, if
+// non-zero. Once deadline passed, a single transmission remains.
+func (c *Client) withDeadline(params Retransmission, deadline time.Time) Retransmission {
+	if deadline.IsZero() {
+		return params
+	}
+	remaining := deadline.Sub(c.timerNow())
+	if remaining <= 0 {
+		params.MRC = 1
+		remaining = 1 // the reply must arrive right away
+	}
+	if params.MRD == 0 || remaining < params.MRD {
+		params.MRD = remaining
+	}
+	return params
+}
+
+// bind records the identity associations of reply as the current lease and
+// returns the resulting network configuration, bound via t. If
+// ClientConfig.LeasePath is set, the lease is saved.
+func (c *Client) bind(reply *dhcpv6.Message, t Transition) Config {
+	now := c.timeNow()
+	cfg := c.bindAt(reply, now)
+	cfg.Transition = t
+	cfg.TransitionAt = now
+	if c.leasePath != "" {
+		if err := c.SaveLease(); err != nil {
+			c.log.Printf("saving lease: %v", err)
+		}
+	}
+	return cfg
+}
+
+// bindAt is like bind, but for a reply received at now.
+func (c *Client) bindAt(reply *dhcpv6.Message, now time.Time) Config {
+	// The Reply identifies the server which holds our binding, so subsequent
+	// messages (e.g. Release) are built from it.
+	c.advertise = reply
+	c.reply = reply
+	if key, replay, err := reconfigureKey(reply); err != nil {
+		c.log.Printf("ignoring reconfigure key: %v", err)
+	} else if key != nil {
+		c.reconfigureKey = key
+		c.replayDetection = replay
+	}
+	c.unicast = nil
+	if opt := reply.GetOneOption(dhcpv6.OptionUnicast); opt != nil {
+		if ip, err := parseUnicast(opt.ToBytes()); err != nil {
+			c.log.Printf("ignoring Server Unicast option: %v", err)
+		} else {
+			c.unicast = ip
+		}
+	}
+	c.boundAt = now
+	c.validUntil = leaseValidUntil(reply, now)
+	if hints := prefixHintsFrom(reply); c.hintPrefixes && hints != nil {
+		c.prefixHints = hints
+	}
+	return c.configFromReply(reply, now)
+}
+
+// leaseValidUntil returns when the valid lifetime of the longest-lived IA in
+// reply, which was received at now, expires.
+func leaseValidUntil(reply *dhcpv6.Message, now time.Time) time.Time {
+	var validUntil time.Time
+	extend := func(valid time.Duration) {
+		if t := now.Add(valid); t.After(validUntil) {
+			validUntil = t
+		}
+	}
+	for _, iana := range reply.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			extend(addr.ValidLifetime)
+		}
 	}
-	var newCfg Config
 	for _, iapd := range reply.Options.IAPD() {
-		t1 := c.timeNow().Add(iapd.T1)
-		if t1.Before(newCfg.RenewAfter) || newCfg.RenewAfter.IsZero() {
-			newCfg.RenewAfter = t1
+		for _, prefix := range iapd.Options.Prefixes() {
+			extend(prefix.ValidLifetime)
+		}
+	}
+	return validUntil
+}
+
+// Renew extends the lifetimes of the current lease by sending a Renew message
+// to the server which granted it (RFC 8415, section 18.2.4), identified by its
+// Server ID. Clients Renew after T1 (Config.RenewAfter). The exchange fails
+// once T2 is reached, after which the client should Rebind. If the server
+// included a Server Unicast option in its Reply, the Renew is unicast to the
+// server until it replies with status UseMulticast, in which case the Renew is
+// transparently resent via multicast.
+//
+// If the server no longer has a binding for (parts of) the lease, Renew asks
+// it to reinstate the lease via Request (RFC 8415, section 18.2.10.1), and
+// falls back to obtaining a new lease via Solicit if that fails.
+func (c *Client) Renew(ctx context.Context) (Config, error) {
+	return c.result(c.renew(ctx, 0, true))
+}
+
+// renew implements Renew. If maxDuration is non-zero, the exchange is aborted
+// after maxDuration even if T2 was not yet reached. Unless reinstate is set, a
+// NoBinding reply fails the exchange with a *StatusError instead of
+// reinstating the lease.
+func (c *Client) renew(ctx context.Context, maxDuration time.Duration, reinstate bool) (Config, error) {
+	if c.reply == nil {
+		return Config{}, fmt.Errorf("no lease to renew")
+	}
+	params := c.retransmission[dhcpv6.MessageTypeRenew]
+	if t2 := c.cfg.RebindAfter; !t2.IsZero() {
+		untilT2 := t2.Sub(c.timeNow())
+		if untilT2 <= 0 {
+			return Config{}, fmt.Errorf("T2 passed at %v, Rebind instead", t2)
+		}
+		if params.MRD == 0 || untilT2 < params.MRD {
+			params.MRD = untilT2
+		}
+	}
+	if maxDuration > 0 && (params.MRD == 0 || maxDuration < params.MRD) {
+		params.MRD = maxDuration
+	}
+	renew, err := c.newMessage(dhcpv6.MessageTypeRenew, c.reply, true)
+	if err != nil {
+		return Config{}, err
+	}
+	renew.AddOption(dhcpv6.OptRequestedOption(c.requestedOptions()...))
+	c.addVendorOpts(renew)
+	c.addFQDN(renew)
+	c.addReconfigureAccept(renew)
+	if err := c.setTransactionID(renew); err != nil {
+		return Config{}, err
+	}
+	reply, err := c.sendReceiveParams(ctx, renew, dhcpv6.MessageTypeNone, params)
+	if err != nil {
+		return Config{}, err
+	}
+	if hasStatus(reply, iana.StatusNoBinding) {
+		if !reinstate {
+			return Config{}, &StatusError{Code: iana.StatusNoBinding}
+		}
+		return c.reinstate(ctx)
+	}
+	if err := bindingError(reply); err != nil {
+		return Config{}, err
+	}
+	return c.bind(reply, TransitionRenew), nil
+}
+
+// reinstate sends a Request for the current lease to the server which granted
+// it, after it replied NoBinding to a Renew. All IAs of the lease are
+// requested, not only those without binding, so that the Reply describes the
+// entire lease.
+func (c *Client) reinstate(ctx context.Context) (Config, error) {
+	c.log.Printf("server has no binding for our lease, sending Request")
+	_, reply, err := c.request(ctx, c.reply, c.retransmission[dhcpv6.MessageTypeRequest])
+	if err == nil {
+		err = bindingError(reply)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return Config{}, ctx.Err()
+		}
+		c.log.Printf("Request: %v, soliciting a new lease", err)
+		return c.obtainOrRenew(ctx, c.maxDuration)
+	}
+	return c.bind(reply, TransitionRequest), nil
+}
+
+// ErrNotOnLink is returned by Confirm when a server determined that the
+// current lease is not appropriate for the link the client is attached to.
+var ErrNotOnLink = errors.New("dhcp6: lease not on link (NotOnLink)")
+
+// Confirm asks any available server whether the current lease is still
+// appropriate for the link, e.g. after the link went down and up again. A nil
+// error means the lease can continue to be used, which includes the case
+// where no server replied. ErrNotOnLink means the caller should obtain a new
+// lease via ObtainOrRenewErr.
+//
+// A Confirm message can only carry addresses (RFC 8415, section 18.2.3), so
+// a lease with delegated prefixes is verified via Rebind instead, using the
+// retransmission parameters of Confirm (section 18.2.12). A successful Rebind
+// also extends the lease; a Rebind which does not yield a binding results in
+// ErrNotOnLink.
+func (c *Client) Confirm(ctx context.Context) error {
+	if c.reply == nil {
+		return fmt.Errorf("no lease to confirm")
+	}
+	if hasPrefixes(c.reply) {
+		return c.confirmPrefixes(ctx)
+	}
+	confirm, err := dhcpv6.NewMessage()
+	if err != nil {
+		return err
+	}
+	confirm.MessageType = dhcpv6.MessageTypeConfirm
+	confirm.AddOption(dhcpv6.OptClientID(*c.duid))
+	confirm.AddOption(dhcpv6.OptElapsedTime(0))
+	// The server ignores T1, T2 and lifetimes, so they are set to 0.
+	for _, ia := range c.reply.Options.IANA() {
+		confirmed := &dhcpv6.OptIANA{IaId: ia.IaId}
+		for _, addr := range ia.Options.Addresses() {
+			confirmed.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: addr.IPv6Addr})
+		}
+		confirm.AddOption(confirmed)
+	}
+	if err := c.setTransactionID(confirm); err != nil {
+		return err
+	}
+	reply, err := c.sendReceive(ctx, confirm, dhcpv6.MessageTypeNone)
+	if err != nil {
+		if errors.Is(err, ErrTimeout) {
+			// RFC 8415, section 18.2.3: When no server replies, the client
+			// continues to use its leases.
+			return nil
+		}
+		return err
+	}
+	if hasStatus(reply, iana.StatusNotOnLink) {
+		return ErrNotOnLink
+	}
+	c.confirmed()
+	return nil
+}
+
+// confirmPrefixes implements Confirm for leases with delegated prefixes.
+func (c *Client) confirmPrefixes(ctx context.Context) error {
+	cfg, err := c.rebindParams(ctx, c.retransmission[dhcpv6.MessageTypeConfirm])
+	if err == nil {
+		c.result(cfg, nil)
+		return nil
+	}
+	if errors.Is(err, ErrTimeout) {
+		return nil // like Confirm, see RFC 8415, section 18.2.12
+	}
+	var se *StatusError
+	if errors.As(err, &se) || err == ErrNoPrefixes {
+		c.log.Printf("Rebind: %v", err)
+		return ErrNotOnLink
+	}
+	return err
+}
+
+// hasPrefixes returns whether msg contains an IA_PD with a prefix.
+func hasPrefixes(msg *dhcpv6.Message) bool {
+	for _, ia := range msg.Options.IAPD() {
+		if len(ia.Options.Prefixes()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmed records that the current lease was confirmed.
+func (c *Client) confirmed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg.Transition = TransitionConfirm
+	c.cfg.TransitionAt = c.timeNow()
+}
+
+// InformationRequest obtains configuration parameters (as requested via
+// ClientConfig.ORO) without obtaining a lease, i.e. stateless
+// DHCPv6 (RFC 8415, section 18.2.6). Use it when addresses are configured
+// via SLAAC and no prefix delegation is required.
+//
+// The returned Config.RenewAfter is derived from the Information Refresh Time
+// option, and indicates when InformationRequest should be called again.
+func (c *Client) InformationRequest(ctx context.Context) (Config, error) {
+	inforeq, err := dhcpv6.NewMessage()
+	if err != nil {
+		return Config{}, err
+	}
+	inforeq.MessageType = dhcpv6.MessageTypeInformationRequest
+	inforeq.AddOption(dhcpv6.OptClientID(*c.duid))
+	inforeq.AddOption(dhcpv6.OptElapsedTime(0))
+	// RFC 8415, section 18.2.6: INF_MAX_RT and the Information Refresh Time
+	// must be requested.
+	oro := append(dhcpv6.OptionCodes(nil), c.requestedOptions()...)
+	oro.Add(dhcpv6.OptionInfMaxRT)
+	oro.Add(dhcpv6.OptionInformationRefreshTime)
+	inforeq.AddOption(dhcpv6.OptRequestedOption(oro...))
+	c.addVendorOpts(inforeq)
+	c.addReconfigureAccept(inforeq)
+	if err := c.setTransactionID(inforeq); err != nil {
+		return Config{}, err
+	}
+	reply, err := c.sendReceive(ctx, inforeq, dhcpv6.MessageTypeNone)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := c.configFromReply(reply, c.timeNow())
+	refresh := irtDefault
+	if opt := reply.GetOneOption(dhcpv6.OptionInformationRefreshTime); opt != nil {
+		if refresh, err = parseInformationRefreshTime(opt.ToBytes()); err != nil {
+			c.log.Printf("ignoring Information Refresh Time option: %v", err)
+			refresh = irtDefault
+		}
+	}
+	cfg.InformationRefreshTime = refresh
+	cfg.RenewAfter = c.timeNow().Add(refresh)
+	return cfg, nil
+}
+
+// Rebind extends the lifetimes of the current lease by multicasting a Rebind
+// message to any available server (RFC 8415, section 18.2.5). Clients Rebind
+// after T2 (Config.RebindAfter) when the granting server did not respond to
+// Renew. The exchange fails once the valid lifetimes of all bound IAs expired.
+func (c *Client) Rebind(ctx context.Context) (Config, error) {
+	return c.result(c.rebind(ctx, 0))
+}
+
+// rebind implements Rebind. If maxDuration is non-zero, the exchange is
+// aborted after maxDuration even if the lease did not yet expire.
+func (c *Client) rebind(ctx context.Context, maxDuration time.Duration) (Config, error) {
+	params := c.retransmission[dhcpv6.MessageTypeRebind]
+	if maxDuration > 0 && (params.MRD == 0 || maxDuration < params.MRD) {
+		params.MRD = maxDuration
+	}
+	return c.rebindParams(ctx, params)
+}
+
+// rebindParams is like rebind, but retransmits according to params. The
+// exchange is aborted after params.MRD, or once the lease expires if that is
+// earlier or params.MRD is 0.
+func (c *Client) rebindParams(ctx context.Context, params Retransmission) (Config, error) {
+	if c.reply == nil {
+		return Config{}, fmt.Errorf("no lease to rebind")
+	}
+	remaining := c.validUntil.Sub(c.timeNow())
+	if remaining <= 0 {
+		return Config{}, fmt.Errorf("lease expired at %v", c.validUntil)
+	}
+	rebind, err := c.newMessage(dhcpv6.MessageTypeRebind, c.reply, false)
+	if err != nil {
+		return Config{}, err
+	}
+	rebind.AddOption(dhcpv6.OptRequestedOption(c.requestedOptions()...))
+	c.addVendorOpts(rebind)
+	c.addFQDN(rebind)
+	c.addReconfigureAccept(rebind)
+	if err := c.setTransactionID(rebind); err != nil {
+		return Config{}, err
+	}
+	if params.MRD == 0 || params.MRD > remaining {
+		params.MRD = remaining
+	}
+	reply, err := c.sendReceiveParams(ctx, rebind, dhcpv6.MessageTypeNone, params)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := bindingError(reply); err != nil {
+		return Config{}, err
+	}
+	return c.bind(reply, TransitionRebind), nil
+}
+
+// configFromReply returns the network configuration contained in reply,
+// which was received at now.
+func (c *Client) configFromReply(reply *dhcpv6.Message, now time.Time) Config {
+	var newCfg Config
+	renewAfter := func(t1, t2 time.Duration) {
+		if t1 < newCfg.T1 || newCfg.RenewAfter.IsZero() {
+			newCfg.T1 = t1
+			newCfg.RenewAfter = now.Add(t1)
 		}
+		if t2 < newCfg.T2 || newCfg.RebindAfter.IsZero() {
+			newCfg.T2 = t2
+			newCfg.RebindAfter = now.Add(t2)
+		}
+	}
+	// A server may list the same address or prefix more than once, e.g. in
+	// multiple IA_PD options. Entries are matched by network, so that the
+	// lifetimes of the last occurrence apply and no duplicates are added.
+	leaseIdx := make(map[string]int)
+	addLease := func(l Lease) (added bool) {
+		key := networkKey(l.Prefix)
+		if idx, ok := leaseIdx[key]; ok {
+			newCfg.Leases[idx] = l
+			return false
+		}
+		leaseIdx[key] = len(newCfg.Leases)
+		newCfg.Leases = append(newCfg.Leases, l)
+		return true
+	}
+	if sid := reply.Options.ServerID(); sid != nil {
+		newCfg.ServerID = sid.ToBytes()
+	}
+	for _, iana := range reply.Options.IANA() {
+		addrs := iana.Options.Addresses()
+		if len(addrs) == 0 {
+			continue
+		}
+		var leases []Lease
+		for _, addr := range addrs {
+			ipnet := net.IPNet{
+				IP:   addr.IPv6Addr,
+				Mask: net.CIDRMask(128, 128),
+			}
+			l := newLease(ipnet, addr.PreferredLifetime, addr.ValidLifetime, now)
+			leases = append(leases, l)
+			if addr.ValidLifetime == 0 {
+				// The server withdrew the lease, e.g. after renumbering, so
+				// the client must stop using it (RFC 8415, section
+				// 18.2.10.1). It still counts for the timers (see iaTimers).
+				continue
+			}
+			if addLease(l) {
+				newCfg.Addresses = append(newCfg.Addresses, ipnet)
+			}
+		}
+		renewAfter(c.adjustTimers("IA_NA", iana.T1, iana.T2, leases))
+	}
+	for _, iapd := range reply.Options.IAPD() {
+		var leases []Lease
+		delegation := Delegation{IAID: iapd.IaId}
+		delegated := make(map[string]bool)
 		for _, prefix := range iapd.Options.Prefixes() {
-			newCfg.Prefixes = append(newCfg.Prefixes, *prefix.Prefix)
+			if prefix.Prefix == nil {
+				continue
+			}
+			if err := validatePrefix(*prefix.Prefix); err != nil {
+				c.log.Printf("ignoring delegated prefix: %v", err)
+				continue
+			}
+			if ones, _ := prefix.Prefix.Mask.Size(); c.prefixLength > 0 && ones != c.prefixLength {
+				c.log.Printf("server delegated %v, which differs from the requested prefix length /%d", prefix.Prefix, c.prefixLength)
+			}
+			l := newLease(*prefix.Prefix, prefix.PreferredLifetime, prefix.ValidLifetime, now)
+			leases = append(leases, l)
+			if prefix.ValidLifetime == 0 {
+				continue // withdrawn, like addresses above
+			}
+			if key := networkKey(*prefix.Prefix); !delegated[key] {
+				delegated[key] = true
+				delegation.Prefixes = append(delegation.Prefixes, *prefix.Prefix)
+			}
+			if addLease(l) {
+				newCfg.Prefixes = append(newCfg.Prefixes, *prefix.Prefix)
+			}
+			excluded, err := pdExclude(prefix)
+			if err != nil {
+				c.log.Printf("ignoring invalid Prefix Exclude option: %v", err)
+			}
+			if excluded != nil {
+				newCfg.Excluded = append(newCfg.Excluded, *excluded)
+			}
 		}
+		renewAfter(c.adjustTimers("IA_PD", iapd.T1, iapd.T2, leases))
+		newCfg.Delegations = append(newCfg.Delegations, delegation)
+	}
+	newCfg.Infinite = newCfg.T1 == Infinity
+	if c.renewJitter > 0 && newCfg.T2 > newCfg.T1 {
+		// RFC 8415, section 18.2.4: the client sends Renew at some time
+		// between T1 and T2.
+		jitter := time.Duration(c.randFloat64() * c.renewJitter * float64(newCfg.T2-newCfg.T1))
+		newCfg.RenewAfter = newCfg.RenewAfter.Add(jitter)
 	}
 	for _, dns := range reply.Options.DNS() {
 		newCfg.DNS = append(newCfg.DNS, dns.String())
 	}
-	c.cfg = newCfg
-	return true
+	if opt := reply.GetOneOption(dhcpv6.OptionDomainSearchList); opt != nil {
+		domains, err := parseDomainSearchList(opt.ToBytes())
+		if err != nil {
+			c.log.Printf("ignoring invalid Domain Search List option: %v", err)
+		}
+		newCfg.DomainSearch = domains
+	}
+	for _, opt := range reply.GetOption(dhcpv6.OptionNTPServer) {
+		servers, err := parseNTPServer(opt.ToBytes())
+		if err != nil {
+			c.log.Printf("ignoring invalid NTP Server option: %v", err)
+			continue
+		}
+		newCfg.NTP = append(newCfg.NTP, servers...)
+	}
+	for _, vo := range reply.Options.VendorOpts() {
+		for _, opt := range vo.VendorOpts {
+			newCfg.VendorOpts = append(newCfg.VendorOpts, VendorOption{
+				EnterpriseNumber: vo.EnterpriseNumber,
+				Code:             uint16(opt.Code()),
+				Data:             opt.ToBytes(),
+			})
+		}
+	}
+	if opt := reply.GetOneOption(dhcpv6.OptionSNTPServerList); opt != nil {
+		servers, err := parseSNTPServers(opt.ToBytes())
+		if err != nil {
+			c.log.Printf("ignoring invalid SNTP Servers option: %v", err)
+		}
+		for _, server := range servers {
+			newCfg.NTP = append(newCfg.NTP, server.String())
+		}
+	}
+	if opt := reply.GetOneOption(dhcpv6.OptionFQDN); opt != nil {
+		flags, name, err := parseFQDN(opt.ToBytes())
+		if err != nil {
+			c.log.Printf("ignoring invalid Client FQDN option: %v", err)
+		} else {
+			newCfg.FQDN = name
+			newCfg.FQDNFlags = flags
+		}
+	}
+	newCfg.BootFileURL = reply.Options.BootFileURL()
+	newCfg.BootFileParams = reply.Options.BootFileParam()
+	// Servers may list the same entry in multiple options (e.g. an NTP
+	// server in both the NTP Server and SNTP Servers options), which must not
+	// end up in resolv.conf or Router Advertisements twice.
+	newCfg.DNS = uniqueStrings(newCfg.DNS)
+	newCfg.DomainSearch = uniqueStrings(newCfg.DomainSearch)
+	newCfg.NTP = uniqueStrings(newCfg.NTP)
+	newCfg.Excluded = uniqueIPNets(newCfg.Excluded)
+	return newCfg
+}
+
+// adjustTimers is like iaTimers, but logs when the server sent inconsistent
+// values.
+func (c *Client) adjustTimers(ia string, t1, t2 time.Duration, leases []Lease) (time.Duration, time.Duration) {
+	t1c, t2c := iaTimers(t1, t2, leases)
+	if t1 != 0 && t2 != 0 && (t1c != t1 || t2c != t2) {
+		c.log.Printf("%s: adjusted inconsistent T1 %v, T2 %v to %v, %v", ia, t1, t2, t1c, t2c)
+	}
+	return t1c, t2c
+}
+
+// validatePrefix returns an error if n cannot sensibly be delegated to the
+// client. Routing ::/0 downstream, for example, would capture all traffic.
+func validatePrefix(n net.IPNet) error {
+	ones, bits := n.Mask.Size()
+	if bits != 8*net.IPv6len || n.IP.To16() == nil || n.IP.To4() != nil {
+		return fmt.Errorf("%v: not an IPv6 prefix", n.String())
+	}
+	if ones < 1 || ones > 64 {
+		return fmt.Errorf("%v: invalid prefix length /%d", n.String(), ones)
+	}
+	network := n.IP.Mask(n.Mask)
+	switch {
+	case network.IsUnspecified():
+		return fmt.Errorf("%v: unspecified network", n.String())
+	case network.IsMulticast():
+		return fmt.Errorf("%v: multicast network", n.String())
+	case network.IsLinkLocalUnicast():
+		return fmt.Errorf("%v: link-local network", n.String())
+	}
+	return nil
+}
+
+// networkKey identifies the network of n, ignoring any host bits (e.g.
+// 2001:db8::1/64 and 2001:db8::/64 are the same network).
+func networkKey(n net.IPNet) string {
+	return (&net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask}).String()
+}
+
+// uniqueStrings returns ss without duplicates, retaining the first
+// occurrence of each entry.
+func uniqueStrings(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		result = append(result, s)
+	}
+	return result
 }
 
+// uniqueIPNets is like uniqueStrings, but for net.IPNet.
+func uniqueIPNets(nets []net.IPNet) []net.IPNet {
+	if nets == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	result := make([]net.IPNet, 0, len(nets))
+	for _, n := range nets {
+		if seen[n.String()] {
+			continue
+		}
+		seen[n.String()] = true
+		result = append(result, n)
+	}
+	return result
+}
+
+// Release returns the current lease to the server (RFC 8415, section
+// 18.2.7). The Release is retransmitted up to REL_MAX_RC times; as the client
+// must not use the lease afterwards either way, a missing Reply is not an
+// error, and reply is nil in that case. Once the Release was sent, the client
+// discards the lease (including the lease saved in ClientConfig.LeasePath),
+// so that the next ObtainOrRenewErr solicits a new one.
 func (c *Client) Release() (release *dhcpv6.Message, reply *dhcpv6.Message, err error) {
-	release, err = dhcpv6.NewRequestFromAdvertise(c.advertise, dhcpv6.WithClientID(*c.duid))
+	if c.reply == nil {
+		return nil, nil, fmt.Errorf("no lease to release")
+	}
+	release, err = c.newMessage(dhcpv6.MessageTypeRelease, c.reply, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Release all bound addresses and prefixes, but not the identity
+	// associations the server declined (which carry a status code instead).
+	release.Options.Del(dhcpv6.OptionIANA)
+	release.Options.Del(dhcpv6.OptionIAPD)
+	for _, ia := range identityAssociations(c.reply, true) {
+		release.AddOption(ia)
+	}
+
+	if err := c.setTransactionID(release); err != nil {
+		return nil, nil, err
+	}
+	reply, err = c.sendReceive(context.Background(), release, dhcpv6.MessageTypeNone)
+	if err != nil && !errors.Is(err, ErrTimeout) {
+		return release, nil, err
+	}
+	if err != nil {
+		c.log.Printf("no reply to Release, considering the lease released: %v", err)
+	}
+	c.unbind()
+	c.uninstallAddresses()
+	c.prefixHints = nil
+	c.lastPrefixes = nil
+	if c.leasePath != "" {
+		if err := os.Remove(c.leasePath); err != nil && !os.IsNotExist(err) {
+			c.log.Printf("removing saved lease: %v", err)
+		}
+	}
+	return release, reply, nil
+}
+
+// Decline informs the server that addrs, which must have been assigned via
+// IA_NA, are already in use on the link, e.g. because Duplicate Address
+// Detection failed (RFC 8415, section 18.2.8). The server's Reply is returned
+// so that the caller can verify the acknowledgement.
+func (c *Client) Decline(addrs []net.IP) (decline *dhcpv6.Message, reply *dhcpv6.Message, err error) {
+	if c.reply == nil {
+		return nil, nil, fmt.Errorf("no lease to decline addresses from")
+	}
+	sid := c.reply.GetOneOption(dhcpv6.OptionServerID)
+	if sid == nil {
+		return nil, nil, fmt.Errorf("Server ID missing in %v", c.reply.MessageType)
+	}
+	decline, err = dhcpv6.NewMessage()
 	if err != nil {
 		return nil, nil, err
 	}
-	release.MessageType = dhcpv6.MessageTypeRelease
+	decline.MessageType = dhcpv6.MessageTypeDecline
+	decline.AddOption(dhcpv6.OptClientID(*c.duid))
+	decline.AddOption(sid)
+	decline.AddOption(dhcpv6.OptElapsedTime(0))
+	declined := make(map[string]bool)
+	for _, ia := range c.reply.Options.IANA() {
+		declinedIA := &dhcpv6.OptIANA{IaId: ia.IaId}
+		for _, addr := range ia.Options.Addresses() {
+			for _, a := range addrs {
+				if addr.IPv6Addr.Equal(a) {
+					declinedIA.Options.Add(addr)
+					declined[a.String()] = true
+				}
+			}
+		}
+		if len(declinedIA.Options.Options) > 0 {
+			decline.AddOption(declinedIA)
+		}
+	}
+	for _, a := range addrs {
+		if !declined[a.String()] {
+			return nil, nil, fmt.Errorf("address %v was not assigned via IA_NA", a)
+		}
+	}
 
-	if len(c.transactionIDs) > 0 {
-		id := c.transactionIDs[0]
-		c.transactionIDs = c.transactionIDs[1:]
-		release.TransactionID = id
+	if err := c.setTransactionID(decline); err != nil {
+		return nil, nil, err
 	}
-	reply, err = c.sendReceive(release, dhcpv6.MessageTypeNone)
-	return release, reply, err
+	reply, err = c.sendReceive(context.Background(), decline, dhcpv6.MessageTypeNone)
+	return decline, reply, err
 }
 
 func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.err
 }
 
 func (c *Client) Config() Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.cfg
 }
+
+// LastAdvertiseOptions returns all options of the most recently received
+// Advertise message, including options which are not reflected in Config.
+// The options must not be modified.
+func (c *Client) LastAdvertiseOptions() dhcpv6.Options {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastAdvertise == nil {
+		return nil
+	}
+	return append(dhcpv6.Options(nil), c.lastAdvertise.Options.Options...)
+}
+
+// LastReplyOptions is like LastAdvertiseOptions, but for the most recently
+// received Reply message.
+func (c *Client) LastReplyOptions() dhcpv6.Options {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastReply == nil {
+		return nil
+	}
+	return append(dhcpv6.Options(nil), c.lastReply.Options.Options...)
+}
+
+// Exchange describes a message exchange with a server, for troubleshooting.
+// Byte counts are those of the DHCPv6 messages, excluding any relay
+// encapsulation.
+type Exchange struct {
+	// MessageType is the type of the message sent by the client.
+	MessageType dhcpv6.MessageType
+	// Sent is the time of the last transmission.
+	Sent      time.Time
+	SentBytes int
+	// Transmissions is 1 plus the number of retransmissions.
+	Transmissions int
+
+	// Received is the time the response arrived, or zero if none did.
+	Received      time.Time
+	ReceivedType  dhcpv6.MessageType
+	ReceivedBytes int
+	// ServerID identifies the server which sent the response.
+	ServerID *dhcpv6.Duid
+	// InterfaceID is the Interface-ID option echoed in the Relay-Reply which
+	// carried the response, if the client operates across a relay hop (see
+	// RelayConfig.InterfaceID).
+	InterfaceID []byte
+
+	// Err is the error the exchange failed with, if any.
+	Err error
+}
+
+// LastExchange returns the most recent exchange (e.g. the Renew and its
+// Reply), or a zero Exchange if no message was sent yet. Combined with the
+// Prometheus metrics, which are aggregated over all exchanges, this helps
+// diagnosing an unresponsive server.
+func (c *Client) LastExchange() Exchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastExchange
+}
+
+// AcquisitionDuration returns how long obtaining the most recent lease took,
+// from the first transmission of the Solicit until the Reply arrived, or zero
+// if no lease was obtained yet. Leases which were extended via Renew or
+// Rebind, or resumed from ClientConfig.LeasePath, do not change it. The
+// duration is also exported as the dhcp6_acquisition_duration_seconds metric.
+func (c *Client) AcquisitionDuration() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.acquisition
+}
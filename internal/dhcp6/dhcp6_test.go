@@ -15,14 +15,23 @@
 package dhcp6
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
 	"github.com/rtr7/router7/internal/testing/pcapreplayer"
 )
 
@@ -32,14 +41,24 @@ func TestDHCP6(t *testing.T) {
 		SolicitTID  dhcpv6.TransactionID
 		RequestTID  dhcpv6.TransactionID
 		Prefix      net.IPNet
+		Address     net.IPNet
 		Expiry      time.Duration
+		Rebind      time.Duration
+		ServerID    []byte
+		Preferred   time.Duration
+		Valid       time.Duration
 	}{
 		{
 			CaptureFile: "fiber7.pcap",
 			SolicitTID:  dhcpv6.TransactionID{0x48, 0xe5, 0x9e},
 			RequestTID:  dhcpv6.TransactionID{0x73, 0x8c, 0x3b},
 			Prefix:      mustParseCIDR("2a02:168:4a00::/48"),
+			Address:     mustParseCIDR("2a02:168:2000:5:add7:17aa:9163:8ef3/128"),
 			Expiry:      20 * time.Minute,
+			Rebind:      30 * time.Minute,
+			ServerID:    []byte{0x00, 0x01, 0x00, 0x01, 0x22, 0x43, 0x9e, 0x23, 0x52, 0x54, 0x00, 0xfa, 0xac, 0x14},
+			Preferred:   1 * time.Hour,
+			Valid:       24 * time.Hour,
 		},
 
 		{
@@ -47,7 +66,12 @@ func TestDHCP6(t *testing.T) {
 			SolicitTID:  dhcpv6.TransactionID{0x49, 0xb4, 0x8c},
 			RequestTID:  dhcpv6.TransactionID{0x49, 0xb4, 0x8c},
 			Prefix:      mustParseCIDR("2a02:168:4bf3::/48"),
+			Address:     mustParseCIDR("2a02:168:2000:5::1f/128"),
 			Expiry:      1000 * time.Second,
+			Rebind:      2000 * time.Second,
+			ServerID:    []byte{0x00, 0x01, 0x00, 0x01, 0x25, 0x60, 0x37, 0x3b, 0x52, 0x54, 0x00, 0xf9, 0x6d, 0x9c},
+			Preferred:   3000 * time.Second,
+			Valid:       4000 * time.Second,
 		},
 	} {
 		t.Run(tt.CaptureFile, func(t *testing.T) {
@@ -88,14 +112,45 @@ func TestDHCP6(t *testing.T) {
 			}
 			got := c.Config()
 			want := Config{
-				RenewAfter: now.Add(tt.Expiry),
+				RenewAfter:  now.Add(tt.Expiry),
+				RebindAfter: now.Add(tt.Rebind),
+				T1:          tt.Expiry,
+				T2:          tt.Rebind,
+				ServerID:    tt.ServerID,
+				Leases: []Lease{
+					{
+						Prefix:            tt.Address,
+						PreferredLifetime: tt.Preferred,
+						ValidLifetime:     tt.Valid,
+						PreferredUntil:    now.Add(tt.Preferred),
+						ValidUntil:        now.Add(tt.Valid),
+					},
+					{
+						Prefix:            tt.Prefix,
+						PreferredLifetime: tt.Preferred,
+						ValidLifetime:     tt.Valid,
+						PreferredUntil:    now.Add(tt.Preferred),
+						ValidUntil:        now.Add(tt.Valid),
+					},
+				},
 				Prefixes: []net.IPNet{
 					tt.Prefix,
 				},
+				Addresses: []net.IPNet{
+					tt.Address,
+				},
 				DNS: []string{
 					"2001:1620:2777:1::10",
 					"2001:1620:2777:2::20",
 				},
+				Delegations: []Delegation{
+					{
+						IAID:     [4]byte{0, 0, 0, 1},
+						Prefixes: []net.IPNet{tt.Prefix},
+					},
+				},
+				Transition:   TransitionSolicit,
+				TransitionAt: now,
 			}
 			if diff := cmp.Diff(want, got); diff != "" {
 				t.Fatalf("unexpected config: diff (-want +got):\n%s", diff)
@@ -111,3 +166,1487 @@ func mustParseCIDR(s string) net.IPNet {
 	}
 	return *net
 }
+
+// newTestServer returns a fake server which delegates prefix.
+func newTestServer(prefix net.IPNet) *dhcp6test.Server {
+	return dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes: []net.IPNet{prefix},
+	})
+}
+
+// modifyResponses passes the default responses of srv to Solicit, Request,
+// Renew and Rebind messages to modify before they are sent.
+func modifyResponses(srv *dhcp6test.Server, modify func(reply *dhcpv6.Message)) {
+	for _, mt := range []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind,
+	} {
+		srv.Handle(mt, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+			replies := srv.Respond(msg)
+			for _, reply := range replies {
+				modify(reply)
+			}
+			return replies
+		})
+	}
+}
+
+// dropAll discards all client messages of type mt, as if the server was
+// unreachable.
+func dropAll(srv *dhcp6test.Server, mt dhcpv6.MessageType) {
+	srv.Handle(mt, func(*dhcpv6.Message) []*dhcpv6.Message { return nil })
+}
+
+func newTestClient(t *testing.T, conn net.PacketConn) *Client {
+	t.Helper()
+	return newTestClientConfig(t, ClientConfig{Conn: conn})
+}
+
+// testClientConfig returns cfg with the interface and addresses of the test
+// clients.
+func testClientConfig(t *testing.T, cfg ClientConfig) ClientConfig {
+	t.Helper()
+	laddr, err := net.ResolveUDPAddr("udp6", "[fe80::42:aff:fea5:966e]:546")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.InterfaceName = "lo"
+	cfg.LocalAddr = laddr
+	cfg.HardwareAddr = []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66}
+	return cfg
+}
+
+func newTestClientConfig(t *testing.T, cfg ClientConfig) *Client {
+	t.Helper()
+	c, err := NewClient(testClientConfig(t, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast := make(map[dhcpv6.MessageType]Retransmission)
+	for typ, p := range defaultRetransmission {
+		p.IRT /= 100
+		p.MRT /= 100
+		p.MRD /= 100
+		fast[typ] = p
+	}
+	for typ, p := range cfg.Retransmission {
+		fast[typ] = p
+	}
+	c.retransmission = fast
+	return c
+}
+
+func TestReceiveBufferSize(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	// newLargeServer pads each message to more than 16 KiB, e.g. long DNS
+	// lists.
+	newLargeServer := func() *dhcp6test.Server {
+		srv := newTestServer(prefix)
+		modifyResponses(srv, func(reply *dhcpv6.Message) {
+			reply.AddOption(&dhcpv6.OptionGeneric{
+				OptionCode: dhcpv6.OptionCode(65000),
+				OptionData: make([]byte, 16384),
+			})
+		})
+		return srv
+	}
+
+	t.Run("Small", func(t *testing.T) {
+		logger := &recordingLogger{}
+		c := newTestClientConfig(t, ClientConfig{
+			Conn:              newLargeServer(),
+			ReceiveBufferSize: 8192,
+			Logger:            logger,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := c.ObtainOrRenewErr(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error: got %v, want %v", err, context.DeadlineExceeded)
+		}
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		if len(logger.printf) == 0 || !strings.Contains(logger.printf[0], "larger than ReceiveBufferSize") {
+			t.Errorf("truncation not logged: %q", logger.printf)
+		}
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		c := newTestClient(t, newLargeServer())
+
+		cfg, err := c.ObtainOrRenewErr(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+			t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+		}
+	})
+
+	if _, err := NewClient(ClientConfig{InterfaceName: "lo", ReceiveBufferSize: 65536}); err == nil {
+		t.Errorf("NewClient unexpectedly accepted ReceiveBufferSize 65536")
+	}
+}
+
+func TestRenewJitter(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:        newTestServer(prefix),
+		RenewJitter: 0.5,
+	})
+	now := time.Now()
+	c.timeNow = func() time.Time { return now }
+	c.randFloat64 = func() float64 { return 0.5 }
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The server sends T1 = 20m and T2 = 30m, so the Renew is delayed by a
+	// quarter of the 10m in between.
+	if got, want := cfg.RenewAfter, now.Add(20*time.Minute+150*time.Second); !got.Equal(want) {
+		t.Errorf("unexpected RenewAfter: got %v, want %v", got, want)
+	}
+	if got, want := cfg.T1, 20*time.Minute; got != want {
+		t.Errorf("unexpected T1: got %v, want %v", got, want)
+	}
+
+	if _, err := NewClient(ClientConfig{InterfaceName: "lo", RenewJitter: 1}); err == nil {
+		t.Errorf("NewClient unexpectedly accepted RenewJitter 1")
+	}
+}
+
+func TestLastOptions(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	unknown := &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionCode(65000),
+		OptionData: []byte("oddball"),
+	}
+	srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		replies[0].AddOption(unknown)
+		return replies
+	})
+	c := newTestClient(t, srv)
+	if got := c.LastReplyOptions(); got != nil {
+		t.Fatalf("LastReplyOptions before any exchange: got %v, want nil", got)
+	}
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := c.LastAdvertiseOptions().Get(dhcpv6.OptionServerID); len(got) != 1 {
+		t.Errorf("LastAdvertiseOptions: Server ID missing")
+	}
+	if got := c.LastAdvertiseOptions().Get(unknown.Code()); len(got) != 0 {
+		t.Errorf("LastAdvertiseOptions: unexpected option %v", got)
+	}
+	got := c.LastReplyOptions().Get(unknown.Code())
+	if len(got) != 1 || !bytes.Equal(got[0].ToBytes(), unknown.OptionData) {
+		t.Errorf("LastReplyOptions: got %v, want %v", got, unknown)
+	}
+}
+
+func TestLastExchange(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	c := newTestClient(t, srv)
+	if diff := cmp.Diff(Exchange{}, c.LastExchange()); diff != "" {
+		t.Fatalf("LastExchange before any exchange: diff (-want +got):\n%s", diff)
+	}
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ex := c.LastExchange()
+	if got, want := ex.MessageType, dhcpv6.MessageTypeRequest; got != want {
+		t.Errorf("MessageType: got %v, want %v", got, want)
+	}
+	if got, want := ex.ReceivedType, dhcpv6.MessageTypeReply; got != want {
+		t.Errorf("ReceivedType: got %v, want %v", got, want)
+	}
+	if got, want := ex.Transmissions, 1; got != want {
+		t.Errorf("Transmissions: got %d, want %d", got, want)
+	}
+	if ex.SentBytes == 0 || ex.ReceivedBytes == 0 {
+		t.Errorf("unexpected byte counts: sent %d, received %d", ex.SentBytes, ex.ReceivedBytes)
+	}
+	if ex.Received.Before(ex.Sent) {
+		t.Errorf("Received (%v) before Sent (%v)", ex.Received, ex.Sent)
+	}
+	if ex.ServerID == nil || !ex.ServerID.Equal(dhcp6test.DefaultServerID) {
+		t.Errorf("ServerID: got %v, want %v", ex.ServerID, dhcp6test.DefaultServerID)
+	}
+	if ex.Err != nil {
+		t.Errorf("Err: got %v, want nil", ex.Err)
+	}
+
+	srv.Drop(1) // force a retransmission
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	ex = c.LastExchange()
+	if got, want := ex.MessageType, dhcpv6.MessageTypeRenew; got != want {
+		t.Errorf("MessageType: got %v, want %v", got, want)
+	}
+	if got, want := ex.Transmissions, 2; got != want {
+		t.Errorf("Transmissions: got %d, want %d", got, want)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	var changes, leases []Config
+	var expired int
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:           srv,
+		OnConfigChange: func(cfg Config) { changes = append(changes, cfg) },
+		OnLease:        func(cfg Config) { leases = append(leases, cfg) },
+		OnExpired:      func() { expired++ },
+	})
+	now := time.Now()
+	c.timeNow = func() time.Time { return now }
+
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := len(changes), 1; got != want {
+		t.Fatalf("OnConfigChange calls after obtaining: got %d, want %d", got, want)
+	}
+
+	// Renewing the same lease later only moves the timers.
+	now = now.Add(25 * time.Minute)
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if got, want := len(changes), 1; got != want {
+		t.Fatalf("OnConfigChange calls after unchanged Renew: got %d, want %d", got, want)
+	}
+	if got, want := len(leases), 2; got != want {
+		t.Fatalf("OnLease calls after unchanged Renew: got %d, want %d", got, want)
+	}
+	if want := now.Add(20 * time.Minute); !leases[1].RenewAfter.Equal(want) {
+		t.Fatalf("OnLease: unexpected RenewAfter: got %v, want %v", leases[1].RenewAfter, want)
+	}
+
+	renumbered := mustParseCIDR("2a02:168:4b00::/48")
+	srv.Handle(dhcpv6.MessageTypeRebind, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		return newTestServer(renumbered).Respond(msg)
+	})
+	if _, err := c.Rebind(context.Background()); err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	if got, want := len(changes), 2; got != want {
+		t.Fatalf("OnConfigChange calls after new prefix: got %d, want %d", got, want)
+	}
+	if diff := cmp.Diff([]net.IPNet{renumbered}, changes[1].Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+
+	// The server grants a valid lifetime of 24h.
+	now = now.Add(25 * time.Hour)
+	if _, err := c.Rebind(context.Background()); err == nil {
+		t.Fatalf("Rebind of expired lease unexpectedly succeeded")
+	}
+	if got, want := expired, 1; got != want {
+		t.Fatalf("OnExpired calls: got %d, want %d", got, want)
+	}
+	if got := c.Config().Prefixes; len(got) != 0 {
+		t.Fatalf("expired lease not discarded: %v", got)
+	}
+}
+
+func TestConcurrentConfig(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	c := newTestClient(t, newTestServer(prefix))
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// Run with -race to detect unsynchronized accesses.
+			_ = c.Config()
+			_ = c.Err()
+			_ = c.LastReplyOptions()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestStatusError(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options []dhcpv6.Option
+	}{
+		{
+			name: "top-level",
+			options: []dhcpv6.Option{
+				&dhcpv6.OptStatusCode{
+					StatusCode:    iana.StatusNoPrefixAvail,
+					StatusMessage: "no prefixes available",
+				},
+			},
+		},
+
+		{
+			name: "IA_PD",
+			options: []dhcpv6.Option{
+				&dhcpv6.OptIAPD{
+					IaId: [4]byte{0, 0, 0, 1},
+					Options: dhcpv6.PDOptions{Options: dhcpv6.Options{
+						&dhcpv6.OptStatusCode{
+							StatusCode:    iana.StatusNoPrefixAvail,
+							StatusMessage: "no prefixes available",
+						},
+					}},
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+			srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+				adv := srv.Respond(msg)[0]
+				adv.Options.Del(dhcpv6.OptionIANA)
+				adv.Options.Del(dhcpv6.OptionIAPD)
+				for _, opt := range tt.options {
+					adv.AddOption(opt)
+				}
+				return []*dhcpv6.Message{adv}
+			})
+			c := newTestClient(t, srv)
+			_, err := c.ObtainOrRenewErr(context.Background())
+			var se *StatusError
+			if !errors.As(err, &se) {
+				t.Fatalf("ObtainOrRenewErr: got %v, want *StatusError", err)
+			}
+			if got, want := se.Code, iana.StatusNoPrefixAvail; got != want {
+				t.Errorf("unexpected status code: got %v, want %v", got, want)
+			}
+			if got, want := se.Message, "no prefixes available"; got != want {
+				t.Errorf("unexpected status message: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestServerIDMismatch(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	rogue := mustParseCIDR("2001:db8::/48")
+	rogueDUID := dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01},
+	}
+	roguesrv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		ServerID: &rogueDUID,
+		Prefixes: []net.IPNet{rogue},
+	})
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		// A reply from a server other than the one the Request was
+		// addressed to arrives first.
+		return append(roguesrv.Respond(msg), srv.Respond(msg)...)
+	})
+	c := newTestClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDisableIA(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	assigned := net.ParseIP("2a02:168:2000:5::1f")
+	for _, tt := range []struct {
+		name          string
+		cfg           ClientConfig
+		disabled      dhcpv6.OptionCode
+		wantPrefixes  []net.IPNet
+		wantAddresses []net.IPNet
+	}{
+		{
+			name:         "IA_NA",
+			cfg:          ClientConfig{DisableIANA: true},
+			disabled:     dhcpv6.OptionIANA,
+			wantPrefixes: []net.IPNet{prefix},
+		},
+
+		{
+			name:          "IA_PD",
+			cfg:           ClientConfig{DisableIAPD: true},
+			disabled:      dhcpv6.OptionIAPD,
+			wantAddresses: []net.IPNet{{IP: assigned, Mask: net.CIDRMask(128, 128)}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+				Prefixes:  []net.IPNet{prefix},
+				Addresses: []net.IP{assigned},
+			})
+			cfg := tt.cfg
+			cfg.Conn = srv
+			c := newTestClientConfig(t, cfg)
+			got, err := c.ObtainOrRenewErr(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := c.Renew(context.Background()); err != nil {
+				t.Fatalf("Renew: %v", err)
+			}
+			for _, msg := range srv.Received() {
+				if msg.GetOneOption(tt.disabled) != nil {
+					t.Errorf("%v unexpectedly contains %v", msg.MessageType, tt.disabled)
+				}
+			}
+			if diff := cmp.Diff(tt.wantPrefixes, got.Prefixes); diff != "" {
+				t.Errorf("unexpected prefixes: diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantAddresses, got.Addresses); diff != "" {
+				t.Errorf("unexpected addresses: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, cfg := range []ClientConfig{
+		{DisableIAPD: true, DisableIANA: true},
+		{DisableIAPD: true, IAPDs: 2},
+	} {
+		cfg.InterfaceName = "lo"
+		cfg.LocalAddr = &net.UDPAddr{IP: net.ParseIP("fe80::42:aff:fea5:966e"), Port: 546}
+		cfg.HardwareAddr = []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+		cfg.Conn = dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+		if _, err := NewClient(cfg); err == nil {
+			t.Errorf("NewClient(%+v) unexpectedly succeeded", cfg)
+		}
+	}
+}
+
+func TestIAID(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		iaid []byte
+		want [4]byte
+	}{
+		{
+			name: "default",
+			want: [4]byte{0x33, 0x44, 0x55, 0x66}, // from HardwareAddr
+		},
+
+		{
+			name: "pinned",
+			iaid: []byte{0, 0, 0, 1},
+			want: [4]byte{0, 0, 0, 1},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(mustParseCIDR("2a02:168:4a00::/48"))
+			c := newTestClientConfig(t, ClientConfig{
+				Conn: srv,
+				IAID: tt.iaid,
+			})
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			iapd := srv.Received()[0].Options.OneIAPD()
+			if iapd == nil {
+				t.Fatalf("Solicit does not contain IA_PD")
+			}
+			if got := iapd.IaId; got != tt.want {
+				t.Fatalf("unexpected IAID: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipleIAPD(t *testing.T) {
+	// The server delegates one prefix per IA_PD, in order.
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes: []net.IPNet{
+			mustParseCIDR("2a02:168:4a00::/56"),
+			mustParseCIDR("2a02:168:4b00::/60"),
+		},
+	})
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:        srv,
+		DisableIANA: true,
+		IAID:        []byte{0, 0, 0, 7},
+		IAPDs:       2,
+	})
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Delegation{
+		{
+			IAID:     [4]byte{0, 0, 0, 7},
+			Prefixes: []net.IPNet{mustParseCIDR("2a02:168:4a00::/56")},
+		},
+		{
+			IAID:     [4]byte{0, 0, 0, 8},
+			Prefixes: []net.IPNet{mustParseCIDR("2a02:168:4b00::/60")},
+		},
+	}
+	if diff := cmp.Diff(want, cfg.Delegations); diff != "" {
+		t.Fatalf("unexpected delegations: diff (-want +got):\n%s", diff)
+	}
+
+	release, _, err := c.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var released []Delegation
+	for _, iapd := range release.Options.IAPD() {
+		d := Delegation{IAID: iapd.IaId}
+		for _, prefix := range iapd.Options.Prefixes() {
+			d.Prefixes = append(d.Prefixes, *prefix.Prefix)
+		}
+		released = append(released, d)
+	}
+	if diff := cmp.Diff(want, released); diff != "" {
+		t.Fatalf("unexpected released IA_PDs: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPrefixLengthHint(t *testing.T) {
+	srv := newTestServer(mustParseCIDR("2a02:168:4a00::/56"))
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:         srv,
+		PrefixLength: 48,
+	})
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prefixes := srv.Received()[0].Options.OneIAPD().Options.Prefixes()
+
+	if len(prefixes) != 1 {
+		t.Fatalf("Solicit IA_PD contains %d IAPrefix options, want 1", len(prefixes))
+	}
+	if got, want := prefixes[0].Prefix.String(), "::/48"; got != want {
+		t.Fatalf("unexpected prefix hint: got %v, want %v", got, want)
+	}
+	// The server ignored the hint, which must not prevent obtaining a lease.
+	if diff := cmp.Diff([]net.IPNet{mustParseCIDR("2a02:168:4a00::/56")}, cfg.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	c := newTestClient(t, srv)
+
+	if _, err := c.Renew(context.Background()); err == nil {
+		t.Fatalf("Renew() without lease unexpectedly succeeded")
+	}
+
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := c.Renew(context.Background())
+	if err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	received := srv.Received()
+	renew := received[len(received)-1]
+	if sid := renew.Options.ServerID(); sid == nil || !sid.Equal(dhcp6test.DefaultServerID) {
+		t.Fatalf("Renew does not contain the granting Server ID: %v", renew.Summary())
+	}
+
+	srv.RespondWithStatus(dhcpv6.MessageTypeRenew, iana.StatusNoBinding)
+	cfg, err = c.Renew(context.Background())
+	if err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if got, want := cfg.Transition, TransitionRequest; got != want {
+		t.Errorf("unexpected transition: got %v, want %v", got, want)
+	}
+	received = srv.Received()
+	request := received[len(received)-1]
+	if sid := request.Options.ServerID(); sid == nil || !sid.Equal(dhcp6test.DefaultServerID) {
+		t.Errorf("Request does not contain the granting Server ID: %v", request.Summary())
+	}
+	if got := request.Options.OneIAPD(); got == nil || len(got.Options.Prefixes()) != 1 {
+		t.Errorf("Request does not contain the IA_PD of the lease: %v", request.Summary())
+	}
+
+	// If the server does not reinstate the binding either, a new lease is
+	// solicited (whose Request this server refuses as well).
+	srv.RespondWithStatus(dhcpv6.MessageTypeRenew, iana.StatusNoBinding)
+	srv.RespondWithStatus(dhcpv6.MessageTypeRequest, iana.StatusNoBinding)
+	srv.RespondWithStatus(dhcpv6.MessageTypeRequest, iana.StatusNoBinding)
+	if _, err := c.Renew(context.Background()); err == nil {
+		t.Fatalf("Renew unexpectedly succeeded")
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRequest, // reinstating after NoBinding
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeSolicit, // fallback after NoBinding to Request
+		dhcpv6.MessageTypeRequest,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenewIdentityAssociations(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	// The server declines the IA_NA with NoAddrsAvail, and sets T1 and T2
+	// in both identity associations.
+	srv := newTestServer(prefix)
+	c := newTestClient(t, srv)
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if _, err := c.Rebind(context.Background()); err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	for _, msg := range srv.Received() {
+		if msg.MessageType != dhcpv6.MessageTypeRenew && msg.MessageType != dhcpv6.MessageTypeRebind {
+			continue
+		}
+		for _, ia := range msg.Options.IANA() {
+			if ia.T1 != 0 || ia.T2 != 0 || ia.Options.Status() != nil {
+				t.Errorf("%v: IA_NA with T1/T2 or Status Code: %v", msg.MessageType, ia)
+			}
+		}
+		iapds := msg.Options.IAPD()
+		if len(iapds) != 1 {
+			t.Fatalf("%v: got %d IA_PDs, want 1", msg.MessageType, len(iapds))
+		}
+		ia := iapds[0]
+		if ia.T1 != 0 || ia.T2 != 0 || ia.Options.Status() != nil {
+			t.Errorf("%v: IA_PD with T1/T2 or Status Code: %v", msg.MessageType, ia)
+		}
+		if prefixes := ia.Options.Prefixes(); len(prefixes) != 1 || prefixes[0].Prefix.String() != prefix.String() {
+			t.Errorf("%v: IA_PD does not contain the bound prefix: %v", msg.MessageType, ia)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	// Leases with delegated prefixes are confirmed via Rebind, so the lease
+	// consists of an address.
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Addresses: []net.IP{net.ParseIP("2a02:168:4a00::42")},
+	})
+	c := newTestClient(t, srv)
+	now := time.Now()
+	c.timeNow = func() time.Time { return now }
+	check := func(want Transition) {
+		t.Helper()
+		cfg := c.Config()
+		if cfg.Transition != want {
+			t.Errorf("Transition: got %v, want %v", cfg.Transition, want)
+		}
+		if !cfg.TransitionAt.Equal(now) {
+			t.Errorf("TransitionAt: got %v, want %v", cfg.TransitionAt, now)
+		}
+	}
+	ctx := context.Background()
+	if _, err := c.ObtainOrRenewErr(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check(TransitionSolicit)
+
+	now = now.Add(1 * time.Minute)
+	if _, err := c.Renew(ctx); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	check(TransitionRenew)
+
+	now = now.Add(1 * time.Minute)
+	if _, err := c.Rebind(ctx); err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	check(TransitionRebind)
+
+	now = now.Add(1 * time.Minute)
+	if err := c.Confirm(ctx); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	check(TransitionConfirm)
+
+	b, err := json.Marshal(c.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"transition":"confirm"`)) {
+		t.Errorf("transition not encoded by name: %s", b)
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Transition, TransitionConfirm; got != want {
+		t.Errorf("decoded Transition: got %v, want %v", got, want)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, tt := range []struct {
+		name           string
+		prefixes       bool                  // whether the lease contains prefix
+		status         *dhcpv6.OptStatusCode // nil means no reply
+		want           error
+		wantType       dhcpv6.MessageType
+		wantTransition Transition
+	}{
+		{
+			name:           "addresses/success",
+			status:         &dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess},
+			wantType:       dhcpv6.MessageTypeConfirm,
+			wantTransition: TransitionConfirm,
+		},
+
+		{
+			name:     "addresses/notonlink",
+			status:   &dhcpv6.OptStatusCode{StatusCode: iana.StatusNotOnLink},
+			want:     ErrNotOnLink,
+			wantType: dhcpv6.MessageTypeConfirm,
+		},
+
+		{
+			name:           "addresses/noreply",
+			wantType:       dhcpv6.MessageTypeConfirm,
+			wantTransition: TransitionSolicit,
+		},
+
+		// Delegated prefixes are verified via Rebind (RFC 8415, section
+		// 18.2.12).
+		{
+			name:           "prefixes/success",
+			prefixes:       true,
+			status:         &dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess},
+			wantType:       dhcpv6.MessageTypeRebind,
+			wantTransition: TransitionRebind,
+		},
+
+		{
+			name:     "prefixes/nobinding",
+			prefixes: true,
+			status:   &dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding},
+			want:     ErrNotOnLink,
+			wantType: dhcpv6.MessageTypeRebind,
+		},
+
+		{
+			name:           "prefixes/noreply",
+			prefixes:       true,
+			wantType:       dhcpv6.MessageTypeRebind,
+			wantTransition: TransitionSolicit,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(prefix)
+			if !tt.prefixes {
+				srv = dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+					Addresses: []net.IP{net.ParseIP("2a02:168:4a00::42")},
+				})
+			}
+			switch {
+			case tt.status == nil:
+				dropAll(srv, tt.wantType)
+			case tt.status.StatusCode != iana.StatusSuccess:
+				srv.RespondWithStatus(tt.wantType, tt.status.StatusCode)
+			}
+			c := newTestClient(t, srv)
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := c.Confirm(context.Background()); err != tt.want {
+				t.Fatalf("Confirm() = %v, want %v", err, tt.want)
+			}
+			received := srv.Received()
+			if got := received[len(received)-1].MessageType; got != tt.wantType {
+				t.Errorf("unexpected message type: got %v, want %v", got, tt.wantType)
+			}
+			for _, msg := range received {
+				if msg.MessageType != dhcpv6.MessageTypeConfirm {
+					continue
+				}
+				if msg.Options.OneIAPD() != nil {
+					t.Errorf("Confirm contains an IA_PD: %v", msg.Summary())
+				}
+				if ia := msg.Options.OneIANA(); ia == nil || len(ia.Options.Addresses()) != 1 {
+					t.Errorf("Confirm does not contain the bound IA_NA: %v", msg.Summary())
+				}
+			}
+			if tt.want != nil {
+				return
+			}
+			if got := c.Config().Transition; got != tt.wantTransition {
+				t.Errorf("Transition: got %v, want %v", got, tt.wantTransition)
+			}
+		})
+	}
+}
+
+func TestDecline(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:  []net.IPNet{prefix},
+		Addresses: []net.IP{net.ParseIP("2a02:168:4a00::42")},
+	})
+	c := newTestClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Addresses) != 1 {
+		t.Fatalf("unexpected addresses: got %v, want exactly one", cfg.Addresses)
+	}
+	addr := cfg.Addresses[0].IP
+
+	if _, _, err := c.Decline([]net.IP{net.ParseIP("2001:db8::1")}); err == nil {
+		t.Fatalf("Decline(2001:db8::1) unexpectedly succeeded")
+	}
+
+	_, reply, err := c.Decline([]net.IP{addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.MessageType, dhcpv6.MessageTypeReply; got != want {
+		t.Errorf("unexpected reply type: got %v, want %v", got, want)
+	}
+	var declined []net.IP
+	for _, msg := range srv.Received() {
+		if msg.MessageType != dhcpv6.MessageTypeDecline {
+			continue
+		}
+		for _, ia := range msg.Options.IANA() {
+			for _, addr := range ia.Options.Addresses() {
+				declined = append(declined, addr.IPv6Addr)
+			}
+		}
+	}
+	if len(declined) != 1 || !declined[0].Equal(addr) {
+		t.Errorf("unexpected declined addresses: got %v, want [%v]", declined, addr)
+	}
+}
+
+func TestInformationRequest(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name    string
+		refresh []byte // Information Refresh Time option, if non-nil
+		want    time.Duration
+	}{
+		{
+			name: "default",
+			want: 86400 * time.Second,
+		},
+
+		{
+			name:    "refresh",
+			refresh: []byte{0, 0, 0x0e, 0x10}, // 3600s
+			want:    1 * time.Hour,
+		},
+
+		{
+			name:    "minimum",
+			refresh: []byte{0, 0, 0, 0x3c}, // 60s
+			want:    600 * time.Second,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+			srv.Handle(dhcpv6.MessageTypeInformationRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+				if len(msg.Options.IANA()) > 0 || len(msg.Options.IAPD()) > 0 {
+					t.Errorf("Information-Request unexpectedly contains IA options: %v", msg.Summary())
+				}
+				if !msg.IsOptionRequested(dhcpv6.OptionSNTPServerList) ||
+					!msg.IsOptionRequested(dhcpv6.OptionNTPServer) {
+					t.Errorf("Information-Request does not request NTP servers: %v", msg.Summary())
+				}
+				reply, err := dhcpv6.NewReplyFromMessage(msg,
+					dhcpv6.WithServerID(dhcp6test.DefaultServerID),
+					dhcpv6.WithDNS(net.ParseIP("2001:db8::53")),
+					dhcpv6.WithDomainSearchList("example.net", "lan"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				reply.AddOption(&dhcpv6.OptionGeneric{
+					OptionCode: dhcpv6.OptionNTPServer,
+					OptionData: append([]byte{0, ntpSuboptionSrvFQDN, 0, 15},
+						"\x03ntp\x05init7\x03net\x00"...),
+				})
+				reply.AddOption(&dhcpv6.OptionGeneric{
+					OptionCode: dhcpv6.OptionSNTPServerList,
+					OptionData: net.ParseIP("2001:db8::123"),
+				})
+				if tt.refresh != nil {
+					reply.AddOption(&dhcpv6.OptionGeneric{
+						OptionCode: dhcpv6.OptionInformationRefreshTime,
+						OptionData: tt.refresh,
+					})
+				}
+				// The CAPWAP Access Controller option does not affect the
+				// refresh time.
+				reply.AddOption(&dhcpv6.OptionGeneric{
+					OptionCode: dhcpv6.OptionCAPWAPAccessControllerAddresses,
+					OptionData: net.ParseIP("2001:db8::5247"),
+				})
+				return []*dhcpv6.Message{reply}
+			})
+			c := newTestClient(t, srv)
+
+			c.timeNow = func() time.Time { return now }
+			got, err := c.InformationRequest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Config{
+				RenewAfter:             now.Add(tt.want),
+				ServerID:               dhcp6test.DefaultServerID.ToBytes(),
+				DNS:                    []string{"2001:db8::53"},
+				DomainSearch:           []string{"example.net", "lan"},
+				NTP:                    []string{"ntp.init7.net", "2001:db8::123"},
+				InformationRefreshTime: tt.want,
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("unexpected config: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	dns := net.ParseIP("2001:db8::53")
+	ntp := net.ParseIP("2001:db8::123")
+	modifyResponses(srv, func(reply *dhcpv6.Message) {
+		reply.AddOption(dhcpv6.OptDNS(dns, dns))
+		reply.AddOption(dhcpv6.OptDNS(dns))
+		reply.AddOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionNTPServer,
+			// NTP_SUBOPTION_SRV_ADDR
+			OptionData: append([]byte{0, 1, 0, 16}, ntp...),
+		})
+		reply.AddOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionSNTPServerList,
+			OptionData: ntp,
+		})
+		// The same network (with host bits set), with updated lifetimes, in
+		// a second IA_PD, which replaces the default response once the
+		// client renews it.
+		second := &dhcpv6.OptIAPD{
+			IaId: [4]byte{0, 0, 0, 2},
+			T1:   20 * time.Minute,
+			T2:   30 * time.Minute,
+			Options: dhcpv6.PDOptions{Options: dhcpv6.Options{
+				&dhcpv6.OptIAPrefix{
+					PreferredLifetime: 2 * time.Hour,
+					ValidLifetime:     48 * time.Hour,
+					Prefix: &net.IPNet{
+						IP:   net.ParseIP("2a02:168:4a00::1"),
+						Mask: prefix.Mask,
+					},
+				},
+			}},
+		}
+		iapds := reply.Options.IAPD()
+		reply.Options.Del(dhcpv6.OptionIAPD)
+		for _, iapd := range iapds {
+			if iapd.IaId != second.IaId {
+				reply.AddOption(iapd)
+			}
+		}
+		reply.AddOption(second)
+	})
+
+	c := newTestClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Renewals must not accumulate entries either.
+	for i := 0; i < 2; i++ {
+		if cfg, err = c.Renew(context.Background()); err != nil {
+			t.Fatalf("Renew: %v", err)
+		}
+	}
+	if diff := cmp.Diff([]string{dns.String()}, cfg.DNS); diff != "" {
+		t.Errorf("unexpected DNS: diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{ntp.String()}, cfg.NTP); diff != "" {
+		t.Errorf("unexpected NTP: diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Errorf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	if got, want := len(cfg.Leases), 1; got != want {
+		t.Fatalf("unexpected number of leases: got %d, want %d", got, want)
+	}
+	if got, want := cfg.Leases[0].ValidLifetime, 48*time.Hour; got != want {
+		t.Errorf("unexpected valid lifetime: got %v, want %v", got, want)
+	}
+	// Each IA_PD still reports its delegation.
+	if got, want := len(cfg.Delegations), 2; got != want {
+		t.Errorf("unexpected number of delegations: got %d, want %d", got, want)
+	}
+}
+
+func TestInvalidPrefix(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	modifyResponses(srv, func(reply *dhcpv6.Message) {
+		iapd := reply.Options.OneIAPD()
+		for _, invalid := range []string{"::/0", "ff02::/16", "fe80::/64"} {
+			n := mustParseCIDR(invalid)
+			iapd.Options.Add(&dhcpv6.OptIAPrefix{
+				PreferredLifetime: 1 * time.Hour,
+				ValidLifetime:     24 * time.Hour,
+				Prefix:            &n,
+			})
+		}
+	})
+	c := newTestClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Errorf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	if got, want := len(cfg.Leases), 1; got != want {
+		t.Errorf("unexpected number of leases: got %d, want %d", got, want)
+	}
+	if len(cfg.Delegations) != 1 {
+		t.Fatalf("unexpected delegations: %v", cfg.Delegations)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Delegations[0].Prefixes); diff != "" {
+		t.Errorf("unexpected delegated prefixes: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPrefixChange(t *testing.T) {
+	oldPrefix := mustParseCIDR("2a02:168:4a00::/48")
+	newPrefix := mustParseCIDR("2a02:168:4b00::/48")
+	srv := newTestServer(oldPrefix)
+	type change struct{ old, new []net.IPNet }
+	var changes []change
+	c := newTestClientConfig(t, ClientConfig{
+		Conn: srv,
+		OnPrefixChange: func(old, new []net.IPNet) {
+			changes = append(changes, change{old, new})
+		},
+	})
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) > 0 {
+		t.Fatalf("OnPrefixChange unexpectedly called: %v", changes)
+	}
+
+	// The server renumbers the client.
+	srv.Handle(dhcpv6.MessageTypeRenew, newTestServer(newPrefix).Respond)
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []change{{[]net.IPNet{oldPrefix}, []net.IPNet{newPrefix}}}
+	if diff := cmp.Diff(want, changes, cmp.AllowUnexported(change{})); diff != "" {
+		t.Fatalf("unexpected prefix changes: diff (-want +got):\n%s", diff)
+	}
+
+	// The first lease after Release is not a change.
+	if _, _, err := c.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, changes, cmp.AllowUnexported(change{})); diff != "" {
+		t.Fatalf("unexpected prefix changes: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestNoPrefixes(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, tt := range []struct {
+		name     string
+		withdraw func(iapd *dhcpv6.OptIAPD)
+	}{
+		{
+			name: "empty",
+			withdraw: func(iapd *dhcpv6.OptIAPD) {
+				iapd.Options.Del(dhcpv6.OptionIAPrefix)
+			},
+		},
+
+		{
+			name: "expired",
+			withdraw: func(iapd *dhcpv6.OptIAPD) {
+				for _, p := range iapd.Options.Prefixes() {
+					p.PreferredLifetime = 0
+					p.ValidLifetime = 0
+				}
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(prefix)
+			var withdrawn bool
+			modifyResponses(srv, func(reply *dhcpv6.Message) {
+				if withdrawn {
+					tt.withdraw(reply.Options.OneIAPD())
+				}
+			})
+			// Without an IA_NA, which the server declines, the lease
+			// consists of the delegated prefix only.
+			c := newTestClientConfig(t, ClientConfig{
+				Conn:        srv,
+				DisableIANA: true,
+			})
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			withdrawn = true
+			if _, err := c.Renew(context.Background()); err != ErrNoPrefixes {
+				t.Fatalf("Renew: got %v, want %v", err, ErrNoPrefixes)
+			}
+			if _, err := c.Rebind(context.Background()); err != ErrNoPrefixes {
+				t.Fatalf("Rebind: got %v, want %v", err, ErrNoPrefixes)
+			}
+			c.unbind()
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != ErrNoPrefixes {
+				t.Fatalf("ObtainOrRenewErr: got %v, want %v", err, ErrNoPrefixes)
+			}
+		})
+	}
+}
+
+func TestValidatePrefix(t *testing.T) {
+	for _, tt := range []struct {
+		prefix string
+		valid  bool
+	}{
+		{"2a02:168:4a00::/48", true},
+		{"2001:db8::/64", true},
+		{"2000::/3", true},
+		{"::/0", false},
+		{"::/16", false},
+		{"2001:db8::/65", false},
+		{"2001:db8::1/128", false},
+		{"ff00::/8", false},
+		{"fe80::/10", false},
+		{"fe80::/64", false},
+		{"192.168.0.0/24", false},
+	} {
+		t.Run(tt.prefix, func(t *testing.T) {
+			err := validatePrefix(mustParseCIDR(tt.prefix))
+			if got := err == nil; got != tt.valid {
+				t.Fatalf("validatePrefix(%v) = %v, want valid = %v", tt.prefix, err, tt.valid)
+			}
+		})
+	}
+}
+
+// addrConn is a dhcp6test.Server which records the destination addresses of
+// written messages.
+type addrConn struct {
+	*dhcp6test.Server
+	addrs []string
+}
+
+func (ac *addrConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ac.addrs = append(ac.addrs, addr.String())
+	return ac.Server.WriteTo(b, addr)
+}
+
+func TestServerUnicast(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	serverAddr := net.ParseIP("2001:db8::547")
+	unicast := "[2001:db8::547]:547"
+	multicast := "[ff02::1:2]:547"
+	withdrawn := false
+	conn := &addrConn{Server: newTestServer(prefix)}
+	modifyResponses(conn.Server, func(reply *dhcpv6.Message) {
+		if reply.MessageType == dhcpv6.MessageTypeReply && !withdrawn {
+			reply.AddOption(&dhcpv6.OptionGeneric{
+				OptionCode: dhcpv6.OptionUnicast,
+				OptionData: serverAddr,
+			})
+		}
+	})
+	c := newTestClient(t, conn)
+	ctx := context.Background()
+	if _, err := c.ObtainOrRenewErr(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Renew(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The server withdraws the Server Unicast option and rejects the next
+	// Renew, which is still unicast.
+	withdrawn = true
+	conn.RespondWithStatus(dhcpv6.MessageTypeRenew, iana.StatusUseMulticast)
+	cfg, err := c.Renew(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	if _, err := c.Renew(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		multicast, // Solicit
+		multicast, // Request
+		unicast,   // Renew
+		unicast,   // Renew, answered with UseMulticast
+		multicast, // Renew, resent
+		multicast, // Renew
+	}
+	if diff := cmp.Diff(want, conn.addrs); diff != "" {
+		t.Fatalf("unexpected destinations: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	dropAll(srv, dhcpv6.MessageTypeRelease) // all Replies to Release are lost
+	dir, err := ioutil.TempDir("", "dhcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	leasePath := filepath.Join(dir, "lease.json")
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:      srv,
+		LeasePath: leasePath,
+	})
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(leasePath); err != nil {
+		t.Fatal(err)
+	}
+	_, reply, err := c.Release()
+	if err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if reply != nil {
+		t.Errorf("unexpected reply: %v", reply)
+	}
+	var releases int
+	for _, typ := range srv.ReceivedTypes() {
+		if typ == dhcpv6.MessageTypeRelease {
+			releases++
+		}
+	}
+	if got, want := releases, defaultRetransmission[dhcpv6.MessageTypeRelease].MRC; got != want {
+		t.Errorf("unexpected number of transmissions: got %d, want %d", got, want)
+	}
+	if diff := cmp.Diff(Config{}, c.Config()); diff != "" {
+		t.Errorf("unexpected config after Release: diff (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(leasePath); !os.IsNotExist(err) {
+		t.Errorf("saved lease not removed: %v", err)
+	}
+	if _, _, err := c.Release(); err == nil {
+		t.Errorf("second Release unexpectedly succeeded")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	c := newTestClient(t, srv)
+	now := time.Now()
+	c.timeNow = func() time.Time { return now }
+
+	if _, err := c.Rebind(context.Background()); err == nil {
+		t.Fatalf("Rebind() without lease unexpectedly succeeded")
+	}
+
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now = now.Add(30 * time.Minute) // T2
+	cfg, err := c.Rebind(context.Background())
+	if err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	received := srv.Received()
+	rebind := received[len(received)-1]
+	if got, want := rebind.MessageType, dhcpv6.MessageTypeRebind; got != want {
+		t.Fatalf("unexpected message type: got %v, want %v", got, want)
+	}
+	if rebind.GetOneOption(dhcpv6.OptionServerID) != nil {
+		t.Fatalf("Rebind unexpectedly contains a Server ID")
+	}
+	if iapd := rebind.Options.OneIAPD(); iapd == nil || len(iapd.Options.Prefixes()) != 1 {
+		t.Fatalf("Rebind does not contain the bound IA_PD: %v", rebind.Summary())
+	}
+	if want := now.Add(20 * time.Minute); !cfg.RenewAfter.Equal(want) {
+		t.Fatalf("unexpected RenewAfter: got %v, want %v", cfg.RenewAfter, want)
+	}
+	if want := now.Add(30 * time.Minute); !cfg.RebindAfter.Equal(want) {
+		t.Fatalf("unexpected RebindAfter: got %v, want %v", cfg.RebindAfter, want)
+	}
+
+	now = now.Add(25 * time.Hour) // past the valid lifetime
+	if _, err := c.Rebind(context.Background()); err == nil {
+		t.Fatalf("Rebind() of expired lease unexpectedly succeeded")
+	}
+}
+
+func TestTransactionIDs(t *testing.T) {
+	ids := []dhcpv6.TransactionID{
+		{0x01, 0x02, 0x03}, // Solicit
+		{0x04, 0x05, 0x06}, // Request
+		{0x07, 0x08, 0x09}, // Renew
+	}
+	var next int
+	for _, tt := range []struct {
+		name string
+		cfg  ClientConfig
+	}{
+		{
+			name: "TransactionIDs",
+			cfg:  ClientConfig{TransactionIDs: ids},
+		},
+
+		{
+			name: "TransactionIDFunc",
+			cfg: ClientConfig{
+				TransactionIDFunc: func() (dhcpv6.TransactionID, error) {
+					if next == len(ids) {
+						return dhcpv6.TransactionID{}, errors.New("out of IDs")
+					}
+					next++
+					return ids[next-1], nil
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(mustParseCIDR("2a02:168:4a00::/48"))
+			srv.Drop(1) // simulate packet loss, the Solicit is retransmitted
+			cfg := tt.cfg
+			cfg.Conn = srv
+			c := newTestClientConfig(t, cfg)
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := c.Renew(context.Background()); err != nil {
+				t.Fatalf("Renew: %v", err)
+			}
+			var xids []dhcpv6.TransactionID
+			for _, msg := range srv.Received() {
+				xids = append(xids, msg.TransactionID)
+			}
+			want := []dhcpv6.TransactionID{ids[0], ids[0], ids[1], ids[2]}
+			if diff := cmp.Diff(want, xids); diff != "" {
+				t.Errorf("unexpected transaction IDs: diff (-want +got):\n%s", diff)
+			}
+			if _, err := c.Renew(context.Background()); err == nil {
+				t.Errorf("Renew unexpectedly succeeded without transaction IDs left")
+			}
+			if got, want := len(srv.Received()), len(want); got != want {
+				t.Errorf("unexpected number of messages sent: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestIgnoreClientMessages(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+	srv.Handle(dhcpv6.MessageTypeInformationRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		// The message is looped back before the server replies.
+		return append([]*dhcpv6.Message{msg}, srv.Respond(msg)...)
+	})
+	logger := &recordingLogger{}
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:   srv,
+		Logger: logger,
+	})
+	if _, err := c.InformationRequest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	want := "ignoring client message INFORMATION-REQUEST"
+	for _, msg := range logger.debug {
+		if msg == want {
+			return
+		}
+	}
+	t.Errorf("looped back message not ignored: debug messages %q do not contain %q", logger.debug, want)
+}
+
+func TestSendReceive(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+	c := newTestClient(t, srv)
+
+	for _, tt := range []struct {
+		typ          dhcpv6.MessageType
+		expectedType dhcpv6.MessageType
+	}{
+		{dhcpv6.MessageTypeInformationRequest, dhcpv6.MessageTypeNone},
+		{dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeNone},
+		{dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeReply},
+	} {
+		srv.Handle(tt.typ, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+			// A stray Advertise precedes the Reply, which must be skipped.
+			adv := &dhcpv6.Message{
+				MessageType:   dhcpv6.MessageTypeAdvertise,
+				TransactionID: msg.TransactionID,
+			}
+			adv.AddOption(dhcpv6.OptServerID(dhcp6test.DefaultServerID))
+			return append([]*dhcpv6.Message{adv}, srv.Respond(msg)...)
+		})
+		t.Run(tt.typ.String(), func(t *testing.T) {
+
+			msg, err := dhcpv6.NewMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg.MessageType = tt.typ
+			msg.AddOption(dhcpv6.OptClientID(*c.duid))
+			reply, err := c.SendReceive(context.Background(), msg, tt.expectedType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := reply.MessageType, dhcpv6.MessageTypeReply; got != want {
+				t.Errorf("unexpected message type: got %v, want %v", got, want)
+			}
+		})
+	}
+}
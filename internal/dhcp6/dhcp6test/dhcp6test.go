@@ -0,0 +1,426 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dhcp6test provides a fake DHCPv6 server for deterministic tests of
+// code using the dhcp6 package: pass the Server as dhcp6.ClientConfig.Conn.
+package dhcp6test
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// DefaultServerID is the DUID of servers whose ServerConfig.ServerID is nil.
+var DefaultServerID = dhcpv6.Duid{
+	Type:          dhcpv6.DUID_LL,
+	HwType:        iana.HWTypeEthernet,
+	LinkLayerAddr: net.HardwareAddr{0x52, 0x54, 0x00, 0xfa, 0xac, 0x14},
+}
+
+// ServerConfig contains configuration for NewFakeServer.
+type ServerConfig struct {
+	// ServerID defaults to DefaultServerID.
+	ServerID *dhcpv6.Duid
+
+	// Prefixes are delegated in order: the first IA_PD of a client message
+	// is answered with the first prefix, and so on. IA_PDs for which no
+	// prefix remains are answered with NoPrefixAvail.
+	Prefixes []net.IPNet
+
+	// Addresses are assigned to IA_NAs like Prefixes to IA_PDs. IA_NAs for
+	// which no address remains are answered with NoAddrsAvail.
+	Addresses []net.IP
+
+	// T1 and T2 default to 20 and 30 minutes.
+	T1, T2 time.Duration
+
+	// PreferredLifetime and ValidLifetime default to 1 and 24 hours.
+	PreferredLifetime, ValidLifetime time.Duration
+
+	// RapidCommit makes the server answer a Solicit containing the Rapid
+	// Commit option with a Reply (RFC 8415, section 18.3.1).
+	RapidCommit bool
+
+	// Clock, if non-nil, is used to evaluate read deadlines. Pass the same
+	// Clock as dhcp6.ClientConfig.Clock to control the retransmission
+	// timers of the client.
+	Clock *Clock
+}
+
+// Clock is a fake clock which only advances when Advance is called. It
+// implements dhcp6.Clock.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	notify []func()
+}
+
+// NewClock returns a Clock starting at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, expiring the read deadlines of all
+// Servers using the clock which lie before the new time.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	notify := c.notify
+	c.mu.Unlock()
+	for _, fn := range notify {
+		fn()
+	}
+}
+
+func (c *Clock) onAdvance(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = append(c.notify, fn)
+}
+
+// HandlerFunc returns the messages to send in response to msg.
+type HandlerFunc func(msg *dhcpv6.Message) []*dhcpv6.Message
+
+// Server is a net.PacketConn which passes each message written by a client to
+// a fake DHCPv6 server and returns the responses from ReadFrom, honoring read
+// deadlines.
+//
+// By default, the server answers Solicit with an Advertise and Request,
+// Renew, Rebind, Confirm, Release, Decline and Information-Request with a
+// Reply. Use Handle to script other responses, and Drop, Reorder and
+// RespondWithStatus to simulate failures.
+type Server struct {
+	cfg      ServerConfig
+	serverID dhcpv6.Duid
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	closed   bool
+	deadline time.Time
+	queue    [][]byte
+	withheld [][]byte // responses held back by Reorder
+	reorder  int      // number of messages whose responses to withhold
+	drop     int      // number of messages whose responses to drop
+	status   map[dhcpv6.MessageType][]iana.StatusCode
+	handlers map[dhcpv6.MessageType]HandlerFunc
+	received []*dhcpv6.Message
+}
+
+// NewFakeServer returns a fake server configured by cfg.
+func NewFakeServer(cfg ServerConfig) *Server {
+	if cfg.T1 == 0 {
+		cfg.T1 = 20 * time.Minute
+	}
+	if cfg.T2 == 0 {
+		cfg.T2 = 30 * time.Minute
+	}
+	if cfg.PreferredLifetime == 0 {
+		cfg.PreferredLifetime = 1 * time.Hour
+	}
+	if cfg.ValidLifetime == 0 {
+		cfg.ValidLifetime = 24 * time.Hour
+	}
+	s := &Server{
+		cfg:      cfg,
+		serverID: DefaultServerID,
+		status:   make(map[dhcpv6.MessageType][]iana.StatusCode),
+		handlers: make(map[dhcpv6.MessageType]HandlerFunc),
+	}
+	if cfg.ServerID != nil {
+		s.serverID = *cfg.ServerID
+	}
+	s.cond = sync.NewCond(&s.mu)
+	if cfg.Clock != nil {
+		cfg.Clock.onAdvance(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.cond.Broadcast()
+		})
+	}
+	return s
+}
+
+func (s *Server) now() time.Time {
+	if s.cfg.Clock != nil {
+		return s.cfg.Clock.Now()
+	}
+	return time.Now()
+}
+
+// ServerID returns the DUID the server identifies itself with.
+func (s *Server) ServerID() dhcpv6.Duid { return s.serverID }
+
+// Handle replaces the default response to messages of type mt with the
+// result of h. h is called with the server locked and must not call methods
+// of s other than Respond.
+func (s *Server) Handle(mt dhcpv6.MessageType, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[mt] = h
+}
+
+// Drop discards the responses to the next n messages, as if they were lost.
+func (s *Server) Drop(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop += n
+}
+
+// Reorder withholds the responses to the next n messages, and delivers them
+// after the responses to the message following them (which might be a
+// retransmission).
+func (s *Server) Reorder(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reorder += n
+}
+
+// RespondWithStatus answers the next message of type mt with code instead of
+// a lease. NoAddrsAvail, NoPrefixAvail, NoBinding and NotOnLink are sent
+// within each IA_NA and IA_PD (RFC 8415, section 18.3), all other codes (e.g.
+// UseMulticast) at the top level. Multiple calls queue up.
+func (s *Server) RespondWithStatus(mt dhcpv6.MessageType, code iana.StatusCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status[mt] = append(s.status[mt], code)
+}
+
+// Send queues msg for reading by the client, e.g. to send a Reconfigure.
+func (s *Server) Send(msg *dhcpv6.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, msg.ToBytes())
+	s.cond.Broadcast()
+}
+
+// Received returns all messages written by the client so far.
+func (s *Server) Received() []*dhcpv6.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*dhcpv6.Message(nil), s.received...)
+}
+
+// ReceivedTypes returns the types of all messages written by the client so
+// far.
+func (s *Server) ReceivedTypes() []dhcpv6.MessageType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := make([]dhcpv6.MessageType, len(s.received))
+	for idx, msg := range s.received {
+		types[idx] = msg.MessageType
+	}
+	return types
+}
+
+// Respond returns the default response to msg. Use it in a HandlerFunc to
+// modify the default response.
+func (s *Server) Respond(msg *dhcpv6.Message) []*dhcpv6.Message {
+	return s.respond(msg, iana.StatusSuccess)
+}
+
+func (s *Server) respond(msg *dhcpv6.Message, code iana.StatusCode) []*dhcpv6.Message {
+	cid := msg.GetOneOption(dhcpv6.OptionClientID)
+	if cid == nil {
+		return nil // servers discard messages without a Client ID
+	}
+	resp := &dhcpv6.Message{
+		MessageType:   dhcpv6.MessageTypeReply,
+		TransactionID: msg.TransactionID,
+	}
+	resp.AddOption(cid)
+	resp.AddOption(dhcpv6.OptServerID(s.serverID))
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeSolicit:
+		if s.cfg.RapidCommit && msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil {
+			resp.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionRapidCommit})
+			break
+		}
+		resp.MessageType = dhcpv6.MessageTypeAdvertise
+	case dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind,
+		dhcpv6.MessageTypeConfirm,
+		dhcpv6.MessageTypeRelease,
+		dhcpv6.MessageTypeDecline,
+		dhcpv6.MessageTypeInformationRequest:
+	default:
+		return nil
+	}
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeConfirm,
+		dhcpv6.MessageTypeRelease,
+		dhcpv6.MessageTypeDecline:
+		resp.AddOption(&dhcpv6.OptStatusCode{StatusCode: code})
+		return []*dhcpv6.Message{resp}
+	case dhcpv6.MessageTypeInformationRequest:
+		if code != iana.StatusSuccess {
+			resp.AddOption(&dhcpv6.OptStatusCode{StatusCode: code})
+		}
+		return []*dhcpv6.Message{resp}
+	}
+	perIA := code == iana.StatusNoAddrsAvail ||
+		code == iana.StatusNoPrefixAvail ||
+		code == iana.StatusNoBinding ||
+		code == iana.StatusNotOnLink
+	if code != iana.StatusSuccess && !perIA {
+		resp.AddOption(&dhcpv6.OptStatusCode{StatusCode: code})
+		return []*dhcpv6.Message{resp}
+	}
+	for idx, ia := range msg.Options.IANA() {
+		opt := &dhcpv6.OptIANA{IaId: ia.IaId, T1: s.cfg.T1, T2: s.cfg.T2}
+		switch {
+		case perIA:
+			opt.Options.Add(&dhcpv6.OptStatusCode{StatusCode: code})
+		case idx < len(s.cfg.Addresses):
+			opt.Options.Add(&dhcpv6.OptIAAddress{
+				IPv6Addr:          s.cfg.Addresses[idx],
+				PreferredLifetime: s.cfg.PreferredLifetime,
+				ValidLifetime:     s.cfg.ValidLifetime,
+			})
+		default:
+			opt.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoAddrsAvail})
+		}
+		resp.AddOption(opt)
+	}
+	for idx, ia := range msg.Options.IAPD() {
+		opt := &dhcpv6.OptIAPD{IaId: ia.IaId, T1: s.cfg.T1, T2: s.cfg.T2}
+		switch {
+		case perIA:
+			opt.Options.Add(&dhcpv6.OptStatusCode{StatusCode: code})
+		case idx < len(s.cfg.Prefixes):
+			prefix := s.cfg.Prefixes[idx]
+			opt.Options.Add(&dhcpv6.OptIAPrefix{
+				PreferredLifetime: s.cfg.PreferredLifetime,
+				ValidLifetime:     s.cfg.ValidLifetime,
+				Prefix:            &prefix,
+			})
+		default:
+			opt.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoPrefixAvail})
+		}
+		resp.AddOption(opt)
+	}
+	return []*dhcpv6.Message{resp}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errClosed = errors.New("dhcp6test: use of closed connection")
+
+// serverAddr is the address from which all responses appear to be sent.
+var serverAddr = &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultServerPort}
+
+func (s *Server) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv6unspecified, Port: dhcpv6.DefaultClientPort}
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *Server) SetDeadline(t time.Time) error      { return s.SetReadDeadline(t) }
+func (s *Server) SetWriteDeadline(t time.Time) error { return nil }
+
+func (s *Server) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadline = t
+	s.cond.Broadcast()
+	if !t.IsZero() && s.cfg.Clock == nil {
+		time.AfterFunc(time.Until(t), func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.cond.Broadcast()
+		})
+	}
+	return nil
+}
+
+// WriteTo passes the message in b to the fake server. Messages which are not
+// valid DHCPv6 messages are rejected with an error.
+func (s *Server) WriteTo(b []byte, addr net.Addr) (int, error) {
+	msg, err := dhcpv6.MessageFromBytes(b)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errClosed
+	}
+	s.received = append(s.received, msg)
+	var resp []*dhcpv6.Message
+	if codes := s.status[msg.MessageType]; len(codes) > 0 {
+		s.status[msg.MessageType] = codes[1:]
+		resp = s.respond(msg, codes[0])
+	} else if h, ok := s.handlers[msg.MessageType]; ok {
+		resp = h(msg)
+	} else {
+		resp = s.Respond(msg)
+	}
+	var raw [][]byte
+	for _, r := range resp {
+		raw = append(raw, r.ToBytes())
+	}
+	switch {
+	case s.drop > 0:
+		s.drop--
+		return len(b), nil
+	case s.reorder > 0:
+		s.reorder--
+		s.withheld = append(s.withheld, raw...)
+		return len(b), nil
+	}
+	s.queue = append(s.queue, raw...)
+	s.queue = append(s.queue, s.withheld...)
+	s.withheld = nil
+	s.cond.Broadcast()
+	return len(b), nil
+}
+
+func (s *Server) ReadFrom(buf []byte) (int, net.Addr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 {
+		if s.closed {
+			return 0, nil, errClosed
+		}
+		if !s.deadline.IsZero() && !s.now().Before(s.deadline) {
+			return 0, nil, timeoutError{}
+		}
+		s.cond.Wait()
+	}
+	n := copy(buf, s.queue[0])
+	s.queue = s.queue[1:]
+	return n, serverAddr, nil
+}
@@ -0,0 +1,284 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6test_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func mustParseCIDR(s string) net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *n
+}
+
+var clientDUID = dhcpv6.Duid{
+	Type:          dhcpv6.DUID_LL,
+	HwType:        iana.HWTypeEthernet,
+	LinkLayerAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+}
+
+func newClient(t *testing.T, srv *dhcp6test.Server) *dhcp6.Client {
+	t.Helper()
+	return newClientConfig(t, dhcp6.ClientConfig{Conn: srv})
+}
+
+func newClientConfig(t *testing.T, cfg dhcp6.ClientConfig) *dhcp6.Client {
+	t.Helper()
+	cfg.InterfaceName = "lo"
+	cfg.LocalAddr = &net.UDPAddr{IP: net.ParseIP("fe80::42:aff:fea5:966e"), Port: dhcpv6.DefaultClientPort}
+	cfg.HardwareAddr = clientDUID.LinkLayerAddr
+	cfg.DUID = clientDUID.ToBytes()
+	cfg.RapidCommit = true
+	c, err := dhcp6.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// exchange writes msg to srv and returns the responses which are readable
+// right away.
+func exchange(t *testing.T, srv *dhcp6test.Server, msg *dhcpv6.Message) []*dhcpv6.Message {
+	t.Helper()
+	if _, err := srv.WriteTo(msg.ToBytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	var resp []*dhcpv6.Message
+	buf := make([]byte, 1500)
+	for {
+		srv.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		n, _, err := srv.ReadFrom(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return resp
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := dhcpv6.MessageFromBytes(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp = append(resp, m)
+	}
+}
+
+func newSolicit(t *testing.T) *dhcpv6.Message {
+	t.Helper()
+	solicit, err := dhcpv6.NewSolicit(clientDUID.LinkLayerAddr, dhcpv6.WithClientID(clientDUID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	solicit.Options.Del(dhcpv6.OptionIANA)
+	solicit.AddOption(&dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 1}})
+	return solicit
+}
+
+func TestClient(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:    []net.IPNet{prefix},
+		RapidCommit: true,
+	})
+	c := newClient(t, srv)
+	// The first Solicit is lost, so the client retransmits it.
+	srv.Drop(1)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRenew,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestAddressOnly(t *testing.T) {
+	// The server answers the IA_PD with NoPrefixAvail, but assigns an address.
+	addr := net.ParseIP("2001:db8::5")
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Addresses:   []net.IP{addr},
+		RapidCommit: true,
+	})
+	c := newClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []net.IPNet{{IP: addr, Mask: net.CIDRMask(128, 128)}}
+	if diff := cmp.Diff(want, cfg.Addresses); diff != "" {
+		t.Fatalf("unexpected addresses: diff (-want +got):\n%s", diff)
+	}
+	if len(cfg.Prefixes) > 0 {
+		t.Fatalf("unexpected prefixes: %v", cfg.Prefixes)
+	}
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if _, err := c.Rebind(context.Background()); err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2020, time.April, 1, 12, 0, 0, 0, time.UTC)
+	clock := dhcp6test.NewClock(start)
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:    []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+		RapidCommit: true,
+		Clock:       clock,
+	})
+	c := newClientConfig(t, dhcp6.ClientConfig{
+		Conn:  srv,
+		Clock: clock,
+	})
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := cfg.RenewAfter, start.Add(20*time.Minute); !got.Equal(want) {
+		t.Fatalf("RenewAfter: got %v, want %v", got, want)
+	}
+
+	// Renew retransmits after 10s (RFC 8415, section 7.6), which elapse
+	// without sleeping.
+	srv.Drop(1)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Renew(context.Background())
+		errc <- err
+	}()
+	for len(srv.ReceivedTypes()) < 3 {
+		clock.Advance(1 * time.Second)
+		time.Sleep(1 * time.Millisecond)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed < 9*time.Second {
+		t.Fatalf("Renew retransmitted after %v, want at least 9s", elapsed)
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:    []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+		RapidCommit: true,
+	})
+	srv.RespondWithStatus(dhcpv6.MessageTypeSolicit, iana.StatusNoPrefixAvail)
+	c := newClient(t, srv)
+	_, err := c.ObtainOrRenewErr(context.Background())
+	var se *dhcp6.StatusError
+	if !errors.As(err, &se) || se.Code != iana.StatusNoPrefixAvail {
+		t.Fatalf("ObtainOrRenewErr: got %v, want NoPrefixAvail", err)
+	}
+}
+
+func TestRespondWithStatus(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes: []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+	})
+	srv.RespondWithStatus(dhcpv6.MessageTypeSolicit, iana.StatusNoPrefixAvail)
+	srv.RespondWithStatus(dhcpv6.MessageTypeSolicit, iana.StatusUseMulticast)
+
+	resp := exchange(t, srv, newSolicit(t))
+	if len(resp) != 1 {
+		t.Fatalf("got %d responses, want 1", len(resp))
+	}
+	iapd := resp[0].Options.OneIAPD()
+	if iapd == nil {
+		t.Fatalf("IA_PD missing")
+	}
+	if sc := iapd.Options.Status(); sc == nil || sc.StatusCode != iana.StatusNoPrefixAvail {
+		t.Errorf("IA_PD status: got %v, want NoPrefixAvail", sc)
+	}
+	if got := iapd.Options.Prefixes(); len(got) != 0 {
+		t.Errorf("unexpected prefixes: %v", got)
+	}
+
+	resp = exchange(t, srv, newSolicit(t))
+	if len(resp) != 1 {
+		t.Fatalf("got %d responses, want 1", len(resp))
+	}
+	if sc := resp[0].Options.Status(); sc == nil || sc.StatusCode != iana.StatusUseMulticast {
+		t.Errorf("status: got %v, want UseMulticast", sc)
+	}
+
+	// Scripted statuses are used up, the server delegates again.
+	resp = exchange(t, srv, newSolicit(t))
+	if len(resp) != 1 || len(resp[0].Options.OneIAPD().Options.Prefixes()) != 1 {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes: []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+	})
+	srv.Reorder(1)
+	first, second := newSolicit(t), newSolicit(t)
+	if resp := exchange(t, srv, first); len(resp) != 0 {
+		t.Fatalf("response to first Solicit not withheld: %v", resp)
+	}
+	resp := exchange(t, srv, second)
+	var got []dhcpv6.TransactionID
+	for _, r := range resp {
+		got = append(got, r.TransactionID)
+	}
+	want := []dhcpv6.TransactionID{second.TransactionID, first.TransactionID}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected transaction IDs: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes: []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+	})
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		resp := srv.Respond(msg)
+		// A second, identical Advertise.
+		return append(resp, resp[0])
+	})
+	if resp := exchange(t, srv, newSolicit(t)); len(resp) != 2 {
+		t.Fatalf("got %d responses, want 2", len(resp))
+	}
+	srv.Drop(1)
+	if resp := exchange(t, srv, newSolicit(t)); len(resp) != 0 {
+		t.Fatalf("response not dropped: %v", resp)
+	}
+}
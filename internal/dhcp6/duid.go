@@ -0,0 +1,216 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/renameio"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// newDUID returns a DUID of type typ (RFC 8415, section 11). DUID-LL and
+// DUID-LLT are derived from hardwareAddr, DUID-EN and DUID-UUID from
+// enterpriseNumber and identifier.
+func newDUID(typ dhcpv6.DuidType, hardwareAddr net.HardwareAddr, enterpriseNumber uint32, identifier []byte) (*dhcpv6.Duid, error) {
+	switch typ {
+	case dhcpv6.DUID_LL:
+		return &dhcpv6.Duid{
+			Type:          dhcpv6.DUID_LL,
+			HwType:        iana.HWTypeEthernet,
+			LinkLayerAddr: hardwareAddr,
+		}, nil
+
+	case dhcpv6.DUID_LLT:
+		return &dhcpv6.Duid{
+			Type:          dhcpv6.DUID_LLT,
+			HwType:        iana.HWTypeEthernet,
+			Time:          dhcpv6.GetTime(),
+			LinkLayerAddr: hardwareAddr,
+		}, nil
+
+	case dhcpv6.DUID_EN:
+		if enterpriseNumber == 0 {
+			return nil, fmt.Errorf("DUID-EN requires an enterprise number")
+		}
+		if len(identifier) == 0 {
+			return nil, fmt.Errorf("DUID-EN requires an identifier")
+		}
+		return &dhcpv6.Duid{
+			Type:                 dhcpv6.DUID_EN,
+			EnterpriseNumber:     enterpriseNumber,
+			EnterpriseIdentifier: identifier,
+		}, nil
+
+	case dhcpv6.DUID_UUID:
+		if got, want := len(identifier), 16; got != want {
+			return nil, fmt.Errorf("DUID-UUID requires a %d byte UUID, got %d bytes", want, got)
+		}
+		return &dhcpv6.Duid{
+			Type: dhcpv6.DUID_UUID,
+			Uuid: identifier,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported DUID type %d", typ)
+	}
+}
+
+// DUIDFormat is the format of a DUID file written by other DHCPv6 clients
+// (see ClientConfig.DUIDImportPath).
+type DUIDFormat int
+
+const (
+	// DUIDFormatRaw is the DUID in wire format, like ClientConfig.DUIDPath.
+	DUIDFormatRaw DUIDFormat = iota
+
+	// DUIDFormatLengthPrefixed is a 16-bit length followed by the DUID in
+	// wire format, e.g. the dhcp6c_duid file of WIDE-DHCPv6. The length is
+	// accepted in either byte order, as dhcp6c writes it in host byte order.
+	DUIDFormatLengthPrefixed
+
+	// DUIDFormatISC is a dhclient (ISC DHCP) lease file, e.g.
+	// /var/lib/dhcp/dhclient6.leases, containing a default-duid statement.
+	DUIDFormatISC
+
+	// DUIDFormatHex is the DUID as hexadecimal text with optional colon
+	// separators, e.g. /var/lib/dhcpcd/duid.
+	DUIDFormatHex
+)
+
+// iscDefaultDUID matches the default-duid statement of ISC DHCP lease files,
+// e.g. default-duid "\000\001\000\001%8\341\242RT\000\0224V";
+var iscDefaultDUID = regexp.MustCompile(`(?m)^\s*default-duid\s+"((?:[^"\\]|\\.)*)"\s*;`)
+
+// readDUIDFile reads the DUID stored in path in the specified format.
+func readDUIDFile(path string, format DUIDFormat) (*dhcpv6.Duid, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err = parseDUIDFile(b, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	duid, err := dhcpv6.DuidFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return duid, nil
+}
+
+// parseDUIDFile returns the wire format of the DUID contained in b.
+func parseDUIDFile(b []byte, format DUIDFormat) ([]byte, error) {
+	switch format {
+	case DUIDFormatRaw:
+		return b, nil
+
+	case DUIDFormatLengthPrefixed:
+		if len(b) < 2 {
+			return nil, fmt.Errorf("DUID file too short (%d bytes)", len(b))
+		}
+		duid := b[2:]
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			if n := int(order.Uint16(b)); n > 0 && n <= len(duid) {
+				return duid[:n], nil
+			}
+		}
+		return nil, fmt.Errorf("DUID length %x exceeds the %d bytes of the file", b[:2], len(duid))
+
+	case DUIDFormatISC:
+		// dhclient appends to the lease file, so the last statement is the
+		// current one.
+		matches := iscDefaultDUID.FindAllSubmatch(b, -1)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no default-duid statement found")
+		}
+		return unquoteISC(matches[len(matches)-1][1])
+
+	case DUIDFormatHex:
+		s := strings.Replace(strings.TrimSpace(string(b)), ":", "", -1)
+		return hex.DecodeString(s)
+
+	default:
+		return nil, fmt.Errorf("unsupported DUID file format %d", format)
+	}
+}
+
+// unquoteISC decodes the contents of a string as written by ISC DHCP, in
+// which non-printable bytes are escaped as three octal digits.
+func unquoteISC(s []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return nil, fmt.Errorf("truncated escape sequence")
+		}
+		if c := s[i+1]; c < '0' || c > '7' {
+			buf.WriteByte(c) // e.g. \" or \\
+			i++
+			continue
+		}
+		if i+3 >= len(s) {
+			return nil, fmt.Errorf("truncated escape sequence %q", s[i:])
+		}
+		v, err := strconv.ParseUint(string(s[i+1:i+4]), 8, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid escape sequence %q", s[i:i+4])
+		}
+		buf.WriteByte(byte(v))
+		i += 3
+	}
+	return buf.Bytes(), nil
+}
+
+// loadOrCreateDUID reads the DUID stored in path. If path does not exist, the
+// DUID returned by generate is atomically written to path.
+func loadOrCreateDUID(path string, generate func() (*dhcpv6.Duid, error)) (*dhcpv6.Duid, error) {
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		duid, err := dhcpv6.DuidFromBytes(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return duid, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	duid, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := renameio.WriteFile(path, duid.ToBytes(), 0644); err != nil {
+		return nil, err
+	}
+	return duid, nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestDUIDType(t *testing.T) {
+	uuid := []byte{
+		0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1,
+		0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8,
+	}
+	for _, tt := range []struct {
+		name string
+		cfg  ClientConfig
+		want []byte
+	}{
+		{
+			name: "default",
+			want: []byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		},
+
+		{
+			name: "ll",
+			cfg:  ClientConfig{DUIDType: dhcpv6.DUID_LL},
+			want: []byte{0x00, 0x03, 0x00, 0x01, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+		},
+
+		{
+			name: "en",
+			cfg: ClientConfig{
+				DUIDType:             dhcpv6.DUID_EN,
+				DUIDEnterpriseNumber: 872,
+				DUIDIdentifier:       []byte("router7"),
+			},
+			want: append([]byte{0x00, 0x02, 0x00, 0x00, 0x03, 0x68}, "router7"...),
+		},
+
+		{
+			name: "uuid",
+			cfg: ClientConfig{
+				DUIDType:       dhcpv6.DUID_UUID,
+				DUIDIdentifier: uuid,
+			},
+			want: append([]byte{0x00, 0x04}, uuid...),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Conn = dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+			c := newTestClientConfig(t, tt.cfg)
+			if got := c.duid.ToBytes(); !bytes.Equal(got, tt.want) {
+				t.Fatalf("unexpected DUID: got %x, want %x", got, tt.want)
+			}
+		})
+	}
+
+	// DUID-LLT contains the current time, so only verify the prefix.
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:     dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}),
+		DUIDType: dhcpv6.DUID_LLT,
+	})
+	if got, want := c.duid.ToBytes()[:4], []byte{0x00, 0x01, 0x00, 0x01}; !bytes.Equal(got, want) {
+		t.Fatalf("unexpected DUID-LLT prefix: got %x, want %x", got, want)
+	}
+}
+
+func TestDUIDTypeInvalid(t *testing.T) {
+	hwaddr := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	for _, tt := range []struct {
+		typ              dhcpv6.DuidType
+		enterpriseNumber uint32
+		identifier       []byte
+	}{
+		{typ: dhcpv6.DUID_EN, identifier: []byte("router7")},
+		{typ: dhcpv6.DUID_EN, enterpriseNumber: 872},
+		{typ: dhcpv6.DUID_UUID, identifier: []byte{0x01}},
+		{typ: 42},
+	} {
+		if got, err := newDUID(tt.typ, hwaddr, tt.enterpriseNumber, tt.identifier); err == nil {
+			t.Errorf("newDUID(%v, %d, %x) = %v, want error", tt.typ, tt.enterpriseNumber, tt.identifier, got)
+		}
+	}
+}
+
+func TestDUIDPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dhcp6", "duid")
+
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:     dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}),
+		DUIDPath: path,
+	})
+	want := c.duid.ToBytes()
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("unexpected persisted DUID: got %x, want %x", got, want)
+	}
+
+	// A persisted DUID takes precedence over generating one.
+	persisted := []byte{0x00, 0x03, 0x00, 0x01, 0x52, 0x54, 0x00, 0xfa, 0xac, 0x14}
+	if err := ioutil.WriteFile(path, persisted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = newTestClientConfig(t, ClientConfig{
+		Conn:     dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}),
+		DUIDPath: path,
+	})
+	if got := c.duid.ToBytes(); !bytes.Equal(got, persisted) {
+		t.Fatalf("unexpected DUID: got %x, want %x", got, persisted)
+	}
+}
+
+func TestParseDUIDFile(t *testing.T) {
+	// DUID-LLT, hardware type 1, time 0x2538e1a2, 52:54:00:12:34:56
+	want := []byte{0x00, 0x01, 0x00, 0x01, 0x25, 0x38, 0xe1, 0xa2, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	for _, tt := range []struct {
+		name   string
+		format DUIDFormat
+		b      []byte
+	}{
+		{"raw", DUIDFormatRaw, want},
+		{"length-prefixed little-endian", DUIDFormatLengthPrefixed, append([]byte{14, 0}, want...)},
+		{"length-prefixed big-endian", DUIDFormatLengthPrefixed, append([]byte{0, 14}, want...)},
+		{"hex", DUIDFormatHex, []byte("00:01:00:01:25:38:e1:a2:52:54:00:12:34:56\n")},
+		{"isc", DUIDFormatISC, []byte(`default-duid "\000\001\000\001\000\000\000\000RT\000\000\000\000";
+lease6 {
+  interface "eth0";
+}
+default-duid "\000\001\000\001%8\341\242RT\000\0224V";
+`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDUIDFile(tt.b, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("unexpected DUID: got %x, want %x", got, want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		format DUIDFormat
+		b      []byte
+	}{
+		{"length exceeds file", DUIDFormatLengthPrefixed, []byte{0xff, 0xff, 0x00, 0x03}},
+		{"no default-duid", DUIDFormatISC, []byte("lease6 {\n}\n")},
+		{"truncated escape", DUIDFormatISC, []byte(`default-duid "\00";`)},
+		{"invalid hex", DUIDFormatHex, []byte("00:0x")},
+		{"unknown format", DUIDFormat(-1), want},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseDUIDFile(tt.b, tt.format); err == nil {
+				t.Fatalf("parseDUIDFile(%q) = %x, want error", tt.b, got)
+			}
+		})
+	}
+}
+
+func TestDUIDImportPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	importPath := filepath.Join(dir, "dhcp6c_duid")
+	imported := []byte{0x00, 0x03, 0x00, 0x01, 0x52, 0x54, 0x00, 0xfa, 0xac, 0x14}
+	if err := ioutil.WriteFile(importPath, append([]byte{byte(len(imported)), 0}, imported...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "duid")
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:             dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}),
+		DUIDPath:         path,
+		DUIDImportPath:   importPath,
+		DUIDImportFormat: DUIDFormatLengthPrefixed,
+	})
+	if got := c.duid.ToBytes(); !bytes.Equal(got, imported) {
+		t.Fatalf("unexpected DUID: got %x, want %x", got, imported)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, imported) {
+		t.Fatalf("unexpected persisted DUID: got %x, want %x", got, imported)
+	}
+
+	// A missing import file is an error.
+	_, err = NewClient(ClientConfig{
+		InterfaceName:  "lo",
+		LocalAddr:      &net.UDPAddr{IP: net.ParseIP("fe80::42:aff:fea5:966e"), Port: 546},
+		HardwareAddr:   []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		Conn:           dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}),
+		DUIDImportPath: filepath.Join(dir, "nonexistent"),
+	})
+	if !os.IsNotExist(err) {
+		t.Fatalf("NewClient with a missing DUIDImportPath: got %v, want a not-exist error", err)
+	}
+}
+
+func TestEphemeralDUID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tt := range []struct {
+		name   string
+		cfg    ClientConfig
+		warned bool
+	}{
+		{"DUID-LL", ClientConfig{}, false},
+		{"DUID-LLT", ClientConfig{DUIDType: dhcpv6.DUID_LLT}, true},
+		{"persisted DUID-LLT", ClientConfig{
+			DUIDType: dhcpv6.DUID_LLT,
+			DUIDPath: filepath.Join(dir, "duid"),
+		}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			tt.cfg.Conn = dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+			tt.cfg.Logger = logger
+			c := newTestClientConfig(t, tt.cfg)
+			if got, want := len(logger.printf) > 0, tt.warned; got != want {
+				t.Errorf("warning logged: got %v, want %v (messages: %q)", got, want, logger.printf)
+			}
+			if got, want := c.StateSnapshot().EphemeralDUID, tt.warned; got != want {
+				t.Errorf("State.EphemeralDUID: got %v, want %v", got, want)
+			}
+		})
+	}
+}
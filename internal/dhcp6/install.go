@@ -0,0 +1,69 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import "net"
+
+// AddressInstaller assigns the addresses of the lease (IA_NA, see
+// Config.Addresses) to an interface, so that the assigned addresses and the
+// DHCP state stay in sync (see ClientConfig.AddressInstaller).
+type AddressInstaller interface {
+	// AddAddress assigns addr, or updates its lifetimes if it is already
+	// assigned. Deprecated addresses are passed with a preferred lifetime of
+	// 0.
+	AddAddress(addr LeaseStatus) error
+
+	// RemoveAddress removes addr, which AddAddress assigned before.
+	RemoveAddress(addr net.IPNet) error
+}
+
+// NopAddressInstaller is an AddressInstaller which does nothing. It is the
+// default if ClientConfig.AddressInstaller is nil.
+type NopAddressInstaller struct{}
+
+func (NopAddressInstaller) AddAddress(LeaseStatus) error  { return nil }
+func (NopAddressInstaller) RemoveAddress(net.IPNet) error { return nil }
+
+// installAddresses passes the addresses of cfg to c.installer, and removes
+// the previously installed addresses which cfg no longer contains (e.g.
+// after renumbering). Failures are logged, as the lease itself is valid.
+func (c *Client) installAddresses(cfg Config) {
+	current := make(map[string]net.IPNet)
+	for _, st := range cfg.AddressLeases(c.timeNow()) {
+		if st.State == LeaseExpired {
+			continue
+		}
+		if err := c.installer.AddAddress(st); err != nil {
+			c.log.Printf("installing address %v: %v", st.Prefix.String(), err)
+			continue
+		}
+		current[networkKey(st.Prefix)] = st.Prefix
+	}
+	for key, addr := range c.installed {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if err := c.installer.RemoveAddress(addr); err != nil {
+			c.log.Printf("removing address %v: %v", addr.String(), err)
+		}
+	}
+	c.installed = current
+}
+
+// uninstallAddresses removes all installed addresses, e.g. after the lease
+// expired or was released.
+func (c *Client) uninstallAddresses() {
+	c.installAddresses(Config{})
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package dhcp6
+
+import (
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// NetlinkAddressInstaller is an AddressInstaller which assigns addresses to
+// the interface named InterfaceName via netlink. The kernel deprecates and
+// removes the addresses when their lifetimes expire, even if the client
+// stops running.
+type NetlinkAddressInstaller struct {
+	InterfaceName string // e.g. uplink0
+}
+
+func (n *NetlinkAddressInstaller) AddAddress(addr LeaseStatus) error {
+	link, err := netlink.LinkByName(n.InterfaceName)
+	if err != nil {
+		return err
+	}
+	prefix := addr.Prefix
+	return netlink.AddrReplace(link, &netlink.Addr{
+		IPNet:       &prefix,
+		PreferedLft: lifetimeSeconds(addr.Preferred),
+		ValidLft:    lifetimeSeconds(addr.Valid),
+	})
+}
+
+func (n *NetlinkAddressInstaller) RemoveAddress(addr net.IPNet) error {
+	link, err := netlink.LinkByName(n.InterfaceName)
+	if err != nil {
+		return err
+	}
+	return netlink.AddrDel(link, &netlink.Addr{IPNet: &addr})
+}
+
+// lifetimeSeconds converts d to netlink’s representation, in which
+// 0xffffffff (like Infinity) means infinite.
+func lifetimeSeconds(d time.Duration) int {
+	return int(d / time.Second)
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+type recordingInstaller struct {
+	calls []string
+}
+
+func (r *recordingInstaller) AddAddress(addr LeaseStatus) error {
+	r.calls = append(r.calls, fmt.Sprintf("add %v %v %v", addr.Prefix.String(), addr.Preferred, addr.Valid))
+	return nil
+}
+
+func (r *recordingInstaller) RemoveAddress(addr net.IPNet) error {
+	r.calls = append(r.calls, "remove "+addr.String())
+	return nil
+}
+
+func TestAddressInstaller(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:  []net.IPNet{prefix},
+		Addresses: []net.IP{net.ParseIP("2a02:168:4a00::42")},
+	})
+	installer := &recordingInstaller{}
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:             srv,
+		AddressInstaller: installer,
+	})
+	now := time.Now()
+	c.timeNow = func() time.Time { return now }
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The server renumbers the client.
+	renumbered := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:  []net.IPNet{prefix},
+		Addresses: []net.IP{net.ParseIP("2a02:168:4a00::43")},
+	})
+	srv.Handle(dhcpv6.MessageTypeRenew, renumbered.Respond)
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if _, _, err := c.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	want := []string{
+		"add 2a02:168:4a00::42/128 1h0m0s 24h0m0s",
+		"add 2a02:168:4a00::43/128 1h0m0s 24h0m0s",
+		"remove 2a02:168:4a00::42/128",
+		"remove 2a02:168:4a00::43/128",
+	}
+	if diff := cmp.Diff(want, installer.calls); diff != "" {
+		t.Errorf("unexpected installer calls: diff (-want +got):\n%s", diff)
+	}
+}
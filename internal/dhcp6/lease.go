@@ -0,0 +1,163 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/renameio"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// savedLease is the representation of a lease in ClientConfig.LeasePath. The
+// Reply contains the Server ID, the bound IAs and their lifetimes, which are
+// relative to BoundAt.
+type savedLease struct {
+	BoundAt time.Time `json:"bound_at"`
+	Reply   []byte    `json:"reply"` // wire format
+}
+
+// resumeTimeout limits for how long a saved lease is renewed or rebound before
+// falling back to Solicit.
+const resumeTimeout = 30 * time.Second
+
+// SaveLease atomically writes the current lease to ClientConfig.LeasePath.
+func (c *Client) SaveLease() error {
+	if c.leasePath == "" {
+		return fmt.Errorf("ClientConfig.LeasePath not set")
+	}
+	if c.reply == nil {
+		return fmt.Errorf("no lease to save")
+	}
+	b, err := json.Marshal(savedLease{
+		BoundAt: c.boundAt,
+		Reply:   c.reply.ToBytes(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.leasePath), 0755); err != nil {
+		return err
+	}
+	return renameio.WriteFile(c.leasePath, b, 0644)
+}
+
+// LoadLease restores the lease saved in ClientConfig.LeasePath as the current
+// lease. Leases whose valid lifetimes expired are discarded.
+func (c *Client) LoadLease() (Config, error) {
+	if c.leasePath == "" {
+		return Config{}, fmt.Errorf("ClientConfig.LeasePath not set")
+	}
+	reply, saved, err := c.readSavedLease()
+	if err != nil {
+		return Config{}, err
+	}
+	if validUntil := leaseValidUntil(reply, saved.BoundAt); !validUntil.After(c.timeNow()) {
+		return Config{}, fmt.Errorf("saved lease expired at %v", validUntil)
+	}
+	cfg := c.bindAt(reply, saved.BoundAt)
+	cfg.Transition = TransitionLoad
+	cfg.TransitionAt = saved.BoundAt
+	c.setConfig(cfg)
+	return cfg, nil
+}
+
+// readSavedLease reads ClientConfig.LeasePath, regardless of whether the saved
+// lease expired.
+func (c *Client) readSavedLease() (*dhcpv6.Message, savedLease, error) {
+	b, err := ioutil.ReadFile(c.leasePath)
+	if err != nil {
+		return nil, savedLease{}, err
+	}
+	var saved savedLease
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, savedLease{}, fmt.Errorf("%s: %w", c.leasePath, err)
+	}
+	reply, err := dhcpv6.MessageFromBytes(saved.Reply)
+	if err != nil {
+		return nil, savedLease{}, fmt.Errorf("%s: %w", c.leasePath, err)
+	}
+	if reply.GetOneOption(dhcpv6.OptionServerID) == nil {
+		return nil, savedLease{}, fmt.Errorf("%s: Server ID missing", c.leasePath)
+	}
+	return reply, saved, nil
+}
+
+// prefixHintsFrom returns the valid prefixes delegated in reply by IAID, or
+// nil if reply contains none.
+func prefixHintsFrom(reply *dhcpv6.Message) map[[4]byte][]net.IPNet {
+	var hints map[[4]byte][]net.IPNet
+	for _, iapd := range reply.Options.IAPD() {
+		for _, prefix := range iapd.Options.Prefixes() {
+			if prefix.Prefix == nil || validatePrefix(*prefix.Prefix) != nil {
+				continue
+			}
+			if hints == nil {
+				hints = make(map[[4]byte][]net.IPNet)
+			}
+			hints[iapd.IaId] = append(hints[iapd.IaId], *prefix.Prefix)
+		}
+	}
+	return hints
+}
+
+// solicitPrefixHints returns the prefixes to include in the IA_PDs of a
+// Solicit (see ClientConfig.HintPrefixes). Until the client bound a lease, they
+// are read from the saved lease.
+func (c *Client) solicitPrefixHints() map[[4]byte][]net.IPNet {
+	if !c.hintPrefixes {
+		return nil
+	}
+	if c.prefixHints == nil && c.leasePath != "" {
+		reply, _, err := c.readSavedLease()
+		if err != nil {
+			if !os.IsNotExist(err) {
+				c.log.Printf("not hinting saved prefixes: %v", err)
+			}
+			return nil
+		}
+		c.prefixHints = prefixHintsFrom(reply)
+	}
+	return c.prefixHints
+}
+
+// resume loads the saved lease and extends it: via Renew with the granting
+// server before T2, via Rebind with any server afterwards. A lease which the
+// server no longer has a binding for is not reinstated, leaving it to
+// ObtainOrRenewErr to solicit a new lease.
+func (c *Client) resume(ctx context.Context) (Config, error) {
+	cfg, err := c.LoadLease()
+	if err != nil {
+		return Config{}, err
+	}
+	if c.timeNow().Before(cfg.RebindAfter) {
+		cfg, err = c.renew(ctx, resumeTimeout, false)
+	} else {
+		cfg, err = c.rebind(ctx, resumeTimeout)
+	}
+	if err != nil {
+		// Do not retain the unconfirmed lease.
+		c.unbind()
+		return Config{}, err
+	}
+	return cfg, nil
+}
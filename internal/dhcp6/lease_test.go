@@ -0,0 +1,463 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestLeaseStatus(t *testing.T) {
+	now := time.Date(2020, 4, 20, 10, 0, 0, 0, time.UTC)
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, tt := range []struct {
+		name             string
+		preferred, valid time.Duration
+		at               time.Duration // after now
+		want             LeaseStatus
+	}{
+		{"preferred", 1 * time.Hour, 24 * time.Hour, 15 * time.Minute,
+			LeaseStatus{prefix, 45 * time.Minute, 23*time.Hour + 45*time.Minute, LeasePreferred}},
+		{"deprecated", 1 * time.Hour, 24 * time.Hour, 2 * time.Hour,
+			LeaseStatus{prefix, 0, 22 * time.Hour, LeaseDeprecated}},
+		{"expired", 1 * time.Hour, 24 * time.Hour, 25 * time.Hour,
+			LeaseStatus{prefix, 0, 0, LeaseExpired}},
+		{"withdrawn", 0, 0, 0,
+			LeaseStatus{prefix, 0, 0, LeaseExpired}},
+		{"infinite", Infinity, Infinity, 48 * time.Hour,
+			LeaseStatus{prefix, Infinity, Infinity, LeasePreferred}},
+		{"infinite valid lifetime", 1 * time.Hour, Infinity, 2 * time.Hour,
+			LeaseStatus{prefix, 0, Infinity, LeaseDeprecated}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLease(prefix, tt.preferred, tt.valid, now)
+			if diff := cmp.Diff(tt.want, l.Status(now.Add(tt.at))); diff != "" {
+				t.Errorf("unexpected status: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	addr := mustParseCIDR("2a02:168:2000:5::1f/128")
+	cfg := Config{
+		Prefixes:  []net.IPNet{prefix},
+		Addresses: []net.IPNet{addr},
+		Leases: []Lease{
+			newLease(addr, 1*time.Hour, 2*time.Hour, now),
+			newLease(prefix, 1*time.Hour, 24*time.Hour, now),
+		},
+	}
+	at := now.Add(90 * time.Minute)
+	if diff := cmp.Diff([]LeaseStatus{{prefix, 0, 22*time.Hour + 30*time.Minute, LeaseDeprecated}}, cfg.PrefixLeases(at)); diff != "" {
+		t.Errorf("unexpected PrefixLeases: diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]LeaseStatus{{addr, 0, 30 * time.Minute, LeaseDeprecated}}, cfg.AddressLeases(at)); diff != "" {
+		t.Errorf("unexpected AddressLeases: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestIATimers(t *testing.T) {
+	lease := func(preferred, valid time.Duration) Lease {
+		return Lease{PreferredLifetime: preferred, ValidLifetime: valid}
+	}
+	for _, tt := range []struct {
+		name           string
+		t1, t2         time.Duration
+		leases         []Lease
+		wantT1, wantT2 time.Duration
+	}{
+		{
+			name:   "consistent",
+			t1:     20 * time.Minute,
+			t2:     30 * time.Minute,
+			leases: []Lease{lease(1*time.Hour, 24*time.Hour)},
+			wantT1: 20 * time.Minute,
+			wantT2: 30 * time.Minute,
+		},
+		{
+			name:   "no leases",
+			wantT1: 0,
+			wantT2: 0,
+		},
+		{
+			name: "left to client",
+			leases: []Lease{
+				lease(2*time.Hour, 24*time.Hour),
+				lease(1*time.Hour, 24*time.Hour),
+			},
+			wantT1: 30 * time.Minute,
+			wantT2: 48 * time.Minute,
+		},
+		{
+			name:   "deprecated",
+			leases: []Lease{lease(0, 2*time.Hour)},
+			wantT1: 1 * time.Hour,
+			wantT2: 96 * time.Minute,
+		},
+		{
+			name: "withdrawn",
+			t1:   20 * time.Minute,
+			t2:   30 * time.Minute,
+			leases: []Lease{
+				lease(1*time.Hour, 24*time.Hour),
+				lease(0, 0),
+			},
+			wantT1: 20 * time.Minute,
+			wantT2: 30 * time.Minute,
+		},
+		{
+			name:   "T1 after T2",
+			t1:     40 * time.Minute,
+			t2:     30 * time.Minute,
+			leases: []Lease{lease(1*time.Hour, 24*time.Hour)},
+			wantT1: 30 * time.Minute,
+			wantT2: 30 * time.Minute,
+		},
+		{
+			name:   "after valid lifetime",
+			t1:     48 * time.Hour,
+			t2:     72 * time.Hour,
+			leases: []Lease{lease(1*time.Hour, 10*time.Hour)},
+			wantT1: 5 * time.Hour,
+			wantT2: 8 * time.Hour,
+		},
+		{
+			name:   "infinite T1 and T2",
+			t1:     Infinity,
+			t2:     Infinity,
+			leases: []Lease{lease(1*time.Hour, 10*time.Hour)},
+			wantT1: 5 * time.Hour,
+			wantT2: 8 * time.Hour,
+		},
+		{
+			name:   "infinite lifetimes",
+			t1:     Infinity,
+			t2:     Infinity,
+			leases: []Lease{lease(Infinity, Infinity)},
+			wantT1: Infinity,
+			wantT2: Infinity,
+		},
+		{
+			name:   "infinite lifetimes left to client",
+			leases: []Lease{lease(Infinity, Infinity)},
+			wantT1: Infinity,
+			wantT2: Infinity,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t1, t2 := iaTimers(tt.t1, tt.t2, tt.leases)
+			if t1 != tt.wantT1 || t2 != tt.wantT2 {
+				t.Fatalf("iaTimers(%v, %v) = %v, %v, want %v, %v", tt.t1, tt.t2, t1, t2, tt.wantT1, tt.wantT2)
+			}
+		})
+	}
+}
+
+func TestInfiniteLifetime(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:          []net.IPNet{prefix},
+		T1:                Infinity,
+		T2:                Infinity,
+		PreferredLifetime: Infinity,
+		ValidLifetime:     Infinity,
+	})
+	c := newTestClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Infinite {
+		t.Errorf("Config.Infinite = false, want true")
+	}
+	if got, want := cfg.T1, Infinity; got != want {
+		t.Errorf("unexpected T1: got %v, want %v", got, want)
+	}
+	want := []Lease{{
+		Prefix:            prefix,
+		PreferredLifetime: Infinity,
+		ValidLifetime:     Infinity,
+	}}
+	if diff := cmp.Diff(want, cfg.Leases); diff != "" {
+		t.Errorf("unexpected leases: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithdrawnLeases(t *testing.T) {
+	current := mustParseCIDR("2a02:168:4b00::/48")
+	withdrawn := mustParseCIDR("2a02:168:4a00::/48")
+	addr := net.ParseIP("2a02:168:2000:5::1f")
+	oldAddr := net.ParseIP("2a02:168:2000:5::2f")
+	iaPrefix := func(prefix net.IPNet, valid time.Duration) dhcpv6.Option {
+		return &dhcpv6.OptIAPrefix{PreferredLifetime: valid / 2, ValidLifetime: valid, Prefix: &prefix}
+	}
+	iaAddress := func(ip net.IP, valid time.Duration) dhcpv6.Option {
+		return &dhcpv6.OptIAAddress{IPv6Addr: ip, PreferredLifetime: valid / 2, ValidLifetime: valid}
+	}
+	for _, tt := range []struct {
+		desc            string
+		options         []dhcpv6.Option
+		wantPrefixes    []net.IPNet
+		wantAddresses   []net.IPNet
+		wantDelegations []Delegation
+	}{
+		{
+			desc: "renumbered prefix",
+			options: []dhcpv6.Option{
+				&dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 1}, Options: dhcpv6.PDOptions{Options: dhcpv6.Options{
+					iaPrefix(withdrawn, 0),
+					iaPrefix(current, 24*time.Hour),
+				}}},
+			},
+			wantPrefixes:    []net.IPNet{current},
+			wantDelegations: []Delegation{{IAID: [4]byte{0, 0, 0, 1}, Prefixes: []net.IPNet{current}}},
+		},
+		{
+			desc: "all prefixes withdrawn",
+			options: []dhcpv6.Option{
+				&dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 1}, Options: dhcpv6.PDOptions{Options: dhcpv6.Options{
+					iaPrefix(withdrawn, 0),
+				}}},
+			},
+			wantDelegations: []Delegation{{IAID: [4]byte{0, 0, 0, 1}}},
+		},
+		{
+			desc: "renumbered address",
+			options: []dhcpv6.Option{
+				&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 1}, Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{
+					iaAddress(oldAddr, 0),
+					iaAddress(addr, 24*time.Hour),
+				}}},
+			},
+			wantAddresses: []net.IPNet{{IP: addr, Mask: net.CIDRMask(128, 128)}},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := newTestClient(t, dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}))
+			reply := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply}
+			for _, opt := range tt.options {
+				reply.AddOption(opt)
+			}
+			cfg := c.configFromReply(reply, time.Now())
+			if diff := cmp.Diff(tt.wantPrefixes, cfg.Prefixes); diff != "" {
+				t.Errorf("unexpected prefixes: diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantAddresses, cfg.Addresses); diff != "" {
+				t.Errorf("unexpected addresses: diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantDelegations, cfg.Delegations); diff != "" {
+				t.Errorf("unexpected delegations: diff (-want +got):\n%s", diff)
+			}
+			for _, l := range cfg.Leases {
+				if l.ValidLifetime == 0 {
+					t.Errorf("withdrawn lease %v retained", l.Prefix.String())
+				}
+			}
+		})
+	}
+}
+
+func TestLeasePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	leasePath := filepath.Join(dir, "wire", "reply.json")
+
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	now := time.Now()
+	newClient := func(srv *dhcp6test.Server, at time.Time) *Client {
+		c := newTestClientConfig(t, ClientConfig{
+			Conn:      srv,
+			LeasePath: leasePath,
+		})
+		c.timeNow = func() time.Time { return at }
+		return c
+	}
+
+	c := newClient(newTestServer(prefix), now)
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(leasePath); err != nil {
+		t.Fatalf("lease not saved: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		after time.Duration
+		want  []dhcpv6.MessageType
+	}{
+		{
+			name:  "renew",
+			after: 5 * time.Minute,
+			want:  []dhcpv6.MessageType{dhcpv6.MessageTypeRenew},
+		},
+
+		{
+			name:  "rebind",
+			after: 1 * time.Hour, // after T2
+			want:  []dhcpv6.MessageType{dhcpv6.MessageTypeRebind},
+		},
+
+		{
+			name:  "expired",
+			after: 25 * time.Hour, // after the valid lifetime
+			want: []dhcpv6.MessageType{
+				dhcpv6.MessageTypeSolicit,
+				dhcpv6.MessageTypeRequest,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Restore the original lease, which is overwritten in each test.
+			c.SaveLease()
+			srv := newTestServer(prefix)
+			c := newClient(srv, now.Add(tt.after))
+			cfg, err := c.ObtainOrRenewErr(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, srv.ReceivedTypes()); diff != "" {
+				t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+				t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLeasePathNoBinding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	leasePath := filepath.Join(dir, "reply.json")
+
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	now := time.Now()
+	newClient := func(srv *dhcp6test.Server) *Client {
+		c := newTestClientConfig(t, ClientConfig{
+			Conn:      srv,
+			LeasePath: leasePath,
+			Retransmission: map[dhcpv6.MessageType]Retransmission{
+				dhcpv6.MessageTypeRequest: {IRT: 10 * time.Millisecond, MRC: 2},
+			},
+		})
+		c.timeNow = func() time.Time { return now }
+		return c
+	}
+	if _, err := newClient(newTestServer(prefix)).ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server lost its bindings and does not answer Requests.
+	srv := newTestServer(prefix)
+	srv.RespondWithStatus(dhcpv6.MessageTypeRenew, iana.StatusNoBinding)
+	dropAll(srv, dhcpv6.MessageTypeRequest)
+	now = now.Add(5 * time.Minute)
+	if _, err := newClient(srv).ObtainOrRenewErr(context.Background()); err == nil {
+		t.Fatalf("ObtainOrRenewErr unexpectedly succeeded")
+	}
+	// Resuming the saved lease must not reinstate it or solicit a new lease:
+	// only ObtainOrRenewErr solicits, once.
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRequest,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Errorf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestHintPrefixes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dhcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	leasePath := filepath.Join(dir, "lease.json")
+
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	newClient := func(at time.Time) *Client {
+		c := newTestClientConfig(t, ClientConfig{
+			Conn:         srv,
+			LeasePath:    leasePath,
+			HintPrefixes: true,
+			PrefixLength: 56,
+		})
+		c.timeNow = func() time.Time { return at }
+		return c
+	}
+	// hints returns the prefixes hinted in the last Solicit.
+	hints := func() []net.IPNet {
+		var hints []net.IPNet
+		for _, msg := range srv.Received() {
+			if msg.MessageType != dhcpv6.MessageTypeSolicit {
+				continue
+			}
+			hints = nil
+			for _, p := range msg.Options.OneIAPD().Options.Prefixes() {
+				hints = append(hints, *p.Prefix)
+			}
+		}
+		return hints
+	}
+
+	now := time.Now()
+	if _, err := newClient(now).ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Without a previous lease, the prefix length is hinted.
+	want := []net.IPNet{{IP: net.IPv6zero, Mask: net.CIDRMask(56, 128)}}
+	if diff := cmp.Diff(want, hints()); diff != "" {
+		t.Fatalf("unexpected hints: diff (-want +got):\n%s", diff)
+	}
+
+	// After a restart, the prefix of the expired saved lease is hinted.
+	c := newClient(now.Add(25 * time.Hour))
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, hints()); diff != "" {
+		t.Fatalf("unexpected hints: diff (-want +got):\n%s", diff)
+	}
+
+	// Released prefixes are not hinted.
+	if _, _, err := c.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, hints()); diff != "" {
+		t.Fatalf("unexpected hints: diff (-want +got):\n%s", diff)
+	}
+}
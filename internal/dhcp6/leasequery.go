@@ -0,0 +1,281 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// This file implements the requestor side of DHCPv6 Leasequery (RFC 5007).
+
+// LeasequeryType is the query-type of an OPTION_LQ_QUERY (RFC 5007, section
+// 4.1.2.1).
+type LeasequeryType uint8
+
+const (
+	// QueryByAddress asks for the client to which LeasequeryQuery.Addr is
+	// leased (or delegated, for an address within a delegated prefix).
+	QueryByAddress LeasequeryType = 1
+	// QueryByClientID asks for the bindings of LeasequeryQuery.ClientID.
+	QueryByClientID LeasequeryType = 2
+
+	// The following query types are only supported by Bulk Leasequery (RFC
+	// 5460, section 5.3).
+
+	// QueryByRelayID asks for the bindings of clients whose messages were
+	// relayed by the relay agent with DUID LeasequeryQuery.RelayID.
+	QueryByRelayID LeasequeryType = 3
+	// QueryByLinkAddress asks for the bindings on the link with
+	// LeasequeryQuery.LinkAddr. Servers which support it return the bindings
+	// on all links for an unspecified LinkAddr.
+	QueryByLinkAddress LeasequeryType = 4
+	// QueryByRemoteID asks for the bindings of clients whose messages were
+	// relayed with the Remote-ID option LeasequeryQuery.RemoteID.
+	QueryByRemoteID LeasequeryType = 5
+)
+
+func (t LeasequeryType) String() string {
+	switch t {
+	case QueryByAddress:
+		return "QUERY_BY_ADDRESS"
+	case QueryByClientID:
+		return "QUERY_BY_CLIENTID"
+	case QueryByRelayID:
+		return "QUERY_BY_RELAY_ID"
+	case QueryByLinkAddress:
+		return "QUERY_BY_LINK_ADDRESS"
+	case QueryByRemoteID:
+		return "QUERY_BY_REMOTE_ID"
+	default:
+		return fmt.Sprintf("unknown query type %d", uint8(t))
+	}
+}
+
+// LeasequeryQuery describes the bindings a Leasequery asks for.
+type LeasequeryQuery struct {
+	Type LeasequeryType
+
+	// LinkAddr restricts the query to the link with this address. If nil,
+	// the server searches all links.
+	LinkAddr net.IP
+
+	// Addr is the address to query for QueryByAddress.
+	Addr net.IP
+
+	// ClientID is the DUID to query for QueryByClientID.
+	ClientID *dhcpv6.Duid
+
+	// RelayID is the DUID of the relay agent to query for QueryByRelayID.
+	RelayID *dhcpv6.Duid
+
+	// RemoteID is the Remote-ID option to query for QueryByRemoteID.
+	RemoteID *dhcpv6.OptRemoteID
+}
+
+// ClientData is a decoded OPTION_CLIENT_DATA (RFC 5007, section 4.1.2.2): the
+// bindings a server holds for one client.
+type ClientData struct {
+	ClientID dhcpv6.Duid
+	// Leases contains the addresses (with a /128 mask) and delegated
+	// prefixes of the client. Lifetimes are those remaining at the time the
+	// server answered.
+	Leases []Lease
+	// LastTransaction is the time elapsed since the server last communicated
+	// with the client (OPTION_CLT_TIME), at the time the server answered.
+	LastTransaction time.Duration
+	// Relay is the last message the server received from a relay agent on
+	// behalf of the client (OPTION_LQ_RELAY_DATA), if any. Bulk Leasequery
+	// servers include it for clients behind relay agents.
+	Relay *RelayData
+}
+
+// RelayData is a decoded OPTION_LQ_RELAY_DATA (RFC 5460, section 5.4.1).
+type RelayData struct {
+	// PeerAddr is the address of the relay agent closest to the server.
+	PeerAddr net.IP
+	Message  *dhcpv6.RelayMessage
+}
+
+// LeasequeryReply is the answer to a Leasequery.
+type LeasequeryReply struct {
+	// Clients is empty if the server has no binding matching the query.
+	Clients []ClientData
+
+	// ClientLinks is set instead of Clients if the client of a
+	// QueryByClientID without LinkAddr has bindings on multiple links
+	// (OPTION_LQ_CLIENT_LINK). Repeat the query with one of these as
+	// LinkAddr to obtain the bindings.
+	ClientLinks []net.IP
+}
+
+// Leasequery asks the server for the bindings matching q (RFC 5007), e.g. to
+// audit which prefixes a server considers delegated. The request is sent to
+// ClientConfig.RemoteAddr, which should be the unicast address of the server,
+// and is not retransmitted. Servers answer a query they do not support or
+// allow with a *StatusError.
+func (c *Client) Leasequery(ctx context.Context, q LeasequeryQuery) (*LeasequeryReply, error) {
+	msg, err := newLeasequery(c.duid, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.setTransactionID(msg); err != nil {
+		return nil, err
+	}
+	reply, err := c.sendReceive(ctx, msg, dhcpv6.MessageTypeNone)
+	if err != nil {
+		return nil, err
+	}
+	return parseLeasequeryReply(reply, c.timeNow())
+}
+
+// newLeasequery returns a LEASEQUERY message sent by requestor for q.
+func newLeasequery(requestor *dhcpv6.Duid, q LeasequeryQuery) (*dhcpv6.Message, error) {
+	linkAddr := net.IPv6unspecified
+	if q.LinkAddr != nil {
+		if linkAddr = q.LinkAddr.To16(); linkAddr == nil {
+			return nil, fmt.Errorf("invalid link address %v", q.LinkAddr)
+		}
+	}
+	var queryOpts dhcpv6.Options
+	switch q.Type {
+	case QueryByAddress:
+		addr := q.Addr.To16()
+		if addr == nil || addr.To4() != nil {
+			return nil, fmt.Errorf("%v: invalid IPv6 address %v", q.Type, q.Addr)
+		}
+		queryOpts.Add(&dhcpv6.OptIAAddress{IPv6Addr: addr})
+	case QueryByClientID:
+		if q.ClientID == nil {
+			return nil, fmt.Errorf("%v: ClientID not set", q.Type)
+		}
+		queryOpts.Add(dhcpv6.OptClientID(*q.ClientID))
+	case QueryByRelayID:
+		if q.RelayID == nil {
+			return nil, fmt.Errorf("%v: RelayID not set", q.Type)
+		}
+		queryOpts.Add(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionRelayID,
+			OptionData: q.RelayID.ToBytes(),
+		})
+	case QueryByLinkAddress:
+		// The link to query is specified by the link-address field.
+	case QueryByRemoteID:
+		if q.RemoteID == nil {
+			return nil, fmt.Errorf("%v: RemoteID not set", q.Type)
+		}
+		queryOpts.Add(q.RemoteID)
+	default:
+		return nil, fmt.Errorf("unsupported query type %v", q.Type)
+	}
+
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageType = dhcpv6.MessageTypeLeaseQuery
+	msg.AddOption(dhcpv6.OptClientID(*requestor))
+	b := make([]byte, 0, 1+net.IPv6len)
+	b = append(b, byte(q.Type))
+	b = append(b, linkAddr...)
+	msg.AddOption(&dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionLQQuery,
+		OptionData: append(b, queryOpts.ToBytes()...),
+	})
+	return msg, nil
+}
+
+// parseLeasequeryReply decodes the LEASEQUERY-REPLY msg, received at now.
+func parseLeasequeryReply(msg *dhcpv6.Message, now time.Time) (*LeasequeryReply, error) {
+	if sc := msg.Options.Status(); sc != nil && sc.StatusCode != iana.StatusSuccess {
+		return nil, &StatusError{Code: sc.StatusCode, Message: sc.StatusMessage}
+	}
+	var reply LeasequeryReply
+	for _, opt := range msg.GetOption(dhcpv6.OptionClientData) {
+		cd, err := parseClientData(opt.ToBytes(), now)
+		if err != nil {
+			return nil, err
+		}
+		reply.Clients = append(reply.Clients, *cd)
+	}
+	if opt := msg.GetOneOption(dhcpv6.OptionLQClientLink); opt != nil {
+		b := opt.ToBytes()
+		if len(b)%net.IPv6len != 0 {
+			return nil, fmt.Errorf("OPTION_LQ_CLIENT_LINK: invalid length %d", len(b))
+		}
+		for ; len(b) > 0; b = b[net.IPv6len:] {
+			reply.ClientLinks = append(reply.ClientLinks, net.IP(append([]byte(nil), b[:net.IPv6len]...)))
+		}
+	}
+	return &reply, nil
+}
+
+// parseClientData decodes an OPTION_CLIENT_DATA, whose lifetimes are relative
+// to now.
+func parseClientData(data []byte, now time.Time) (*ClientData, error) {
+	var opts dhcpv6.MessageOptions
+	if err := opts.FromBytes(data); err != nil {
+		return nil, fmt.Errorf("OPTION_CLIENT_DATA: %w", err)
+	}
+	cid := opts.ClientID()
+	if cid == nil {
+		return nil, fmt.Errorf("OPTION_CLIENT_DATA: Client ID missing")
+	}
+	cd := ClientData{ClientID: *cid}
+	for _, opt := range opts.Options {
+		switch o := opt.(type) {
+		case *dhcpv6.OptIAAddress:
+			cd.Leases = append(cd.Leases, newLease(net.IPNet{
+				IP:   o.IPv6Addr,
+				Mask: net.CIDRMask(128, 128),
+			}, o.PreferredLifetime, o.ValidLifetime, now))
+		case *dhcpv6.OptIAPrefix:
+			if o.Prefix == nil {
+				continue
+			}
+			cd.Leases = append(cd.Leases, newLease(*o.Prefix, o.PreferredLifetime, o.ValidLifetime, now))
+		default:
+			if opt.Code() != dhcpv6.OptionCLTTime {
+				continue
+			}
+			b := opt.ToBytes()
+			if len(b) != 4 {
+				return nil, fmt.Errorf("OPTION_CLT_TIME: invalid length %d", len(b))
+			}
+			cd.LastTransaction = time.Duration(binary.BigEndian.Uint32(b)) * time.Second
+		}
+	}
+	if opt := opts.GetOne(dhcpv6.OptionLQRelayData); opt != nil {
+		b := opt.ToBytes()
+		if len(b) < net.IPv6len {
+			return nil, fmt.Errorf("OPTION_LQ_RELAY_DATA too short: %d bytes", len(b))
+		}
+		relay, err := dhcpv6.RelayMessageFromBytes(b[net.IPv6len:])
+		if err != nil {
+			return nil, fmt.Errorf("OPTION_LQ_RELAY_DATA: %w", err)
+		}
+		cd.Relay = &RelayData{
+			PeerAddr: net.IP(append([]byte(nil), b[:net.IPv6len]...)),
+			Message:  relay,
+		}
+	}
+	return &cd, nil
+}
@@ -0,0 +1,302 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+var testLeasequeryClientDUID = dhcpv6.Duid{
+	Type:          dhcpv6.DUID_LL,
+	HwType:        iana.HWTypeEthernet,
+	LinkLayerAddr: net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+}
+
+// leasequeryServer returns a handler which answers Leasequery messages for
+// prefix, delegated to testLeasequeryClientDUID, and records the received
+// OPTION_LQ_QUERY in query.
+func leasequeryServer(prefix net.IPNet, query *[]byte) dhcp6test.HandlerFunc {
+	return func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		*query = msg.GetOneOption(dhcpv6.OptionLQQuery).ToBytes()
+		reply, err := dhcpv6.NewMessage()
+		if err != nil {
+			panic(err)
+		}
+		reply.MessageType = dhcpv6.MessageTypeLeaseQueryReply
+		reply.TransactionID = msg.TransactionID
+		reply.AddOption(dhcpv6.OptServerID(dhcp6test.DefaultServerID))
+		reply.AddOption(msg.GetOneOption(dhcpv6.OptionClientID))
+		var data dhcpv6.Options
+		data.Add(dhcpv6.OptClientID(testLeasequeryClientDUID))
+		data.Add(&dhcpv6.OptIAPrefix{
+			PreferredLifetime: 1 * time.Hour,
+			ValidLifetime:     2 * time.Hour,
+			Prefix:            &prefix,
+		})
+		data.Add(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionCLTTime,
+			OptionData: []byte{0, 0, 0, 42},
+		})
+		reply.AddOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionClientData,
+			OptionData: data.ToBytes(),
+		})
+		return []*dhcpv6.Message{reply}
+	}
+}
+
+func TestLeasequery(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	var query []byte
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+	srv.Handle(dhcpv6.MessageTypeLeaseQuery, leasequeryServer(prefix, &query))
+	c := newTestClient(t, srv)
+	now := time.Now()
+	c.timeNow = func() time.Time { return now }
+
+	addr := net.ParseIP("2a02:168:4a00::1")
+	reply, err := c.Leasequery(context.Background(), LeasequeryQuery{
+		Type: QueryByAddress,
+		Addr: addr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &LeasequeryReply{
+		Clients: []ClientData{
+			{
+				ClientID: testLeasequeryClientDUID,
+				Leases: []Lease{
+					{
+						Prefix:            prefix,
+						PreferredLifetime: 1 * time.Hour,
+						ValidLifetime:     2 * time.Hour,
+						PreferredUntil:    now.Add(1 * time.Hour),
+						ValidUntil:        now.Add(2 * time.Hour),
+					},
+				},
+				LastTransaction: 42 * time.Second,
+			},
+		},
+	}
+	if diff := cmp.Diff(want, reply); diff != "" {
+		t.Fatalf("unexpected reply: diff (-want +got):\n%s", diff)
+	}
+
+	// query-type, link-address (unspecified) and OPTION_IAADDR
+	wantQuery := append([]byte{byte(QueryByAddress)}, net.IPv6unspecified...)
+	wantQuery = append(wantQuery, dhcpv6.Options{&dhcpv6.OptIAAddress{IPv6Addr: addr}}.ToBytes()...)
+	if !bytes.Equal(query, wantQuery) {
+		t.Errorf("unexpected OPTION_LQ_QUERY: got %x, want %x", query, wantQuery)
+	}
+
+	linkAddr := net.ParseIP("2001:db8::1")
+	if _, err := c.Leasequery(context.Background(), LeasequeryQuery{
+		Type:     QueryByClientID,
+		LinkAddr: linkAddr,
+		ClientID: &testLeasequeryClientDUID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := query[0], byte(QueryByClientID); got != want {
+		t.Errorf("unexpected query-type: got %d, want %d", got, want)
+	}
+	if got := net.IP(query[1:17]); !got.Equal(linkAddr) {
+		t.Errorf("unexpected link-address: got %v, want %v", got, linkAddr)
+	}
+}
+
+func TestLeasequeryStatus(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+	srv.Handle(dhcpv6.MessageTypeLeaseQuery, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		reply, err := dhcpv6.NewMessage()
+		if err != nil {
+			panic(err)
+		}
+		reply.MessageType = dhcpv6.MessageTypeLeaseQueryReply
+		reply.TransactionID = msg.TransactionID
+		reply.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNotAllowed})
+		return []*dhcpv6.Message{reply}
+	})
+	c := newTestClient(t, srv)
+	_, err := c.Leasequery(
+		context.Background(), LeasequeryQuery{
+			Type: QueryByAddress,
+			Addr: net.ParseIP("2a02:168:4a00::1"),
+		})
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != iana.StatusNotAllowed {
+		t.Fatalf("unexpected error: got %v, want NotAllowed", err)
+	}
+}
+
+func TestNewLeasequery(t *testing.T) {
+	for _, q := range []LeasequeryQuery{
+		{Type: QueryByAddress},
+		{Type: QueryByAddress, Addr: net.ParseIP("192.0.2.1")},
+		{Type: QueryByClientID},
+		{Type: 3},
+	} {
+		if _, err := newLeasequery(&dhcp6test.DefaultServerID, q); err == nil {
+			t.Errorf("newLeasequery(%+v) unexpectedly succeeded", q)
+		}
+	}
+}
+
+// bulkLeasequeryServer reads one LEASEQUERY from conn and answers it with
+// replies, which are built from the received message. conn is closed
+// afterwards.
+func bulkLeasequeryServer(t *testing.T, conn net.Conn, replies ...func(*dhcpv6.Message) *dhcpv6.Message) {
+	defer conn.Close()
+	raw, err := readTCPMessage(conn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	msg, err := dhcpv6.MessageFromBytes(raw)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, reply := range replies {
+		if err := writeTCPMessage(conn, reply(msg).ToBytes()); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+// bulkMessage returns a function building a message of type typ in response
+// to a LEASEQUERY, with an OPTION_CLIENT_DATA for each prefix.
+func bulkMessage(typ dhcpv6.MessageType, prefixes ...net.IPNet) func(*dhcpv6.Message) *dhcpv6.Message {
+	return func(query *dhcpv6.Message) *dhcpv6.Message {
+		msg, err := dhcpv6.NewMessage()
+		if err != nil {
+			panic(err)
+		}
+		msg.MessageType = typ
+		msg.TransactionID = query.TransactionID
+		for idx := range prefixes {
+			var data dhcpv6.Options
+			data.Add(dhcpv6.OptClientID(testLeasequeryClientDUID))
+			data.Add(&dhcpv6.OptIAPrefix{
+				PreferredLifetime: 1 * time.Hour,
+				ValidLifetime:     2 * time.Hour,
+				Prefix:            &prefixes[idx],
+			})
+			msg.AddOption(&dhcpv6.OptionGeneric{
+				OptionCode: dhcpv6.OptionClientData,
+				OptionData: data.ToBytes(),
+			})
+		}
+		return msg
+	}
+}
+
+func TestBulkLeasequery(t *testing.T) {
+	p1 := mustParseCIDR("2a02:168:4a00::/48")
+	p2 := mustParseCIDR("2a02:168:4a01::/48")
+	p3 := mustParseCIDR("2a02:168:4a02::/48")
+
+	for _, tt := range []struct {
+		name    string
+		replies []func(*dhcpv6.Message) *dhcpv6.Message
+		want    []net.IPNet
+		wantErr error
+	}{
+		{
+			name: "complete",
+			replies: []func(*dhcpv6.Message) *dhcpv6.Message{
+				bulkMessage(dhcpv6.MessageTypeLeaseQueryReply, p1),
+				bulkMessage(dhcpv6.MessageTypeLeaseQueryData, p2),
+				bulkMessage(dhcpv6.MessageTypeLeaseQueryData, p3),
+				bulkMessage(dhcpv6.MessageTypeLeaseQueryDone),
+			},
+			want: []net.IPNet{p1, p2, p3},
+		},
+		{
+			name: "terminated",
+			replies: []func(*dhcpv6.Message) *dhcpv6.Message{
+				bulkMessage(dhcpv6.MessageTypeLeaseQueryReply, p1),
+				bulkMessage(dhcpv6.MessageTypeLeaseQueryData, p2),
+			},
+			want:    []net.IPNet{p1, p2},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			go bulkLeasequeryServer(t, server, tt.replies...)
+			b, err := NewBulkLeasequeryClient(context.Background(), BulkLeasequeryConfig{
+				DUID: &dhcp6test.DefaultServerID,
+				Conn: client,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			var got []net.IPNet
+			err = b.Query(context.Background(), LeasequeryQuery{Type: QueryByLinkAddress}, func(cd ClientData) error {
+				for _, l := range cd.Leases {
+					got = append(got, l.Prefix)
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Fatalf("unexpected error: got %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBulkLeasequeryStatus(t *testing.T) {
+	client, server := net.Pipe()
+	go bulkLeasequeryServer(t, server, func(query *dhcpv6.Message) *dhcpv6.Message {
+		msg := bulkMessage(dhcpv6.MessageTypeLeaseQueryReply)(query)
+		msg.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusUnknownQueryType})
+		return msg
+	})
+	b, err := NewBulkLeasequeryClient(context.Background(), BulkLeasequeryConfig{
+		DUID: &dhcp6test.DefaultServerID,
+		Conn: client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	err = b.Query(context.Background(), LeasequeryQuery{
+		Type:     QueryByRelayID,
+		RelayID:  &testLeasequeryClientDUID,
+		LinkAddr: net.ParseIP("2001:db8::1"),
+	}, func(ClientData) error { return nil })
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != iana.StatusUnknownQueryType {
+		t.Fatalf("unexpected error: got %v, want UnknownQueryType", err)
+	}
+}
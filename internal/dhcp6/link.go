@@ -0,0 +1,119 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/vishvananda/netlink"
+)
+
+// This file implements ClientConfig.WatchLink: when the link comes back up
+// (e.g. after a modem reboot), the socket might be bound to a link-local
+// address which no longer exists, and the lease might no longer be
+// appropriate for the link.
+
+// linkUp reports whether u indicates that the link is usable.
+func linkUp(u netlink.LinkUpdate) bool {
+	switch u.Attrs().OperState {
+	case netlink.OperUp, netlink.OperUnknown:
+		// Interfaces without carrier detection report unknown.
+		return true
+	}
+	return false
+}
+
+// watchLink notifies c.linkUp when the interface returns to up state, until
+// updates is closed.
+func (c *Client) watchLink(updates <-chan netlink.LinkUpdate) {
+	up := true
+	for u := range updates {
+		if u.Attrs().Index != c.ifindex {
+			continue
+		}
+		wasUp := up
+		up = linkUp(u)
+		if up && !wasUp {
+			c.log.Printf("link %s up again", c.interfaceName)
+			select {
+			case c.linkUp <- struct{}{}:
+			default:
+				// a notification is already pending
+			}
+		}
+	}
+}
+
+// LinkUp returns a channel which receives a value whenever the link came back
+// up after being down, at which point Reconnect should be called. The channel
+// is nil unless ClientConfig.WatchLink is set.
+func (c *Client) LinkUp() <-chan struct{} {
+	return c.linkUp
+}
+
+// Reconnect recreates the socket (unless ClientConfig.Conn was specified) and
+// then verifies the current lease via Confirm. A new lease is obtained if the
+// server determined that the current lease is not appropriate for the link,
+// or if there is no current lease.
+//
+// Like the other exchanges, Reconnect must not be called concurrently with
+// other exchanges of the client.
+func (c *Client) Reconnect(ctx context.Context) (Config, error) {
+	if c.ownConn {
+		if err := c.reopen(); err != nil {
+			return c.result(Config{}, err)
+		}
+	}
+	if c.reply != nil {
+		err := c.Confirm(ctx)
+		if err == nil {
+			return c.result(c.cfg, nil)
+		}
+		if err != ErrNotOnLink {
+			return c.result(Config{}, err)
+		}
+		c.log.Printf("lease not on link, soliciting a new lease")
+	}
+	return c.result(c.obtainOrRenew(ctx, c.maxDuration))
+}
+
+// reopen replaces c.Conn with a new socket, re-resolving the link-local
+// address unless ClientConfig.LocalAddr was specified.
+func (c *Client) reopen() error {
+	laddr := c.laddr
+	if !c.laddrConfigured {
+		llAddr, err := dhcpv6.GetLinkLocalAddr(c.interfaceName)
+		if err != nil {
+			return err
+		}
+		l := *laddr
+		l.IP = llAddr
+		laddr = &l
+	}
+	// The old socket must be closed first, as it is bound to the same port.
+	// Should binding fail, the next Reconnect retries.
+	c.Conn.Close()
+	conn, err := listen(laddr, c.scope, c.bindUnspecified)
+	if err != nil {
+		return err
+	}
+	if err := joinGroup(conn, c.scope); err != nil {
+		c.log.Printf("joining %v on %s: %v", dhcpv6.AllDHCPRelayAgentsAndServers, c.interfaceName, err)
+	}
+	c.Conn = conn
+	c.laddr = laddr
+	return nil
+}
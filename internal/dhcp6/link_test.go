@@ -0,0 +1,122 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+	"github.com/vishvananda/netlink"
+)
+
+func TestWatchLink(t *testing.T) {
+	c := newTestClient(t, dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}))
+	c.ifindex = 3
+	c.linkUp = make(chan struct{}, 1)
+	update := func(index int, state netlink.LinkOperState) netlink.LinkUpdate {
+		return netlink.LinkUpdate{Link: &netlink.Device{LinkAttrs: netlink.LinkAttrs{
+			Index:     index,
+			OperState: state,
+		}}}
+	}
+	updates := make(chan netlink.LinkUpdate)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.watchLink(updates)
+	}()
+	updates <- update(3, netlink.OperUp)   // still up: no notification
+	updates <- update(4, netlink.OperDown) // other interface
+	updates <- update(4, netlink.OperUp)
+	updates <- update(3, netlink.OperDown)
+	updates <- update(3, netlink.OperUp) // up again
+	close(updates)
+	<-done
+	select {
+	case <-c.LinkUp():
+	default:
+		t.Fatalf("no notification after link came back up")
+	}
+	select {
+	case <-c.LinkUp():
+		t.Fatalf("unexpected second notification")
+	default:
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	c := newTestClient(t, srv)
+
+	// Without a lease, Reconnect obtains one.
+	if _, err := c.Reconnect(context.Background()); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+	// The lease is confirmed.
+	if _, err := c.Reconnect(context.Background()); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+	// The lease is not appropriate for the link anymore: the delegated prefix
+	// is confirmed via Rebind, which the server rejects.
+	srv.RespondWithStatus(dhcpv6.MessageTypeRebind, iana.StatusNoBinding)
+	cfg, err := c.Reconnect(context.Background())
+	if err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRebind,
+		dhcpv6.MessageTypeRebind,
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	old, err := listenUDP6(&net.UDPAddr{IP: net.IPv6loopback}, 0)
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	c := newTestClient(t, dhcp6test.NewFakeServer(dhcp6test.ServerConfig{}))
+	c.Conn = old
+	c.laddr = old.LocalAddr().(*net.UDPAddr)
+	c.laddrConfigured = true
+	// The new socket is bound to the same address and port as the old one,
+	// which only succeeds once the old socket is closed.
+	if err := c.reopen(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer c.Conn.Close()
+	if got, want := c.Conn.LocalAddr().String(), old.LocalAddr().String(); got != want {
+		t.Errorf("unexpected local address: got %v, want %v", got, want)
+	}
+	if _, err := old.WriteTo([]byte{0}, c.laddr); err == nil {
+		t.Errorf("old socket still open")
+	}
+}
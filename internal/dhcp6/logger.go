@@ -0,0 +1,54 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import "log"
+
+// Logger receives the client's log messages.
+type Logger interface {
+	// Printf logs noteworthy events, e.g. an invalid option which is ignored
+	// or a saved lease which could not be resumed.
+	Printf(format string, v ...interface{})
+
+	// Debugf logs details which are only of interest when troubleshooting,
+	// e.g. skipped packets of other transactions.
+	Debugf(format string, v ...interface{})
+}
+
+// StdLogger returns a Logger which writes to l (or the standard logger of
+// package log if l is nil), discarding Debugf messages unless debug is true.
+func StdLogger(l *log.Logger, debug bool) Logger {
+	return &stdLogger{l: l, debug: debug}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	debug bool
+}
+
+func (s *stdLogger) Printf(format string, v ...interface{}) {
+	if s.l == nil {
+		log.Printf(format, v...)
+		return
+	}
+	s.l.Printf(format, v...)
+}
+
+func (s *stdLogger) Debugf(format string, v ...interface{}) {
+	if !s.debug {
+		return
+	}
+	s.Printf(format, v...)
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// recordingLogger records Printf and Debugf messages.
+type recordingLogger struct {
+	mu            sync.Mutex
+	printf, debug []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.printf = append(l.printf, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Debugf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debug = append(l.debug, fmt.Sprintf(format, v...))
+}
+
+func TestLogger(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		// An Advertise for a different transaction arrives first.
+		other := srv.Respond(msg)[0]
+		other.TransactionID[0]++
+		return append([]*dhcpv6.Message{other}, srv.Respond(msg)...)
+	})
+	logger := &recordingLogger{}
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:   srv,
+		Logger: logger,
+	})
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.debug) != 2 ||
+		!strings.HasPrefix(logger.debug[0], "DUID: ") ||
+		!strings.HasPrefix(logger.debug[1], "different XID") {
+		t.Errorf("unexpected debug messages: %q", logger.debug)
+	}
+	if len(logger.printf) != 0 {
+		t.Errorf("unexpected messages: %q", logger.printf)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metrics struct {
+	sent            *prometheus.CounterVec
+	retransmissions prometheus.Counter
+	received        *prometheus.CounterVec
+	lastLease       prometheus.Gauge
+	renewAfter      prometheus.Gauge
+	prefixes        prometheus.Gauge
+	acquisition     prometheus.Gauge
+}
+
+func newMetrics() metrics {
+	return metrics{
+		sent: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp6_messages_sent_total",
+				Help: "Number of DHCPv6 messages sent, including retransmissions",
+			},
+			[]string{"type"},
+		),
+		retransmissions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dhcp6_retransmissions_total",
+			Help: "Number of DHCPv6 messages retransmitted because no reply arrived",
+		}),
+		received: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp6_messages_received_total",
+				Help: "Number of DHCPv6 messages accepted in response to our messages",
+			},
+			[]string{"type"},
+		),
+		lastLease: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dhcp6_last_lease_timestamp_seconds",
+			Help: "When the last lease was obtained, renewed or rebound",
+		}),
+		renewAfter: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dhcp6_renew_after_timestamp_seconds",
+			Help: "When the current lease should be renewed (T1)",
+		}),
+		prefixes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dhcp6_delegated_prefixes",
+			Help: "Number of prefixes delegated in the current lease",
+		}),
+		acquisition: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dhcp6_acquisition_duration_seconds",
+			Help: "How long obtaining the most recent lease took, from the first Solicit to the Reply",
+		}),
+	}
+}
+
+// RegisterMetrics registers the client's metrics (messages sent and received,
+// retransmissions, details of the current lease and how long obtaining it
+// took) with reg.
+func (c *Client) RegisterMetrics(reg *prometheus.Registry) error {
+	for _, collector := range []prometheus.Collector{
+		c.prom.sent,
+		c.prom.retransmissions,
+		c.prom.received,
+		c.prom.lastLease,
+		c.prom.renewAfter,
+		c.prom.prefixes,
+		c.prom.acquisition,
+	} {
+		if err := reg.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
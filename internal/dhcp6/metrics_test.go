@@ -0,0 +1,84 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Drop(1) // simulate packet loss
+	c := newTestClient(t, srv)
+	reg := prometheus.NewRegistry()
+	if err := c.RegisterMetrics(reg); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tt := range []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{"Solicits sent", c.prom.sent.WithLabelValues("SOLICIT"), 2},
+		{"Requests sent", c.prom.sent.WithLabelValues("REQUEST"), 1},
+		{"retransmissions", c.prom.retransmissions, 1},
+		{"Advertises received", c.prom.received.WithLabelValues("ADVERTISE"), 1},
+		{"Replies received", c.prom.received.WithLabelValues("REPLY"), 1},
+		{"RenewAfter", c.prom.renewAfter, float64(cfg.RenewAfter.Unix())},
+		{"delegated prefixes", c.prom.prefixes, 1},
+		{"acquisition duration", c.prom.acquisition, c.AcquisitionDuration().Seconds()},
+	} {
+		if got := testutil.ToFloat64(tt.collector); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// The first Solicit was lost, so acquiring the lease took at least its
+	// retransmission timeout (0.9 to 1.1 times IRT, see newTestClient).
+	acquisition := c.AcquisitionDuration()
+	if min := 9 * time.Millisecond; acquisition < min {
+		t.Errorf("AcquisitionDuration() = %v, want at least %v", acquisition, min)
+	}
+	if _, err := c.Renew(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.AcquisitionDuration(); got != acquisition {
+		t.Errorf("AcquisitionDuration() changed by Renew: got %v, want %v", got, acquisition)
+	}
+	// Without a lease, the lease gauges are reset.
+	if _, _, err := c.Release(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name      string
+		collector prometheus.Collector
+	}{
+		{"RenewAfter", c.prom.renewAfter},
+		{"delegated prefixes", c.prom.prefixes},
+	} {
+		if got := testutil.ToFloat64(tt.collector); got != 0 {
+			t.Errorf("%s after Release: got %v, want 0", tt.name, got)
+		}
+	}
+}
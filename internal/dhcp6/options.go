@@ -0,0 +1,315 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// This file contains encoders and decoders for DHCPv6 options which
+// github.com/insomniacslk/dhcp/dhcpv6 does not (yet) implement.
+
+// parsePDExclude decodes an OPTION_PD_EXCLUDE (RFC 6603, section 4.2) found
+// within the IAPrefix option for the delegated prefix.
+func parsePDExclude(delegated *net.IPNet, data []byte) (*net.IPNet, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("OPTION_PD_EXCLUDE too short: %d bytes", len(data))
+	}
+	delegatedLen, _ := delegated.Mask.Size()
+	excludedLen := int(data[0])
+	if excludedLen <= delegatedLen || excludedLen > 128 {
+		return nil, fmt.Errorf("OPTION_PD_EXCLUDE: invalid prefix length %d for delegated prefix %v", excludedLen, delegated)
+	}
+	subnetID := data[1:]
+	if want := (excludedLen-delegatedLen-1)/8 + 1; len(subnetID) != want {
+		return nil, fmt.Errorf("OPTION_PD_EXCLUDE: subnet ID is %d bytes, want %d", len(subnetID), want)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, delegated.IP.To16())
+	// The subnet ID contains the bits following the delegated prefix, up to
+	// the excluded prefix length.
+	for i := 0; i < excludedLen-delegatedLen; i++ {
+		pos := delegatedLen + i
+		if subnetID[i/8]&(0x80>>uint(i%8)) != 0 {
+			ip[pos/8] |= 0x80 >> uint(pos%8)
+		} else {
+			ip[pos/8] &^= 0x80 >> uint(pos%8)
+		}
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(excludedLen, 8*net.IPv6len),
+	}, nil
+}
+
+// parseUnicast decodes an OPTION_UNICAST (RFC 8415, section 21.12), which
+// contains the address to which the client may unicast messages to the server.
+func parseUnicast(data []byte) (net.IP, error) {
+	if len(data) != net.IPv6len {
+		return nil, fmt.Errorf("invalid length: got %d bytes, want %d", len(data), net.IPv6len)
+	}
+	ip := net.IP(append([]byte(nil), data...))
+	if ip.IsUnspecified() || ip.IsMulticast() || ip.To4() != nil {
+		return nil, fmt.Errorf("%v is not a unicast IPv6 address", ip)
+	}
+	return ip, nil
+}
+
+// parseMaxRT decodes an OPTION_SOL_MAX_RT or OPTION_INF_MAX_RT (RFC 8415,
+// sections 21.24 and 21.25). Values outside of [60, 86400] seconds are invalid.
+func parseMaxRT(data []byte) (time.Duration, error) {
+	if len(data) != 4 {
+		return 0, fmt.Errorf("invalid length: got %d bytes, want 4", len(data))
+	}
+	secs := binary.BigEndian.Uint32(data)
+	if secs < 60 || secs > 86400 {
+		return 0, fmt.Errorf("%d seconds out of range [60, 86400]", secs)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+const (
+	irtDefault = 86400 * time.Second // IRT_DEFAULT
+	irtMinimum = 600 * time.Second   // IRT_MINIMUM
+)
+
+// parseInformationRefreshTime decodes an OPTION_INFORMATION_REFRESH_TIME (RFC
+// 8415, section 21.23). Values below IRT_MINIMUM are raised to IRT_MINIMUM.
+func parseInformationRefreshTime(data []byte) (time.Duration, error) {
+	if len(data) != 4 {
+		return 0, fmt.Errorf("invalid length: got %d bytes, want 4", len(data))
+	}
+	irt := time.Duration(binary.BigEndian.Uint32(data)) * time.Second
+	if irt < irtMinimum {
+		irt = irtMinimum
+	}
+	return irt, nil
+}
+
+// parseDomainSearchList decodes an OPTION_DOMAIN_LIST (RFC 3646, section 4),
+// which contains a sequence of domain names in RFC 1035 encoding. While RFC
+// 3646 forbids compression, compression pointers (RFC 1035, section 4.1.4)
+// relative to the start of the option are accepted for robustness.
+func parseDomainSearchList(data []byte) ([]string, error) {
+	var domains []string
+	for off := 0; off < len(data); {
+		domain, next, err := parseDomainName(data, off)
+		if err != nil {
+			return nil, err
+		}
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+		off = next
+	}
+	return domains, nil
+}
+
+// maxPointers limits the number of compression pointers followed when
+// decoding a single domain name, so that pointer loops terminate.
+const maxPointers = 64
+
+// parseDomainName decodes the domain name starting at offset off of data and
+// returns it, along with the offset following its encoding.
+func parseDomainName(data []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1 // offset following the domain name, once known
+	for pointers := 0; ; {
+		if off >= len(data) {
+			return "", 0, fmt.Errorf("domain name truncated")
+		}
+		length := int(data[off])
+		switch {
+		case length == 0:
+			if next == -1 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+
+		case length&0xc0 == 0xc0:
+			if off+2 > len(data) {
+				return "", 0, fmt.Errorf("compression pointer truncated")
+			}
+			if next == -1 {
+				next = off + 2
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, fmt.Errorf("too many compression pointers")
+			}
+			off = int(binary.BigEndian.Uint16(data[off:]) & 0x3fff)
+
+		case length&0xc0 != 0:
+			return "", 0, fmt.Errorf("unsupported label type %#x", length&0xc0)
+
+		default:
+			if off+1+length > len(data) {
+				return "", 0, fmt.Errorf("label truncated")
+			}
+			labels = append(labels, string(data[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// parseSNTPServers decodes an OPTION_SNTP_SERVERS (RFC 4075, section 4).
+func parseSNTPServers(data []byte) ([]net.IP, error) {
+	if len(data)%net.IPv6len != 0 {
+		return nil, fmt.Errorf("OPTION_SNTP_SERVERS: length %d is not a multiple of %d", len(data), net.IPv6len)
+	}
+	var servers []net.IP
+	for off := 0; off < len(data); off += net.IPv6len {
+		servers = append(servers, net.IP(append([]byte(nil), data[off:off+net.IPv6len]...)))
+	}
+	return servers, nil
+}
+
+// suboptions of OPTION_NTP_SERVER (RFC 5908, section 4)
+const (
+	ntpSuboptionSrvAddr = 1
+	ntpSuboptionMCAddr  = 2
+	ntpSuboptionSrvFQDN = 3
+)
+
+// parseNTPServer decodes an OPTION_NTP_SERVER (RFC 5908, section 4), which
+// contains one or more time sources as server address, multicast address or
+// server FQDN suboptions.
+func parseNTPServer(data []byte) ([]string, error) {
+	var servers []string
+	for off := 0; off < len(data); {
+		if off+4 > len(data) {
+			return nil, fmt.Errorf("OPTION_NTP_SERVER: suboption header truncated")
+		}
+		code := binary.BigEndian.Uint16(data[off:])
+		length := int(binary.BigEndian.Uint16(data[off+2:]))
+		off += 4
+		if off+length > len(data) {
+			return nil, fmt.Errorf("OPTION_NTP_SERVER: suboption %d truncated", code)
+		}
+		subopt := data[off : off+length]
+		off += length
+		switch code {
+		case ntpSuboptionSrvAddr, ntpSuboptionMCAddr:
+			if length != net.IPv6len {
+				return nil, fmt.Errorf("OPTION_NTP_SERVER: suboption %d: invalid address length %d", code, length)
+			}
+			servers = append(servers, net.IP(subopt).String())
+		case ntpSuboptionSrvFQDN:
+			fqdn, next, err := parseDomainName(subopt, 0)
+			if err != nil {
+				return nil, fmt.Errorf("OPTION_NTP_SERVER: %w", err)
+			}
+			if next != len(subopt) {
+				return nil, fmt.Errorf("OPTION_NTP_SERVER: trailing bytes after FQDN")
+			}
+			servers = append(servers, fqdn)
+		default:
+			// RFC 5908, section 4: unknown suboptions are ignored
+		}
+	}
+	return servers, nil
+}
+
+// maxPreference is the Preference option value which instructs the client to
+// select the server immediately.
+const maxPreference = 255
+
+// preference returns the value of the Preference option (RFC 8415, section
+// 21.8) of msg, or 0 if the option is absent or invalid.
+func preference(msg *dhcpv6.Message) uint8 {
+	opt := msg.GetOneOption(dhcpv6.OptionPreference)
+	if opt == nil {
+		return 0
+	}
+	if b := opt.ToBytes(); len(b) == 1 {
+		return b[0]
+	}
+	return 0
+}
+
+// pdExclude returns the prefix excluded from the delegated prefix via
+// OPTION_PD_EXCLUDE, or nil if the option is absent.
+func pdExclude(prefix *dhcpv6.OptIAPrefix) (*net.IPNet, error) {
+	opt := prefix.Options.GetOne(dhcpv6.OptionPDExclude)
+	if opt == nil {
+		return nil, nil
+	}
+	return parsePDExclude(prefix.Prefix, opt.ToBytes())
+}
+
+// fqdnOption encodes a Client FQDN option (RFC 4704, section 4.1) for name
+// with flags. A name consisting of a single label (e.g. router7) is encoded
+// as a partial name, which the server completes with its domain; all other
+// names are fully qualified.
+func fqdnOption(name string, flags uint8) (*dhcpv6.OptionGeneric, error) {
+	if flags&FQDNFlagO != 0 {
+		return nil, fmt.Errorf("FQDN: the O flag must only be set by servers")
+	}
+	if flags&FQDNFlagS != 0 && flags&FQDNFlagN != 0 {
+		return nil, fmt.Errorf("FQDN: the S and N flags are mutually exclusive")
+	}
+	name = strings.TrimSuffix(name, ".")
+	data := []byte{flags}
+	if name == "" {
+		// ask the server to provide a name
+		return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionFQDN, OptionData: data}, nil
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("FQDN: invalid label %q in %q", label, name)
+		}
+		data = append(data, byte(len(label)))
+		data = append(data, label...)
+	}
+	if len(labels) > 1 {
+		data = append(data, 0) // fully qualified
+	}
+	if len(data)-1 > 255 {
+		return nil, fmt.Errorf("FQDN: %q exceeds 255 bytes", name)
+	}
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionFQDN, OptionData: data}, nil
+}
+
+// parseFQDN decodes a Client FQDN option (RFC 4704, section 4.1), whose
+// domain name may be partial (i.e. lack the terminating zero-length label).
+func parseFQDN(data []byte) (flags uint8, name string, err error) {
+	if len(data) < 1 {
+		return 0, "", fmt.Errorf("FQDN option too short")
+	}
+	flags = data[0]
+	var labels []string
+	for off := 1; off < len(data); {
+		length := int(data[off])
+		if length == 0 {
+			break
+		}
+		if length&0xc0 != 0 {
+			// RFC 4704, section 4.2: names must not be compressed
+			return 0, "", fmt.Errorf("unsupported label type %#x", length&0xc0)
+		}
+		if off+1+length > len(data) {
+			return 0, "", fmt.Errorf("label truncated")
+		}
+		labels = append(labels, string(data[off+1:off+1+length]))
+		off += 1 + length
+	}
+	return flags, strings.Join(labels, "."), nil
+}
@@ -0,0 +1,436 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestParsePDExclude(t *testing.T) {
+	for _, tt := range []struct {
+		delegated string
+		data      []byte
+		want      string
+	}{
+		{
+			// RFC 6603, section 4.2: excluding a /64 from a /56 needs one byte
+			delegated: "2001:db8:0:ff00::/56",
+			data:      []byte{64, 0x01},
+			want:      "2001:db8:0:ff01::/64",
+		},
+
+		{
+			delegated: "2a02:168:4a00::/48",
+			data:      []byte{64, 0xab, 0xcd},
+			want:      "2a02:168:4a00:abcd::/64",
+		},
+
+		{
+			// subnet ID bits beyond the excluded prefix length are ignored
+			delegated: "2001:db8::/60",
+			data:      []byte{62, 0xff},
+			want:      "2001:db8:0:c::/62",
+		},
+	} {
+		t.Run(tt.delegated, func(t *testing.T) {
+			delegated := mustParseCIDR(tt.delegated)
+			got, err := parsePDExclude(&delegated, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("parsePDExclude() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePDExcludeInvalid(t *testing.T) {
+	delegated := mustParseCIDR("2001:db8::/56")
+	for _, data := range [][]byte{
+		nil,
+		{64},
+		{56, 0x00},       // not longer than the delegated prefix
+		{64, 0x01, 0x02}, // subnet ID too long
+		{72, 0x01},       // subnet ID too short
+	} {
+		if got, err := parsePDExclude(&delegated, data); err == nil {
+			t.Errorf("parsePDExclude(%x) = %v, want error", data, got)
+		}
+	}
+}
+
+func TestParseDomainSearchList(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{
+			name: "single",
+			data: []byte("\x04init\x03net\x00"),
+			want: []string{"init.net"},
+		},
+
+		{
+			name: "multiple",
+			data: []byte("\x07example\x03net\x00\x03lan\x00"),
+			want: []string{"example.net", "lan"},
+		},
+
+		{
+			name: "compressed",
+			// fiber7.init7.net, then init7.net via a pointer to offset 7
+			data: []byte("\x06fiber7\x05init7\x03net\x00\xc0\x07"),
+			want: []string{"fiber7.init7.net", "init7.net"},
+		},
+
+		{
+			name: "compressedsuffix",
+			// lan.init7.net, with init7.net via a pointer to offset 0
+			data: []byte("\x05init7\x03net\x00\x03lan\xc0\x00"),
+			want: []string{"init7.net", "lan.init7.net"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDomainSearchList(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected domains: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseDomainSearchListInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("\x04init"),     // label truncated
+		[]byte("\x04init\x03"), // domain name truncated
+		[]byte("\xc0"),         // pointer truncated
+		[]byte("\xc0\x00"),     // pointer loop
+		[]byte("\x40\x00"),     // reserved label type
+	} {
+		if got, err := parseDomainSearchList(data); err == nil {
+			t.Errorf("parseDomainSearchList(%q) = %v, want error", data, got)
+		}
+	}
+}
+
+func TestParseNTPServer(t *testing.T) {
+	data := []byte{
+		0, ntpSuboptionSrvAddr, 0, 16,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x23,
+		0, ntpSuboptionMCAddr, 0, 16,
+		0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x01,
+		0, 42, 0, 1, 0xff, // unknown suboption
+		0, ntpSuboptionSrvFQDN, 0, 15,
+	}
+	data = append(data, "\x03ntp\x05init7\x03net\x00"...)
+	got, err := parseNTPServer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2001:db8::123", "ff02::101", "ntp.init7.net"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected servers: diff (-want +got):\n%s", diff)
+	}
+
+	for _, data := range [][]byte{
+		{0, ntpSuboptionSrvAddr, 0},               // header truncated
+		{0, ntpSuboptionSrvAddr, 0, 16, 0x20},     // suboption truncated
+		{0, ntpSuboptionMCAddr, 0, 2, 0xff, 0x02}, // address too short
+		{0, ntpSuboptionSrvFQDN, 0, 2, 0x03, 'n'}, // FQDN truncated
+	} {
+		if got, err := parseNTPServer(data); err == nil {
+			t.Errorf("parseNTPServer(%x) = %v, want error", data, got)
+		}
+	}
+}
+
+func TestFQDN(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		flags uint8
+		want  []byte
+	}{
+		{"router7.example.net", FQDNFlagS, []byte("\x01\x07router7\x07example\x03net\x00")},
+		{"router7.example.net.", FQDNFlagS, []byte("\x01\x07router7\x07example\x03net\x00")},
+		{"router7", FQDNFlagN, []byte("\x04\x07router7")}, // partial
+		{"", 0, []byte{0}},
+	} {
+		opt, err := fqdnOption(tt.name, tt.flags)
+		if err != nil {
+			t.Fatalf("fqdnOption(%q): %v", tt.name, err)
+		}
+		if diff := cmp.Diff(tt.want, opt.ToBytes()); diff != "" {
+			t.Errorf("fqdnOption(%q): diff (-want +got):\n%s", tt.name, diff)
+		}
+		flags, name, err := parseFQDN(opt.ToBytes())
+		if err != nil {
+			t.Fatalf("parseFQDN(%x): %v", opt.ToBytes(), err)
+		}
+		if want := strings.TrimSuffix(tt.name, "."); name != want || flags != tt.flags {
+			t.Errorf("parseFQDN(%x) = %d, %q, want %d, %q", opt.ToBytes(), flags, name, tt.flags, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name  string
+		flags uint8
+	}{
+		{"router7.example.net", FQDNFlagS | FQDNFlagN},
+		{"router7.example.net", FQDNFlagO},
+		{"router7..net", 0},
+		{strings.Repeat("a", 64) + ".net", 0},
+		{strings.Repeat(strings.Repeat("a", 63)+".", 4) + "net", 0},
+	} {
+		if _, err := fqdnOption(tt.name, tt.flags); err == nil {
+			t.Errorf("fqdnOption(%q, %d) unexpectedly succeeded", tt.name, tt.flags)
+		}
+	}
+
+	for _, data := range [][]byte{
+		{},              // flags missing
+		{0, 0x07, 'r'},  // label truncated
+		{0, 0xc0, 0x01}, // compressed
+	} {
+		if _, _, err := parseFQDN(data); err == nil {
+			t.Errorf("parseFQDN(%x) unexpectedly succeeded", data)
+		}
+	}
+}
+
+func TestORO(t *testing.T) {
+	oro := []dhcpv6.OptionCode{
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+		dhcpv6.OptionNTPServer,
+		dhcpv6.OptionSolMaxRT,
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	c := newTestClientConfig(t, ClientConfig{
+		Conn: srv,
+		ORO:  oro,
+	})
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.InformationRequest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	requested := make(map[dhcpv6.MessageType]dhcpv6.OptionCodes)
+	for _, msg := range srv.Received() {
+		requested[msg.MessageType] = msg.Options.RequestedOptions()
+	}
+	want := map[dhcpv6.MessageType]dhcpv6.OptionCodes{
+		dhcpv6.MessageTypeSolicit: oro,
+		dhcpv6.MessageTypeRequest: oro,
+		dhcpv6.MessageTypeInformationRequest: append(append([]dhcpv6.OptionCode(nil), oro...),
+			dhcpv6.OptionInfMaxRT,
+			dhcpv6.OptionInformationRefreshTime),
+	}
+	if diff := cmp.Diff(want, requested); diff != "" {
+		t.Fatalf("unexpected ORO: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBootFile(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	const url = "tftp://[2001:db8::1]/router7.img"
+	params := []string{"console=ttyS0", "root=/dev/ram0"}
+	var send bool
+	modifyResponses(srv, func(reply *dhcpv6.Message) {
+		if send {
+			reply.AddOption(dhcpv6.OptBootFileURL(url))
+			reply.AddOption(dhcpv6.OptBootFileParam(params...))
+		}
+	})
+	c := newTestClient(t, srv)
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, msg := range srv.Received() {
+		requested := msg.Options.RequestedOptions()
+		for _, code := range []dhcpv6.OptionCode{dhcpv6.OptionBootfileURL, dhcpv6.OptionBootfileParam} {
+			if !requested.Contains(code) {
+				t.Errorf("%v not requested in %v: ORO %v", code, msg.MessageType, requested)
+			}
+		}
+	}
+	if cfg.BootFileURL != "" || cfg.BootFileParams != nil {
+		t.Errorf("unexpected boot file without options: %q, %q", cfg.BootFileURL, cfg.BootFileParams)
+	}
+
+	send = true
+	cfg, err = c.Renew(context.Background())
+	if err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if got, want := cfg.BootFileURL, url; got != want {
+		t.Errorf("BootFileURL: got %q, want %q", got, want)
+	}
+	if diff := cmp.Diff(params, cfg.BootFileParams); diff != "" {
+		t.Errorf("unexpected BootFileParams: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	vc := &dhcpv6.OptVendorClass{
+		EnterpriseNumber: 872, // AVM GmbH
+		Data:             [][]byte{[]byte("FRITZ!Box")},
+	}
+	for _, tt := range []struct {
+		name string
+		cfg  ClientConfig
+		code dhcpv6.OptionCode
+		want []byte // in Solicit and Request
+	}{
+		{
+			name: "Vendor Class",
+			cfg:  ClientConfig{VendorClass: vc},
+			code: dhcpv6.OptionVendorClass,
+			want: vc.ToBytes(),
+		},
+
+		{
+			name: "User Class",
+			cfg:  ClientConfig{UserClass: [][]byte{[]byte("router7"), []byte("lab")}},
+			code: dhcpv6.OptionUserClass,
+			want: []byte("\x00\x07router7\x00\x03lab"),
+		},
+
+		{
+			name: "Client FQDN",
+			cfg:  ClientConfig{FQDN: "router7", FQDNFlags: FQDNFlagN},
+			code: dhcpv6.OptionFQDN,
+			want: []byte("\x04\x07router7"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(mustParseCIDR("2a02:168:4a00::/48"))
+			cfg := tt.cfg
+			cfg.Conn = srv
+			c := newTestClientConfig(t, cfg)
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := make(map[dhcpv6.MessageType][]byte)
+			for _, msg := range srv.Received() {
+				if opt := msg.GetOneOption(tt.code); opt != nil {
+					got[msg.MessageType] = opt.ToBytes()
+				}
+			}
+			want := map[dhcpv6.MessageType][]byte{
+				dhcpv6.MessageTypeSolicit: tt.want,
+				dhcpv6.MessageTypeRequest: tt.want,
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("unexpected %v option: diff (-want +got):\n%s", tt.code, diff)
+			}
+		})
+	}
+}
+
+func TestVendorOpts(t *testing.T) {
+	opts := []VendorOption{
+		{EnterpriseNumber: 872, Code: 1, Data: []byte("hello")},
+		{EnterpriseNumber: 4491, Code: 2, Data: []byte{0x01}},
+		{EnterpriseNumber: 872, Code: 3, Data: []byte("world")},
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	var sent []VendorOption
+	srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		vos := msg.Options.VendorOpts()
+		if got, want := len(vos), 2; got != want {
+			t.Errorf("unexpected number of Vendor-specific Information options: got %d, want %d", got, want)
+		}
+		for _, vo := range vos {
+			for _, opt := range vo.VendorOpts {
+				sent = append(sent, VendorOption{
+					EnterpriseNumber: vo.EnterpriseNumber,
+					Code:             uint16(opt.Code()),
+					Data:             opt.ToBytes(),
+				})
+			}
+		}
+		for _, reply := range replies {
+			reply.AddOption(&dhcpv6.OptVendorOpts{
+				EnterpriseNumber: 872,
+				VendorOpts: dhcpv6.Options{
+					&dhcpv6.OptionGeneric{OptionCode: 42, OptionData: []byte("hint")},
+				},
+			})
+		}
+		return replies
+	})
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:       srv,
+		VendorOpts: opts,
+	})
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantSent := []VendorOption{opts[0], opts[2], opts[1]} // grouped
+	if diff := cmp.Diff(wantSent, sent); diff != "" {
+		t.Errorf("unexpected vendor options sent: diff (-want +got):\n%s", diff)
+	}
+	want := []VendorOption{
+		{EnterpriseNumber: 872, Code: 42, Data: []byte("hint")},
+	}
+	if diff := cmp.Diff(want, cfg.VendorOpts); diff != "" {
+		t.Errorf("unexpected vendor options received: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientFQDN(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	modifyResponses(srv, func(reply *dhcpv6.Message) {
+		// The server completes the partial name and overrides the client's
+		// wish for it not to perform updates.
+		reply.AddOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionFQDN,
+			OptionData: []byte("\x03\x07router7\x07example\x03net\x00"),
+		})
+	})
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:      srv,
+		FQDN:      "router7",
+		FQDNFlags: FQDNFlagN,
+	})
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := cfg.FQDN, "router7.example.net"; got != want {
+		t.Errorf("unexpected FQDN: got %q, want %q", got, want)
+	}
+	if got, want := cfg.FQDNFlags, uint8(FQDNFlagS|FQDNFlagO); got != want {
+		t.Errorf("unexpected FQDN flags: got %d, want %d", got, want)
+	}
+}
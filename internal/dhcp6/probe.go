@@ -0,0 +1,81 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// ProbeResult describes the Advertise a server sent in response to Probe.
+type ProbeResult struct {
+	ServerID   dhcpv6.Duid
+	Preference uint8
+
+	// Prefixes and Addresses contain what the server offered.
+	Prefixes  []net.IPNet
+	Addresses []net.IPNet
+
+	// Status is non-nil if the server answered, but declined to offer a
+	// lease (e.g. NoPrefixAvail).
+	Status *StatusError
+
+	// Elapsed is the time between sending the first Solicit and receiving
+	// the Advertise, including retransmissions.
+	Elapsed time.Duration
+
+	Advertise *dhcpv6.Message
+}
+
+// Probe checks whether a DHCPv6 server answers on the link by sending a
+// Solicit (without Rapid Commit, so that no binding is created), and returns
+// the preferred Advertise. Probe neither sends a Request nor changes the
+// current lease, which makes it suitable for diagnostics.
+//
+// As Solicit messages are retransmitted until an Advertise arrives, ctx should
+// carry a deadline; errors.Is(err, context.DeadlineExceeded) then means that
+// no server answered.
+func (c *Client) Probe(ctx context.Context) (*ProbeResult, error) {
+	solicit, err := c.newSolicit()
+	if err != nil {
+		return nil, err
+	}
+	solicit.Options.Del(dhcpv6.OptionRapidCommit)
+	start := c.timerNow()
+	adv, err := c.sendReceive(ctx, solicit, dhcpv6.MessageTypeAdvertise)
+	if err != nil {
+		return nil, err
+	}
+	cfg := c.configFromReply(adv, c.timerNow())
+	result := &ProbeResult{
+		Preference: preference(adv),
+		Prefixes:   cfg.Prefixes,
+		Addresses:  cfg.Addresses,
+		Elapsed:    c.timerNow().Sub(start),
+		Advertise:  adv,
+	}
+	if sid := adv.Options.ServerID(); sid != nil {
+		result.ServerID = *sid
+	}
+	var se *StatusError
+	if errors.As(statusError(adv), &se) {
+		result.Status = se
+	}
+	return result, nil
+}
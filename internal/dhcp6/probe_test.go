@@ -0,0 +1,61 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestProbe(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:    []net.IPNet{prefix},
+		RapidCommit: true,
+	})
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:        srv,
+		RapidCommit: true,
+	})
+	res, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Rapid Commit must not be requested, so only an Advertise is returned.
+	want := []dhcpv6.MessageType{dhcpv6.MessageTypeSolicit}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+	if got, want := res.Advertise.MessageType, dhcpv6.MessageTypeAdvertise; got != want {
+		t.Fatalf("unexpected message type: got %v, want %v", got, want)
+	}
+	if !res.ServerID.Equal(dhcp6test.DefaultServerID) {
+		t.Fatalf("unexpected server ID: got %v, want %v", res.ServerID, dhcp6test.DefaultServerID)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, res.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	if res.Status != nil {
+		t.Fatalf("unexpected status: %v", res.Status)
+	}
+	if diff := cmp.Diff(Config{}, c.Config()); diff != "" {
+		t.Fatalf("Probe modified the config: diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Listen waits for a Reconfigure message (RFC 8415, section 18.2.11) from the
+// server which granted the current lease, and then performs the Renew, Rebind
+// or Information-Request the server asked for, returning the resulting
+// Config. Reconfigure messages which are not authenticated with the
+// reconfigure key the server sent in its Reply (or via delayed authentication
+// with ClientConfig.AuthKey, if set) are discarded.
+//
+// Listen returns ctx.Err() if ctx is done before a Reconfigure message
+// arrives, so a typical caller listens until T1, then renews:
+//
+//	ctx, cancel := context.WithDeadline(ctx, cfg.RenewAfter)
+//	cfg, err := c.Listen(ctx)
+//	cancel()
+//
+// The client must have been created with ClientConfig.AcceptReconfigure. Listen
+// must not be called concurrently with other exchanges of the client.
+func (c *Client) Listen(ctx context.Context) (Config, error) {
+	if !c.acceptReconfigure {
+		return Config{}, fmt.Errorf("Reconfigure not accepted (see ClientConfig.AcceptReconfigure)")
+	}
+	if c.reply == nil {
+		return Config{}, fmt.Errorf("no lease to reconfigure")
+	}
+	if c.reconfigureKey == nil && c.authKey == nil {
+		return Config{}, fmt.Errorf("server did not send a reconfigure key")
+	}
+	typ, err := c.awaitReconfigure(ctx)
+	if err != nil {
+		return Config{}, err
+	}
+	switch typ {
+	case dhcpv6.MessageTypeRenew:
+		return c.result(c.renew(ctx, 0, true))
+	case dhcpv6.MessageTypeRebind:
+		return c.result(c.rebind(ctx, 0))
+	default: // dhcpv6.MessageTypeInformationRequest
+		info, err := c.InformationRequest(ctx)
+		if err != nil {
+			return Config{}, err
+		}
+		// Only the configuration parameters changed, the lease is retained.
+		return c.result(withLease(info, c.Config()), nil)
+	}
+}
+
+// withLease returns the configuration parameters of info, the result of an
+// Information-Request, combined with the lease described by cfg.
+func withLease(info, cfg Config) Config {
+	info.RenewAfter = cfg.RenewAfter
+	info.RebindAfter = cfg.RebindAfter
+	info.T1 = cfg.T1
+	info.T2 = cfg.T2
+	info.Infinite = cfg.Infinite
+	info.ServerID = cfg.ServerID
+	info.Leases = cfg.Leases
+	info.Prefixes = cfg.Prefixes
+	info.Addresses = cfg.Addresses
+	info.FQDN = cfg.FQDN
+	info.FQDNFlags = cfg.FQDNFlags
+	info.Delegations = cfg.Delegations
+	info.Excluded = cfg.Excluded
+	info.Transition = cfg.Transition
+	info.TransitionAt = cfg.TransitionAt
+	return info
+}
+
+// awaitReconfigure reads from c.Conn until a valid Reconfigure message arrives
+// and returns the message type the server asked the client to send.
+func (c *Client) awaitReconfigure(ctx context.Context) (dhcpv6.MessageType, error) {
+	defer c.abortReads(ctx)()
+	c.Conn.SetReadDeadline(time.Time{})
+	if err := ctx.Err(); err != nil {
+		// ctx was cancelled before the deadline was reset
+		return 0, err
+	}
+	buf := c.newReceiveBuffer()
+	for {
+		n, _, err := c.Conn.ReadFrom(buf)
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if err != nil {
+			return 0, &SocketError{Op: "read", Err: err}
+		}
+		if c.truncated(n) {
+			continue
+		}
+		raw, _, err := c.unwrap(buf[:n])
+		if err != nil {
+			c.log.Debugf("not relayed: %v", err)
+			continue
+		}
+		msg, err := dhcpv6.MessageFromBytes(raw)
+		if err != nil {
+			c.log.Debugf("non-DHCP: %v", err)
+			continue
+		}
+		if msg.MessageType != dhcpv6.MessageTypeReconfigure {
+			continue
+		}
+		typ, err := c.validReconfigure(raw, msg)
+		if err != nil {
+			c.log.Printf("discarding Reconfigure: %v", err)
+			continue
+		}
+		return typ, nil
+	}
+}
+
+// validReconfigure returns the message type requested by the Reconfigure
+// message msg (with wire representation raw), or an error if the client must
+// discard msg (RFC 8415, section 18.2.11 and 20.4.3).
+func (c *Client) validReconfigure(raw []byte, msg *dhcpv6.Message) (dhcpv6.MessageType, error) {
+	want := c.reply.Options.ServerID()
+	if sid := msg.Options.ServerID(); sid == nil || want == nil || !sid.Equal(*want) {
+		return 0, fmt.Errorf("Server ID %v does not match the server of our lease", sid)
+	}
+	if cid := msg.Options.ClientID(); cid == nil || !cid.Equal(*c.duid) {
+		return 0, fmt.Errorf("Client ID %v does not match our DUID", cid)
+	}
+	opt := msg.GetOneOption(dhcpv6.OptionReconfMessage)
+	if opt == nil {
+		return 0, fmt.Errorf("Reconfigure Message option missing")
+	}
+	var typ dhcpv6.MessageType
+	if b := opt.ToBytes(); len(b) == 1 {
+		typ = dhcpv6.MessageType(b[0])
+	}
+	switch typ {
+	case dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind,
+		dhcpv6.MessageTypeInformationRequest:
+	default:
+		return 0, fmt.Errorf("invalid Reconfigure Message option %x", opt.ToBytes())
+	}
+	replay, err := validateAuth(raw, c.authKey, c.reconfigureKey, c.replayDetection)
+	if err != nil {
+		return 0, err
+	}
+	c.replayDetection = replay
+	return typ, nil
+}
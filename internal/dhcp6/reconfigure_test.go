@@ -0,0 +1,140 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestReconfigure(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	auth := func(replay uint64, typ byte, value []byte) dhcpv6.Option {
+		data := []byte{authProtocolReconfigureKey, authAlgorithmHMACMD5, authRDMMonotonicCounter}
+		data = append(data, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(data[3:], replay)
+		data = append(data, typ)
+		data = append(data, value...)
+		return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: data}
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		if msg.GetOneOption(dhcpv6.OptionReconfAccept) == nil {
+			t.Errorf("Request does not contain Reconfigure Accept: %v", msg.Summary())
+		}
+		replies := srv.Respond(msg)
+		for _, reply := range replies {
+			reply.AddOption(auth(1, reconfigureKeyValue, key))
+		}
+		return replies
+	})
+	srv.Handle(dhcpv6.MessageTypeInformationRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		for _, reply := range replies {
+			reply.AddOption(dhcpv6.OptDNS(net.ParseIP("2001:db8::53")))
+			reply.AddOption(&dhcpv6.OptionGeneric{
+				OptionCode: dhcpv6.OptionInformationRefreshTime,
+				OptionData: []byte{0, 0, 0x0e, 0x10}, // 3600s
+			})
+			reply.AddOption(dhcpv6.OptBootFileURL("tftp://[2001:db8::69]/boot"))
+		}
+		return replies
+	})
+	c := newTestClientConfig(t, ClientConfig{
+		Conn:              srv,
+		AcceptReconfigure: true,
+	})
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reconfigure := func(typ dhcpv6.MessageType, replay uint64, key []byte) *dhcpv6.Message {
+		msg, err := dhcpv6.NewMessage(
+			dhcpv6.WithServerID(dhcp6test.DefaultServerID),
+			dhcpv6.WithClientID(*c.duid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.MessageType = dhcpv6.MessageTypeReconfigure
+		msg.AddOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionReconfMessage,
+			OptionData: []byte{byte(typ)},
+		})
+		opt := auth(replay, reconfigureKeyHMACMD5, make([]byte, md5.Size)).(*dhcpv6.OptionGeneric)
+		msg.AddOption(opt)
+		mac := hmac.New(md5.New, key)
+		mac.Write(msg.ToBytes())
+		// The digest makes up the last bytes of the Authentication option.
+		copy(opt.OptionData[len(opt.OptionData)-md5.Size:], mac.Sum(nil))
+		return msg
+	}
+
+	srv.Send(reconfigure(dhcpv6.MessageTypeRenew, 2, []byte("wrong key")))
+	srv.Send(reconfigure(dhcpv6.MessageTypeRenew, 1, key)) // replayed
+	valid := reconfigure(dhcpv6.MessageTypeRenew, 2, key)
+	srv.Send(valid)
+	if _, err := c.Listen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+
+	srv.Send(valid) // replayed
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.Listen(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Listen() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The parameters of the Information-Request replace those of the lease,
+	// which is retained.
+	lease := c.Config()
+	srv.Send(reconfigure(dhcpv6.MessageTypeInformationRequest, 3, key))
+	cfg, err := c.Listen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"2001:db8::53"}, cfg.DNS); diff != "" {
+		t.Errorf("unexpected DNS: diff (-want +got):\n%s", diff)
+	}
+	if got, want := cfg.BootFileURL, "tftp://[2001:db8::69]/boot"; got != want {
+		t.Errorf("unexpected boot file URL: got %q, want %q", got, want)
+	}
+	if got, want := cfg.InformationRefreshTime, 1*time.Hour; got != want {
+		t.Errorf("unexpected Information Refresh Time: got %v, want %v", got, want)
+	}
+	if diff := cmp.Diff(lease.Leases, cfg.Leases); diff != "" {
+		t.Errorf("lease not retained: diff (-want +got):\n%s", diff)
+	}
+	if !cfg.RenewAfter.Equal(lease.RenewAfter) {
+		t.Errorf("RenewAfter changed: got %v, want %v", cfg.RenewAfter, lease.RenewAfter)
+	}
+}
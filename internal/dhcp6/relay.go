@@ -0,0 +1,191 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// This file implements Relay-Forward and Relay-Reply messages (RFC 8415,
+// section 9 and 19), so that the client can operate across a relay hop.
+
+// RelayConfig configures the Relay-Forward messages in which the client wraps
+// its messages when ClientConfig.Relay is set.
+//
+// When the client sends from a port other than 547 (e.g. because
+// ClientConfig.SourcePort is set), Relay-Forward messages include the Relay
+// Source Port option (RFC 8357, section 5.2), so that servers send their
+// Relay-Replies to that port instead of 547. Like all replies, Relay-Replies
+// are matched by transaction ID, regardless of the port they were sent from.
+type RelayConfig struct {
+	// LinkAddr identifies the link on which the client is located. It may be
+	// left unspecified (::) if InterfaceID is set.
+	LinkAddr net.IP
+
+	// PeerAddr is the address of the client. It defaults to the address of
+	// ClientConfig.LocalAddr.
+	PeerAddr net.IP
+
+	// InterfaceID, if non-nil, is sent in the Interface-ID option (RFC 8415,
+	// section 21.18), which the server echoes in its Relay-Reply. Servers may
+	// apply policy per Interface-ID, e.g. per physical port of the relay.
+	// Relay-Replies which echo a different Interface-ID are addressed to
+	// another interface of the relay and are discarded. The echoed
+	// Interface-ID is available via Exchange.InterfaceID.
+	InterfaceID []byte
+
+	// ClientLinkLayerAddr includes the Client Link-Layer Address option (RFC
+	// 6939) with the Ethernet address of ClientConfig.InterfaceName, so
+	// that the server can apply MAC-based policy although the client is
+	// not on its link.
+	ClientLinkLayerAddr bool
+}
+
+// optRelaySourcePort is OPTION_RELAY_PORT (RFC 8357), which the dhcpv6
+// package does not define.
+const optRelaySourcePort dhcpv6.OptionCode = 135
+
+// relaySourcePort returns an OPTION_RELAY_PORT. Its Downstream Source Port is
+// 0, as the message did not arrive from a downstream relay.
+func relaySourcePort() dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{
+		OptionCode: optRelaySourcePort,
+		OptionData: []byte{0, 0},
+	}
+}
+
+// optClientLinkLayerAddr is OPTION_CLIENT_LINKLAYER_ADDR (RFC 6939), which
+// the dhcpv6 package does not define.
+const optClientLinkLayerAddr dhcpv6.OptionCode = 79
+
+// clientLinkLayerAddr returns an OPTION_CLIENT_LINKLAYER_ADDR for addr.
+func clientLinkLayerAddr(hwtype iana.HWType, addr net.HardwareAddr) dhcpv6.Option {
+	b := make([]byte, 2, 2+len(addr))
+	binary.BigEndian.PutUint16(b, uint16(hwtype))
+	return &dhcpv6.OptionGeneric{
+		OptionCode: optClientLinkLayerAddr,
+		OptionData: append(b, addr...),
+	}
+}
+
+// relayHeaderLen is the length of msg-type, hop-count, link-address and
+// peer-address of a relay message.
+const relayHeaderLen = 1 + 1 + net.IPv6len + net.IPv6len
+
+// EncapsulateRelayForward wraps msg in a Relay-Forward message with the
+// specified link-address and peer-address. If interfaceID is non-nil, it is
+// included in an Interface-ID option.
+func EncapsulateRelayForward(msg dhcpv6.DHCPv6, linkAddr, peerAddr net.IP, interfaceID []byte) (*dhcpv6.RelayMessage, error) {
+	relay, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, linkAddr, peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if interfaceID != nil {
+		relay.AddOption(dhcpv6.OptInterfaceID(interfaceID))
+	}
+	return relay, nil
+}
+
+// DecapsulateRelayReply returns the message relayed in the Relay-Reply message
+// b, and the contents of its Interface-ID option (nil if absent).
+func DecapsulateRelayReply(b []byte) (*dhcpv6.Message, []byte, error) {
+	inner, interfaceID, err := relayReplyPayload(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := dhcpv6.MessageFromBytes(inner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, interfaceID, nil
+}
+
+// relayReplyPayload returns the wire representation of the message relayed
+// in the Relay-Reply message b, and the contents of its Interface-ID option.
+// The wire representation is returned as-is (instead of re-encoding the
+// parsed message) so that Authentication option digests can be verified.
+func relayReplyPayload(b []byte) (inner, interfaceID []byte, err error) {
+	if len(b) < relayHeaderLen {
+		return nil, nil, fmt.Errorf("relay message too short: %d bytes", len(b))
+	}
+	if typ := dhcpv6.MessageType(b[0]); typ != dhcpv6.MessageTypeRelayReply {
+		return nil, nil, fmt.Errorf("unexpected message type %v, want %v", typ, dhcpv6.MessageTypeRelayReply)
+	}
+	for off := relayHeaderLen; off < len(b); {
+		if off+4 > len(b) {
+			return nil, nil, fmt.Errorf("option header truncated")
+		}
+		code := dhcpv6.OptionCode(binary.BigEndian.Uint16(b[off:]))
+		length := int(binary.BigEndian.Uint16(b[off+2:]))
+		data := b[off+4:]
+		if len(data) < length {
+			return nil, nil, fmt.Errorf("option %v truncated", code)
+		}
+		data = data[:length]
+		switch code {
+		case dhcpv6.OptionRelayMsg:
+			inner = data
+		case dhcpv6.OptionInterfaceID:
+			interfaceID = data
+		}
+		off += 4 + length
+	}
+	if inner == nil {
+		return nil, nil, fmt.Errorf("Relay Message option missing")
+	}
+	return inner, interfaceID, nil
+}
+
+// wrap returns the wire representation of packet, wrapped in a Relay-Forward
+// message if the client operates across a relay hop.
+func (c *Client) wrap(packet *dhcpv6.Message) ([]byte, error) {
+	if c.relay == nil {
+		return packet.ToBytes(), nil
+	}
+	relay, err := EncapsulateRelayForward(packet, c.relay.LinkAddr, c.relay.PeerAddr, c.relay.InterfaceID)
+	if err != nil {
+		return nil, err
+	}
+	if c.relay.ClientLinkLayerAddr && c.hardwareAddr != nil {
+		relay.AddOption(clientLinkLayerAddr(iana.HWTypeEthernet, c.hardwareAddr))
+	}
+	if c.laddr != nil && c.laddr.Port != dhcpv6.DefaultServerPort {
+		relay.AddOption(relaySourcePort())
+	}
+	return relay.ToBytes(), nil
+}
+
+// unwrap returns the wire representation of the message contained in b,
+// which must be a Relay-Reply message if the client operates across a relay
+// hop, and the Interface-ID echoed in the Relay-Reply (nil if absent).
+func (c *Client) unwrap(b []byte) (inner, interfaceID []byte, err error) {
+	if c.relay == nil {
+		return b, nil, nil
+	}
+	inner, interfaceID, err = relayReplyPayload(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if want := c.relay.InterfaceID; want != nil && interfaceID != nil && !bytes.Equal(interfaceID, want) {
+		return nil, nil, fmt.Errorf("Interface-ID %x does not match %x", interfaceID, want)
+	}
+	return inner, interfaceID, nil
+}
@@ -0,0 +1,291 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+// relayConn plays the server side of a relay hop: it unwraps Relay-Forward
+// messages before passing them to the wrapped dhcp6test.Server, and wraps
+// replies in Relay-Reply messages, echoing the Interface-ID option.
+type relayConn struct {
+	*dhcp6test.Server
+	t *testing.T
+	// interfaceID, if non-nil, is echoed instead of the Interface-ID of the
+	// Relay-Forward.
+	interfaceID []byte
+
+	mu       sync.Mutex
+	linkAddr net.IP
+	peerAddr net.IP
+	options  dhcpv6.Options // of the last Relay-Forward
+}
+
+func (rc *relayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	rc.t.Helper()
+	relay, err := dhcpv6.RelayMessageFromBytes(b)
+	if err != nil {
+		rc.t.Fatalf("client did not send a relay message: %v", err)
+	}
+	if got, want := relay.MessageType, dhcpv6.MessageTypeRelayForward; got != want {
+		rc.t.Fatalf("unexpected message type: got %v, want %v", got, want)
+	}
+	inner, err := relay.GetInnerMessage()
+	if err != nil {
+		rc.t.Fatal(err)
+	}
+	rc.mu.Lock()
+	rc.linkAddr = relay.LinkAddr
+	rc.peerAddr = relay.PeerAddr
+	rc.options = relay.Options.Options
+	rc.mu.Unlock()
+	if _, err := rc.Server.WriteTo(inner.ToBytes(), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom wraps the replies of the server in Relay-Reply messages for the
+// last Relay-Forward.
+func (rc *relayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := rc.Server.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+	msg, err := dhcpv6.MessageFromBytes(b[:n])
+	if err != nil {
+		return 0, nil, err
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	reply, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayReply, rc.linkAddr, rc.peerAddr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if id := rc.options.GetOne(dhcpv6.OptionInterfaceID); rc.interfaceID != nil {
+		reply.AddOption(dhcpv6.OptInterfaceID(rc.interfaceID))
+	} else if id != nil {
+		reply.AddOption(id)
+	}
+	return copy(b, reply.ToBytes()), addr, nil
+}
+
+func TestRelay(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	conn := &relayConn{
+		Server: newTestServer(prefix),
+		t:      t,
+	}
+	linkAddr := net.ParseIP("2001:db8::1")
+	c := newTestClientConfig(t, ClientConfig{
+		Conn: conn,
+		Relay: &RelayConfig{
+			LinkAddr:    linkAddr,
+			InterfaceID: []byte("uplink0"),
+		},
+	})
+	cfg, err := c.ObtainOrRenewErr(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, cfg.Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+	}
+	if diff := cmp.Diff(want, conn.ReceivedTypes()); diff != "" {
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+	if !conn.linkAddr.Equal(linkAddr) {
+		t.Errorf("unexpected link-address: got %v, want %v", conn.linkAddr, linkAddr)
+	}
+	// PeerAddr defaults to the address of LocalAddr.
+	if want := net.ParseIP("fe80::42:aff:fea5:966e"); !conn.peerAddr.Equal(want) {
+		t.Errorf("unexpected peer-address: got %v, want %v", conn.peerAddr, want)
+	}
+}
+
+func TestClientLinkLayerAddr(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, enabled := range []bool{false, true} {
+		conn := &relayConn{
+			Server: newTestServer(prefix),
+			t:      t,
+		}
+		c := newTestClientConfig(t, ClientConfig{
+			Conn: conn,
+			Relay: &RelayConfig{
+				LinkAddr:            net.ParseIP("2001:db8::1"),
+				ClientLinkLayerAddr: enabled,
+			},
+		})
+		if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		opt := conn.options.GetOne(optClientLinkLayerAddr)
+		if !enabled {
+			if opt != nil {
+				t.Errorf("unexpected Client Link-Layer Address option: %v", opt)
+			}
+			continue
+		}
+		if opt == nil {
+			t.Fatalf("Client Link-Layer Address option missing")
+		}
+		// link-layer type 1 (Ethernet), followed by the hardware address
+		want := append([]byte{0, 1}, c.hardwareAddr...)
+		if got := opt.ToBytes(); !bytes.Equal(got, want) {
+			t.Errorf("unexpected Client Link-Layer Address: got %x, want %x", got, want)
+		}
+	}
+}
+
+func TestRelaySourcePort(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, tt := range []struct {
+		port int
+		want bool
+	}{
+		{dhcpv6.DefaultServerPort, false},
+		{10547, true},
+	} {
+		conn := &relayConn{
+			Server: newTestServer(prefix),
+			t:      t,
+		}
+		c := newTestClientConfig(t, ClientConfig{
+			Conn:       conn,
+			SourcePort: tt.port,
+			Relay:      &RelayConfig{LinkAddr: net.ParseIP("2001:db8::1")},
+		})
+		if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+			t.Fatalf("port %d: unexpected error: %v", tt.port, err)
+		}
+		opt := conn.options.GetOne(optRelaySourcePort)
+		if !tt.want {
+			if opt != nil {
+				t.Errorf("port %d: unexpected Relay Source Port option: %v", tt.port, opt)
+			}
+			continue
+		}
+		if opt == nil {
+			t.Fatalf("port %d: Relay Source Port option missing", tt.port)
+		}
+		if got, want := opt.ToBytes(), []byte{0, 0}; !bytes.Equal(got, want) {
+			t.Errorf("port %d: unexpected Downstream Source Port: got %x, want %x", tt.port, got, want)
+		}
+	}
+}
+
+func TestRelayInterfaceID(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	interfaceID := []byte("uplink0")
+	for _, tt := range []struct {
+		name   string
+		echo   []byte
+		wantOK bool
+	}{
+		{"echoed", nil, true},
+		{"different", []byte("uplink1"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &relayConn{
+				Server:      newTestServer(prefix),
+				t:           t,
+				interfaceID: tt.echo,
+			}
+			c := newTestClientConfig(t, ClientConfig{
+				Conn: conn,
+				Relay: &RelayConfig{
+					LinkAddr:    net.IPv6unspecified,
+					InterfaceID: interfaceID,
+				},
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			_, err := c.ObtainOrRenewErr(ctx)
+			var sent []byte
+			if opt := conn.options.GetOne(dhcpv6.OptionInterfaceID); opt != nil {
+				sent = opt.ToBytes()
+			}
+			if !bytes.Equal(sent, interfaceID) {
+				t.Errorf("unexpected Interface-ID sent: got %q, want %q", sent, interfaceID)
+			}
+			if !tt.wantOK {
+				if err == nil {
+					t.Fatalf("Relay-Reply for a different Interface-ID unexpectedly accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := c.LastExchange().InterfaceID; !bytes.Equal(got, interfaceID) {
+				t.Errorf("unexpected Exchange.InterfaceID: got %q, want %q", got, interfaceID)
+			}
+		})
+	}
+}
+
+func TestDecapsulateRelayReply(t *testing.T) {
+	inner, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.MessageType = dhcpv6.MessageTypeReply
+	relay, err := dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayReply, net.IPv6unspecified, net.ParseIP("fe80::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.AddOption(dhcpv6.OptInterfaceID([]byte("lan0")))
+	msg, interfaceID, err := DecapsulateRelayReply(relay.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.TransactionID, inner.TransactionID; got != want {
+		t.Errorf("unexpected transaction ID: got %v, want %v", got, want)
+	}
+	if got, want := interfaceID, []byte("lan0"); !bytes.Equal(got, want) {
+		t.Errorf("unexpected interface ID: got %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"short", relay.ToBytes()[:relayHeaderLen-1]},
+		{"not a Relay-Reply", append([]byte{byte(dhcpv6.MessageTypeRelayForward)}, relay.ToBytes()[1:]...)},
+		{"truncated", relay.ToBytes()[:relayHeaderLen+6]},
+		{"no Relay Message", relay.ToBytes()[:relayHeaderLen]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := DecapsulateRelayReply(tt.b); err == nil {
+				t.Fatalf("DecapsulateRelayReply(%x) unexpectedly succeeded", tt.b)
+			}
+		})
+	}
+}
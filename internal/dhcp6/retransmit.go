@@ -0,0 +1,203 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Retransmission contains the retransmission parameters for one message type,
+// as described in RFC 8415, section 15.
+type Retransmission struct {
+	IRT time.Duration // initial retransmission time
+	MRT time.Duration // maximum retransmission time (0 = unbounded)
+	MRC int           // maximum transmission count (0 = unbounded)
+	MRD time.Duration // maximum retransmission duration (0 = unbounded)
+}
+
+// defaultRetransmission contains the transmission and retransmission
+// parameters from RFC 8415, section 7.6.
+var defaultRetransmission = map[dhcpv6.MessageType]Retransmission{
+	dhcpv6.MessageTypeSolicit: {
+		IRT: 1 * time.Second,    // SOL_TIMEOUT
+		MRT: 3600 * time.Second, // SOL_MAX_RT
+	},
+	dhcpv6.MessageTypeRequest: {
+		IRT: 1 * time.Second,  // REQ_TIMEOUT
+		MRT: 30 * time.Second, // REQ_MAX_RT
+		MRC: 10,               // REQ_MAX_RC
+	},
+	dhcpv6.MessageTypeConfirm: {
+		IRT: 1 * time.Second,  // CNF_TIMEOUT
+		MRT: 4 * time.Second,  // CNF_MAX_RT
+		MRD: 10 * time.Second, // CNF_MAX_RD
+	},
+	dhcpv6.MessageTypeRenew: {
+		IRT: 10 * time.Second,  // REN_TIMEOUT
+		MRT: 600 * time.Second, // REN_MAX_RT
+	},
+	dhcpv6.MessageTypeRebind: {
+		IRT: 10 * time.Second,  // REB_TIMEOUT
+		MRT: 600 * time.Second, // REB_MAX_RT
+	},
+	dhcpv6.MessageTypeInformationRequest: {
+		IRT: 1 * time.Second,    // INF_TIMEOUT
+		MRT: 3600 * time.Second, // INF_MAX_RT
+	},
+	dhcpv6.MessageTypeRelease: {
+		IRT: 1 * time.Second, // REL_TIMEOUT
+		MRC: 4,               // REL_MAX_RC
+	},
+	dhcpv6.MessageTypeDecline: {
+		IRT: 1 * time.Second, // DEC_TIMEOUT
+		MRC: 4,               // DEC_MAX_RC
+	},
+}
+
+// copyRetransmission returns a copy of params, which can be modified without
+// affecting params.
+func copyRetransmission(params map[dhcpv6.MessageType]Retransmission) map[dhcpv6.MessageType]Retransmission {
+	cp := make(map[dhcpv6.MessageType]Retransmission, len(params))
+	for typ, p := range params {
+		cp[typ] = p
+	}
+	return cp
+}
+
+// retransmissionFromConfig returns defaultRetransmission with the parameters
+// of the message types in overrides (see ClientConfig.Retransmission)
+// replaced.
+func retransmissionFromConfig(overrides map[dhcpv6.MessageType]Retransmission) (map[dhcpv6.MessageType]Retransmission, error) {
+	params := copyRetransmission(defaultRetransmission)
+	for typ, p := range overrides {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("Retransmission[%v]: %v", typ, err)
+		}
+		params[typ] = p
+	}
+	return params, nil
+}
+
+func (p Retransmission) validate() error {
+	if p.IRT <= 0 {
+		return fmt.Errorf("IRT must be positive, got %v", p.IRT)
+	}
+	if p.MRT < 0 {
+		return fmt.Errorf("MRT must not be negative, got %v", p.MRT)
+	}
+	if p.MRC < 0 {
+		return fmt.Errorf("MRC must not be negative, got %d", p.MRC)
+	}
+	if p.MRD < 0 {
+		return fmt.Errorf("MRD must not be negative, got %v", p.MRD)
+	}
+	return nil
+}
+
+// updateMaxRT applies the SOL_MAX_RT and INF_MAX_RT options (RFC 8415,
+// sections 21.24 and 21.25) of msg, an Advertise or Reply, to all subsequent
+// Solicit and Information-Request messages.
+func (c *Client) updateMaxRT(msg *dhcpv6.Message) {
+	for typ, code := range map[dhcpv6.MessageType]dhcpv6.OptionCode{
+		dhcpv6.MessageTypeSolicit:            dhcpv6.OptionSolMaxRT,
+		dhcpv6.MessageTypeInformationRequest: dhcpv6.OptionInfMaxRT,
+	} {
+		opt := msg.GetOneOption(code)
+		if opt == nil {
+			continue
+		}
+		mrt, err := parseMaxRT(opt.ToBytes())
+		if err != nil {
+			c.log.Printf("ignoring %v option: %v", code, err)
+			continue
+		}
+		p := c.retransmission[typ]
+		p.MRT = mrt
+		c.retransmission[typ] = p
+	}
+}
+
+// ErrTimeout matches any *TimeoutError, so that callers can distinguish a
+// missing reply from other failures via errors.Is(err, ErrTimeout).
+var ErrTimeout = errors.New("dhcp6: no reply")
+
+// TimeoutError is returned when no matching reply was received before the
+// retransmission parameters of the message type were exhausted.
+type TimeoutError struct {
+	MessageType   dhcpv6.MessageType
+	Transmissions int
+	Elapsed       time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("dhcp6: no reply to %v after %d transmission(s) in %v",
+		e.MessageType, e.Transmissions, e.Elapsed)
+}
+
+// Is reports whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// Timeout implements net.Error.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary implements net.Error.
+func (e *TimeoutError) Temporary() bool { return true }
+
+// random returns RAND, a random number chosen with a uniform distribution
+// between -0.1 and +0.1 (RFC 8415, section 15).
+func (c *Client) random() float64 {
+	return (c.randFloat64()*2 - 1) * 0.1
+}
+
+// maxElapsedTime is the largest duration which the Elapsed Time option can
+// express (0xffff hundredths of a second).
+const maxElapsedTime = 0xffff * 10 * time.Millisecond
+
+// elapsedTime returns an Elapsed Time option (RFC 8415, section 21.9) for a
+// transaction which started elapsed ago, capped at maxElapsedTime.
+func elapsedTime(elapsed time.Duration) dhcpv6.Option {
+	if elapsed > maxElapsedTime {
+		elapsed = maxElapsedTime
+	}
+	// Truncate so that the option does not round up past the cap.
+	return dhcpv6.OptElapsedTime(elapsed.Truncate(10 * time.Millisecond))
+}
+
+// initialRT returns the retransmission timeout for the first transmission:
+// RT = IRT + RAND*IRT. For Solicit, RAND is strictly greater than 0.
+func (c *Client) initialRT(typ dhcpv6.MessageType, p Retransmission) time.Duration {
+	rand := c.random()
+	if typ == dhcpv6.MessageTypeSolicit && rand <= 0 {
+		rand = -rand
+		if rand == 0 {
+			rand = 0.1
+		}
+	}
+	return p.IRT + time.Duration(rand*float64(p.IRT))
+}
+
+// nextRT returns the retransmission timeout following prev:
+// RT = 2*RTprev + RAND*RTprev, capped at MRT + RAND*MRT.
+func (c *Client) nextRT(prev time.Duration, p Retransmission) time.Duration {
+	rt := 2*prev + time.Duration(c.random()*float64(prev))
+	if p.MRT > 0 && rt > p.MRT {
+		rt = p.MRT + time.Duration(c.random()*float64(p.MRT))
+	}
+	return rt
+}
@@ -0,0 +1,354 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestRetransmission(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Drop(2) // simulate packet loss
+	c := newTestClient(t, srv)
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, c.Config().Prefixes); diff != "" {
+		t.Fatalf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	var elapsed []time.Duration
+	for _, msg := range srv.Received()[:3] {
+		elapsed = append(elapsed, msg.Options.ElapsedTime())
+	}
+	if elapsed[0] != 0 {
+		t.Errorf("Elapsed Time of first Solicit: got %v, want 0", elapsed[0])
+	}
+	for i := 1; i < len(elapsed); i++ {
+		if elapsed[i] <= elapsed[i-1] {
+			t.Errorf("Elapsed Time did not increase: %v", elapsed)
+			break
+		}
+	}
+}
+
+func TestRetransmissionConfig(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	dropAll(srv, dhcpv6.MessageTypeRequest)
+	c := newTestClientConfig(t, ClientConfig{
+		Conn: srv,
+		Retransmission: map[dhcpv6.MessageType]Retransmission{
+			dhcpv6.MessageTypeRequest: {IRT: 10 * time.Millisecond, MRC: 2},
+		},
+	})
+	_, err := c.ObtainOrRenewErr(context.Background())
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("unexpected error: got %v, want a *TimeoutError", err)
+	}
+	if got, want := timeoutErr.Transmissions, 2; got != want {
+		t.Errorf("unexpected number of transmissions: got %d, want %d", got, want)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRequest,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Fatalf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp6", "[fe80::42:aff:fea5:966e]:546")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		params  Retransmission
+		wantErr bool
+	}{
+		{"unbounded", Retransmission{IRT: time.Second}, false},
+		{"zero IRT", Retransmission{MRC: 2}, true},
+		{"negative MRT", Retransmission{IRT: time.Second, MRT: -time.Second}, true},
+		{"negative MRC", Retransmission{IRT: time.Second, MRC: -1}, true},
+		{"negative MRD", Retransmission{IRT: time.Second, MRD: -time.Second}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(ClientConfig{
+				InterfaceName: "lo",
+				LocalAddr:     laddr,
+				HardwareAddr:  []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+				Conn:          newTestServer(prefix),
+				Retransmission: map[dhcpv6.MessageType]Retransmission{
+					dhcpv6.MessageTypeConfirm: tt.params,
+				},
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewClient unexpectedly succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.params, c.retransmission[dhcpv6.MessageTypeConfirm]); diff != "" {
+				t.Errorf("unexpected Confirm parameters: diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(defaultRetransmission[dhcpv6.MessageTypeRenew], c.retransmission[dhcpv6.MessageTypeRenew]); diff != "" {
+				t.Errorf("unexpected Renew parameters: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRetransmissionConfigMRD(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, typ := range []dhcpv6.MessageType{
+		dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind,
+	} {
+		t.Run(typ.String(), func(t *testing.T) {
+			srv := newTestServer(prefix)
+			mrd := 50 * time.Millisecond
+			c := newTestClientConfig(t, ClientConfig{
+				Conn: srv,
+				Retransmission: map[dhcpv6.MessageType]Retransmission{
+					typ: {IRT: 10 * time.Millisecond, MRD: mrd},
+				},
+			})
+			if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			dropAll(srv, typ)
+			// Neither T2 (30 minutes away) nor the valid lifetime may replace
+			// the configured MRD.
+			var err error
+			if typ == dhcpv6.MessageTypeRenew {
+				_, err = c.Renew(context.Background())
+			} else {
+				_, err = c.Rebind(context.Background())
+			}
+			var te *TimeoutError
+			if !errors.As(err, &te) {
+				t.Fatalf("unexpected error: got %v, want a *TimeoutError", err)
+			}
+			if te.MessageType != typ {
+				t.Errorf("unexpected message type: got %v, want %v", te.MessageType, typ)
+			}
+			if te.Elapsed < mrd || te.Elapsed > 10*mrd {
+				t.Errorf("exchange did not stop at the configured MRD: elapsed %v, MRD %v", te.Elapsed, mrd)
+			}
+		})
+	}
+}
+
+func TestRetransmissionTimer(t *testing.T) {
+	c := &Client{randFloat64: func() float64 { return 1 }} // RAND = +0.1
+	p := defaultRetransmission[dhcpv6.MessageTypeRequest]
+	rt := c.initialRT(dhcpv6.MessageTypeRequest, p)
+	if want := 1100 * time.Millisecond; rt != want {
+		t.Fatalf("initialRT = %v, want %v", rt, want)
+	}
+	for i := 0; i < 10; i++ {
+		rt = c.nextRT(rt, p)
+	}
+	if want := p.MRT + p.MRT/10; rt != want {
+		t.Fatalf("nextRT did not cap at MRT: got %v, want %v", rt, want)
+	}
+
+	c.randFloat64 = func() float64 { return 0 } // RAND = -0.1
+	if rt := c.initialRT(dhcpv6.MessageTypeSolicit, p); rt <= p.IRT {
+		t.Fatalf("initialRT(Solicit) = %v, want > %v", rt, p.IRT)
+	}
+}
+
+func TestRetransmissionTimeout(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+	dropAll(srv, dhcpv6.MessageTypeRequest)
+	c := newTestClient(t, srv)
+	c.advertise = &dhcpv6.Message{MessageType: dhcpv6.MessageTypeAdvertise}
+	c.advertise.AddOption(dhcpv6.OptClientID(*c.duid))
+	c.advertise.AddOption(dhcpv6.OptServerID(dhcp6test.DefaultServerID))
+	c.advertise.AddOption(&dhcpv6.OptIANA{})
+	_, _, err := c.request(context.Background(), c.advertise, c.retransmission[dhcpv6.MessageTypeRequest])
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("request() = %v, want *TimeoutError", err)
+	}
+	if got, want := te.Transmissions, defaultRetransmission[dhcpv6.MessageTypeRequest].MRC; got != want {
+		t.Fatalf("unexpected number of transmissions: got %d, want %d", got, want)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("errors.Is(%v, ErrTimeout) = false, want true", err)
+	}
+}
+
+func TestObtainOrRenewTimeout(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+	dropAll(srv, dhcpv6.MessageTypeSolicit)
+	c := newTestClient(t, srv)
+	c.ReadTimeout = 50 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.ObtainOrRenew()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ObtainOrRenew did not return")
+	}
+	if err := c.Err(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("c.Err() = %v, want ErrTimeout", err)
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	for _, tt := range []struct {
+		name string
+		// The server does not answer messages of this type, whose exchange
+		// times out.
+		want dhcpv6.MessageType
+	}{
+		{
+			name: "no server",
+			want: dhcpv6.MessageTypeSolicit,
+		},
+
+		{
+			name: "no reply",
+			want: dhcpv6.MessageTypeRequest,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			const maxDuration = 200 * time.Millisecond
+			srv := newTestServer(prefix)
+			dropAll(srv, tt.want)
+			c := newTestClientConfig(t, ClientConfig{
+				Conn:        srv,
+				MaxDuration: maxDuration,
+			})
+			start := time.Now()
+			_, err := c.ObtainOrRenewErr(context.Background())
+			if elapsed := time.Since(start); elapsed > 2*maxDuration {
+				t.Errorf("ObtainOrRenewErr returned after %v, want at most %v", elapsed, maxDuration)
+			}
+			var te *TimeoutError
+			if !errors.As(err, &te) {
+				t.Fatalf("ObtainOrRenewErr() = %v, want *TimeoutError", err)
+			}
+			if te.MessageType != tt.want {
+				t.Errorf("unexpected message type: got %v, want %v", te.MessageType, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+	dropAll(srv, dhcpv6.MessageTypeSolicit)
+	c := newTestClient(t, srv)
+	c.retransmission = defaultRetransmission // Solicit retransmits forever
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := c.ObtainOrRenewErr(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ObtainOrRenewErr() = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > defaultRetransmission[dhcpv6.MessageTypeSolicit].IRT {
+		t.Fatalf("ObtainOrRenewErr() took %v to return after cancellation", elapsed)
+	}
+}
+
+func TestElapsedTime(t *testing.T) {
+	for _, tt := range []struct {
+		elapsed time.Duration
+		want    []byte
+	}{
+		{0, []byte{0, 0}},
+		{1234 * time.Millisecond, []byte{0, 123}},
+		{maxElapsedTime, []byte{0xff, 0xff}},
+		{24 * time.Hour, []byte{0xff, 0xff}},
+	} {
+		if got := elapsedTime(tt.elapsed).ToBytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("elapsedTime(%v) = %x, want %x", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestMaxRT(t *testing.T) {
+	maxRT := func(code dhcpv6.OptionCode, secs uint32) dhcpv6.Option {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, secs)
+		return &dhcpv6.OptionGeneric{OptionCode: code, OptionData: data}
+	}
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		for _, reply := range replies {
+			reply.AddOption(maxRT(dhcpv6.OptionSolMaxRT, 7200))
+		}
+		return replies
+	})
+	srv.Handle(dhcpv6.MessageTypeInformationRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		for _, reply := range replies {
+			reply.AddOption(maxRT(dhcpv6.OptionInfMaxRT, 30)) // out of range
+		}
+		return replies
+	})
+	c := newTestClient(t, srv)
+
+	infMaxRT := c.retransmission[dhcpv6.MessageTypeInformationRequest].MRT
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.InformationRequest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.retransmission[dhcpv6.MessageTypeSolicit].MRT, 2*time.Hour; got != want {
+		t.Errorf("unexpected Solicit MRT: got %v, want %v", got, want)
+	}
+	if got, want := c.retransmission[dhcpv6.MessageTypeInformationRequest].MRT, infMaxRT; got != want {
+		t.Errorf("unexpected Information-Request MRT: got %v, want %v", got, want)
+	}
+	if got, want := defaultRetransmission[dhcpv6.MessageTypeSolicit].MRT, 3600*time.Second; got != want {
+		t.Errorf("default Solicit MRT modified: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Run obtains a lease and keeps it current until ctx is done, at which point
+// ctx.Err() is returned:
+//
+//   - At Config.RenewAfter (which includes ClientConfig.RenewJitter), the
+//     lease is extended via Renew, falling back to Rebind and then to
+//     obtaining a new lease. Infinite leases (Config.Infinite) are not
+//     renewed.
+//   - With ClientConfig.AcceptReconfigure, Reconfigure messages are processed
+//     while waiting (see Listen).
+//   - With ClientConfig.WatchLink, Reconnect is called when the link comes
+//     back up.
+//   - After ForceRenew, a new lease is obtained via Solicit/Request.
+//   - Failed exchanges are logged and retried with exponential backoff. After
+//     a *SocketError, the retry is a Reconnect, which reopens the socket.
+//
+// Use ClientConfig.OnConfigChange or Config to learn about the current
+// lease. Run must not be called concurrently with other exchanges of the
+// client.
+func (c *Client) Run(ctx context.Context) error {
+	retry := c.retryBackoff
+	select {
+	case <-c.forceRenew:
+	default:
+	}
+	cfg, err := c.ObtainOrRenewErr(ctx)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			dur := retry.Duration()
+			c.log.Printf("%v, retrying in %v", err, dur)
+			if err := sleep(ctx, dur); err != nil {
+				return err
+			}
+			var se *SocketError
+			if errors.As(err, &se) {
+				cfg, err = c.Reconnect(ctx)
+			} else {
+				cfg, err = c.extend(ctx)
+			}
+			continue
+		}
+		retry.Reset()
+		cfg, err = c.await(ctx, cfg)
+	}
+}
+
+// extend extends the current lease like Run does at T1, or obtains a new
+// lease if there is none.
+func (c *Client) extend(ctx context.Context) (Config, error) {
+	if c.reply == nil {
+		return c.ObtainOrRenewErr(ctx)
+	}
+	cfg, err := c.Renew(ctx)
+	if err == nil || ctx.Err() != nil {
+		return cfg, err
+	}
+	c.log.Printf("Renew: %v, trying Rebind", err)
+	cfg, err = c.Rebind(ctx)
+	if err == nil || ctx.Err() != nil {
+		return cfg, err
+	}
+	c.log.Printf("Rebind: %v, obtaining a new lease", err)
+	return c.ObtainOrRenewErr(ctx)
+}
+
+// ForceRenew makes Run obtain a new lease via Solicit/Request without waiting
+// for Config.RenewAfter, e.g. when the user asks to refresh the WAN lease.
+// Unlike the other methods of Client, ForceRenew may be called concurrently
+// with Run. It does not block: the request is honored once Run awaits the
+// next renewal, and multiple requests made in the meantime result in a
+// single exchange. Requests made before Run starts are discarded, as Run
+// starts by obtaining a lease anyway.
+func (c *Client) ForceRenew() {
+	select {
+	case c.forceRenew <- struct{}{}:
+	default:
+	}
+}
+
+type listenResult struct {
+	cfg Config
+	err error
+}
+
+// await waits until the lease described by cfg needs to be renewed, the
+// server reconfigured the client, the link came back up or ForceRenew was
+// called, and returns the outcome of the resulting exchange.
+func (c *Client) await(ctx context.Context, cfg Config) (Config, error) {
+	var t1 <-chan time.Time
+	if !cfg.Infinite {
+		timer := time.NewTimer(cfg.RenewAfter.Sub(c.timeNow()))
+		defer timer.Stop()
+		t1 = timer.C
+	}
+
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var reconfigured chan listenResult
+	if c.acceptReconfigure {
+		reconfigured = make(chan listenResult, 1)
+		go func() {
+			cfg, err := c.Listen(listenCtx)
+			reconfigured <- listenResult{cfg, err}
+		}()
+	}
+	// stopListening waits for Listen to return, so that the next exchange
+	// does not race with it. A Reconfigure processed in the meantime
+	// supersedes the next exchange.
+	stopListening := func() (Config, bool) {
+		cancel()
+		if reconfigured == nil {
+			return Config{}, false
+		}
+		res := <-reconfigured
+		reconfigured = nil
+		return res.cfg, res.err == nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopListening()
+			return Config{}, ctx.Err()
+
+		case <-t1:
+			if cfg, ok := stopListening(); ok {
+				return cfg, nil
+			}
+			return c.extend(ctx)
+
+		case <-c.LinkUp():
+			if cfg, ok := stopListening(); ok {
+				return cfg, nil
+			}
+			return c.Reconnect(ctx)
+
+		case <-c.forceRenew:
+			stopListening()
+			c.log.Printf("renewal forced, soliciting a new lease")
+			return c.ObtainOrRenewErr(ctx)
+
+		case res := <-reconfigured:
+			reconfigured = nil
+			if res.err == nil {
+				return res.cfg, nil
+			}
+			c.log.Printf("not listening for Reconfigure: %v", res.err)
+		}
+	}
+}
+
+// sleep waits for d, returning early with ctx.Err() if ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
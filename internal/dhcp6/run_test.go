@@ -0,0 +1,200 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestRun(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	// T1 and T2 are transmitted in seconds.
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes: []net.IPNet{prefix},
+		T1:       1 * time.Second,
+		T2:       2 * time.Second,
+	})
+	// The first Solicit is answered without prefixes.
+	srv.RespondWithStatus(dhcpv6.MessageTypeSolicit, iana.StatusNoPrefixAvail)
+	renewed := make(chan struct{}, 1)
+	srv.Handle(dhcpv6.MessageTypeRenew, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		select {
+		case renewed <- struct{}{}:
+		default:
+		}
+		return srv.Respond(msg)
+	})
+	c := newTestClient(t, srv)
+	c.retryBackoff.Min = 10 * time.Millisecond
+	c.retryBackoff.Max = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+	select {
+	case <-renewed:
+	case err := <-errc:
+		t.Fatalf("Run returned before renewing: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout waiting for Renew")
+	}
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("Run: got %v, want %v", err, context.Canceled)
+	}
+	var solicits int
+	for _, typ := range srv.ReceivedTypes() {
+		if typ == dhcpv6.MessageTypeSolicit {
+			solicits++
+		}
+	}
+	if got, want := solicits, 2; got != want {
+		t.Errorf("unexpected number of Solicits: got %d, want %d", got, want)
+	}
+	if got := c.Config().Prefixes; len(got) != 1 || got[0].String() != prefix.String() {
+		t.Errorf("unexpected prefixes: got %v, want [%v]", got, prefix)
+	}
+}
+
+func TestForceRenew(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	srv := newTestServer(prefix)
+	requested := make(chan struct{}, 2)
+	srv.Handle(dhcpv6.MessageTypeRequest, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		defer func() { requested <- struct{}{} }()
+		return srv.Respond(msg)
+	})
+	c := newTestClient(t, srv)
+	// Requests made before Run starts are superseded by its first exchange.
+	c.ForceRenew()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+	wait := func() {
+		t.Helper()
+		select {
+		case <-requested:
+		case err := <-errc:
+			t.Fatalf("Run returned unexpectedly: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for Request")
+		}
+	}
+	wait()
+	// The lease is valid for an hour, so only ForceRenew triggers another
+	// exchange.
+	c.ForceRenew()
+	wait()
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("Run: got %v, want %v", err, context.Canceled)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Errorf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+	if got := c.Config().Prefixes; len(got) != 1 || got[0].String() != prefix.String() {
+		t.Errorf("unexpected prefixes: got %v, want [%v]", got, prefix)
+	}
+}
+
+// flakyConn is a dhcp6test.Server whose first failures writes fail with err.
+type flakyConn struct {
+	*dhcp6test.Server
+	err error
+
+	mu       sync.Mutex
+	failures int
+}
+
+func (fc *flakyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	fc.mu.Lock()
+	fail := fc.failures > 0
+	if fail {
+		fc.failures--
+	}
+	fc.mu.Unlock()
+	if fail {
+		return 0, fc.err
+	}
+	return fc.Server.WriteTo(b, addr)
+}
+
+func TestRunSocketError(t *testing.T) {
+	srv := newTestServer(mustParseCIDR("2a02:168:4a00::/48"))
+	rebound := make(chan struct{}, 1)
+	srv.Handle(dhcpv6.MessageTypeRebind, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		defer func() { rebound <- struct{}{} }()
+		return srv.Respond(msg)
+	})
+	conn := &flakyConn{
+		Server: srv,
+		err:    errors.New("network is unreachable"),
+	}
+	c := newTestClient(t, conn)
+	c.retryBackoff.Min = 10 * time.Millisecond
+	c.retryBackoff.Max = 10 * time.Millisecond
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The first exchange of Run fails with a *SocketError, which Run recovers
+	// from via Reconnect (confirming the delegated prefix via Rebind) instead
+	// of retrying on the same socket.
+	conn.mu.Lock()
+	conn.failures = 1
+	conn.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+	select {
+	case <-rebound:
+	case err := <-errc:
+		t.Fatalf("Run returned unexpectedly: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout waiting for Rebind, got %v", srv.ReceivedTypes())
+	}
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("Run: got %v, want %v", err, context.Canceled)
+	}
+	want := []dhcpv6.MessageType{
+		dhcpv6.MessageTypeSolicit,
+		dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRebind,
+	}
+	if diff := cmp.Diff(want, srv.ReceivedTypes()); diff != "" {
+		t.Errorf("unexpected messages: diff (-want +got):\n%s", diff)
+	}
+}
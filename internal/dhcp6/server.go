@@ -0,0 +1,371 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// ServerConfig contains configuration for NewServer.
+type ServerConfig struct {
+	// DUID identifies the server. A device should use the same DUID as
+	// client and as server (RFC 8415, section 11), so pass the DUID of the
+	// upstream Client. Required.
+	DUID []byte
+
+	// Prefixes is the pool to sub-delegate from, typically the Config.Prefixes
+	// delegated to the upstream Client (see also Server.SetPrefixes).
+	Prefixes []net.IPNet
+
+	// PrefixLength is the length of the sub-prefixes delegated to downstream
+	// routers. It defaults to 64.
+	PrefixLength int
+
+	// PreferredLifetime and ValidLifetime of the sub-prefixes default to 1
+	// and 2 hours. T1 and T2 are 0.5 and 0.8 times PreferredLifetime (RFC
+	// 8415, section 21.21).
+	PreferredLifetime, ValidLifetime time.Duration
+
+	// Logger receives the server's log messages. It defaults to
+	// StdLogger(nil, false).
+	Logger Logger
+
+	// Clock, if non-nil, replaces the system clock for expiring bindings
+	// (for testing).
+	Clock Clock
+}
+
+// Server is a delegating router (RFC 8415, section 6.3) for downstream
+// routers: it answers Solicit, Request, Renew, Rebind and Release messages
+// for IA_PDs with sub-prefixes of its pool. IA_NAs are answered with
+// NoAddrsAvail. Bindings are created by Request and discarded once their
+// valid lifetime passed without a Renew or Rebind.
+//
+// Each IA_PD is delegated a fixed sub-prefix, chosen by hashing the DUID and
+// IAID of the requesting router, so that it usually retains its sub-prefix
+// when the Server is restarted. The first sub-prefix of each pool prefix
+// contains the subnet router7 configures on its own interfaces (see
+// netconfig) and is never delegated.
+type Server struct {
+	duid              *dhcpv6.Duid
+	prefixLength      int
+	preferredLifetime time.Duration
+	validLifetime     time.Duration
+	log               Logger
+	timeNow           func() time.Time
+
+	mu       sync.Mutex
+	pool     []net.IPNet
+	size     int                // number of sub-prefixes per pool prefix
+	bindings map[string]binding // by DUID and IAID
+}
+
+// binding is the sub-prefix delegated to an IA_PD.
+type binding struct {
+	index      int       // of the sub-prefix within each pool prefix
+	validUntil time.Time // after which the binding is discarded
+}
+
+// NewServer returns a Server configured by cfg. Use Serve to answer
+// messages.
+func NewServer(cfg ServerConfig) (*Server, error) {
+	if cfg.DUID == nil {
+		return nil, errors.New("dhcp6: ServerConfig.DUID is required")
+	}
+	duid, err := dhcpv6.DuidFromBytes(cfg.DUID)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp6: ServerConfig.DUID: %v", err)
+	}
+	if cfg.PrefixLength == 0 {
+		cfg.PrefixLength = 64
+	}
+	if cfg.PrefixLength < 1 || cfg.PrefixLength > 64 {
+		return nil, fmt.Errorf("dhcp6: invalid ServerConfig.PrefixLength /%d", cfg.PrefixLength)
+	}
+	if cfg.PreferredLifetime == 0 {
+		cfg.PreferredLifetime = 1 * time.Hour
+	}
+	if cfg.ValidLifetime == 0 {
+		cfg.ValidLifetime = 2 * time.Hour
+	}
+	if cfg.PreferredLifetime > cfg.ValidLifetime {
+		return nil, fmt.Errorf("dhcp6: ServerConfig.PreferredLifetime (%v) exceeds ValidLifetime (%v)", cfg.PreferredLifetime, cfg.ValidLifetime)
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = StdLogger(nil, false)
+	}
+	timeNow := time.Now
+	if cfg.Clock != nil {
+		timeNow = cfg.Clock.Now
+	}
+	s := &Server{
+		duid:              duid,
+		prefixLength:      cfg.PrefixLength,
+		preferredLifetime: cfg.PreferredLifetime,
+		validLifetime:     cfg.ValidLifetime,
+		log:               logger,
+		timeNow:           timeNow,
+		bindings:          make(map[string]binding),
+	}
+	s.SetPrefixes(cfg.Prefixes)
+	return s, nil
+}
+
+// SetPrefixes replaces the pool, e.g. from ClientConfig.OnPrefixChange when
+// the upstream prefix changes. Downstream routers retain the position of
+// their sub-prefix within the pool: when they renew, their sub-prefixes of
+// the old pool are returned with zero lifetimes and replaced with the
+// corresponding sub-prefixes of the new pool.
+func (s *Server) SetPrefixes(prefixes []net.IPNet) {
+	var pool []net.IPNet
+	size := 0
+	for _, p := range prefixes {
+		if err := validatePrefix(p); err != nil {
+			s.log.Printf("not sub-delegating from %v", err)
+			continue
+		}
+		ones, _ := p.Mask.Size()
+		if ones >= s.prefixLength {
+			s.log.Printf("not sub-delegating from %v: prefix not shorter than /%d", p.String(), s.prefixLength)
+			continue
+		}
+		// Limit the pool to 2^16 sub-prefixes, which keeps probing for a
+		// free sub-prefix cheap.
+		n := 1 << 16
+		if bits := s.prefixLength - ones; bits < 16 {
+			n = 1 << uint(bits)
+		}
+		if size == 0 || n < size {
+			size = n
+		}
+		pool = append(pool, net.IPNet{IP: p.IP.Mask(p.Mask), Mask: p.Mask})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pool = pool
+	s.size = size
+	for key, b := range s.bindings {
+		if b.index >= size {
+			delete(s.bindings, key)
+		}
+	}
+}
+
+// Serve answers the messages read from conn until reading fails, and returns
+// the error. conn is typically bound to the DHCPv6 server port (547) on a
+// downstream interface, and a member of the All_DHCP_Relay_Agents_and_Servers
+// multicast group (ff02::1:2) on that interface.
+func (s *Server) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		msg, err := dhcpv6.MessageFromBytes(buf[:n])
+		if err != nil {
+			s.log.Debugf("ignoring packet from %v: %v", addr, err)
+			continue
+		}
+		reply := s.respond(msg)
+		if reply == nil {
+			s.log.Debugf("not answering %v from %v", msg.MessageType, addr)
+			continue
+		}
+		if _, err := conn.WriteTo(reply.ToBytes(), addr); err != nil {
+			s.log.Printf("sending %v to %v: %v", reply.MessageType, addr, err)
+		}
+	}
+}
+
+// respond returns the response to msg, or nil if msg must be discarded (RFC
+// 8415, section 16).
+func (s *Server) respond(msg *dhcpv6.Message) *dhcpv6.Message {
+	cid := msg.Options.ClientID()
+	if cid == nil {
+		return nil
+	}
+	sid := msg.Options.ServerID()
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRebind:
+		if sid != nil {
+			return nil
+		}
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRelease:
+		if sid == nil || !sid.Equal(*s.duid) {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	resp := &dhcpv6.Message{
+		MessageType:   dhcpv6.MessageTypeReply,
+		TransactionID: msg.TransactionID,
+	}
+	if msg.MessageType == dhcpv6.MessageTypeSolicit {
+		resp.MessageType = dhcpv6.MessageTypeAdvertise
+	}
+	resp.AddOption(dhcpv6.OptClientID(*cid))
+	resp.AddOption(dhcpv6.OptServerID(*s.duid))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	if msg.MessageType == dhcpv6.MessageTypeRelease {
+		for _, ia := range msg.Options.IAPD() {
+			delete(s.bindings, bindingKey(cid, ia.IaId))
+		}
+		resp.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess})
+		return resp
+	}
+	for _, ia := range msg.Options.IANA() {
+		resp.AddOption(&dhcpv6.OptIANA{
+			IaId:    ia.IaId,
+			Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoAddrsAvail}}},
+		})
+	}
+	for _, ia := range msg.Options.IAPD() {
+		resp.AddOption(s.delegateLocked(msg.MessageType, cid, ia))
+	}
+	return resp
+}
+
+// expireLocked discards the bindings whose valid lifetime passed. s.mu must
+// be held.
+func (s *Server) expireLocked() {
+	now := s.timeNow()
+	for key, b := range s.bindings {
+		if !now.Before(b.validUntil) {
+			delete(s.bindings, key)
+		}
+	}
+}
+
+// delegateLocked returns the response to ia, which the router identified by
+// cid sent in a message of type mt. s.mu must be held.
+func (s *Server) delegateLocked(mt dhcpv6.MessageType, cid *dhcpv6.Duid, ia *dhcpv6.OptIAPD) *dhcpv6.OptIAPD {
+	opt := &dhcpv6.OptIAPD{IaId: ia.IaId}
+	key := bindingKey(cid, ia.IaId)
+	b, ok := s.bindings[key]
+	switch {
+	case ok:
+	case mt == dhcpv6.MessageTypeRenew:
+		// RFC 8415, section 18.3.4
+		opt.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding})
+		return opt
+	case mt == dhcpv6.MessageTypeRebind:
+		// Rather than creating a binding, invalidate the prefixes of the
+		// router, which then solicits a new lease (RFC 8415, section
+		// 18.3.5).
+		prefixes := ia.Options.Prefixes()
+		if len(prefixes) == 0 {
+			opt.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding})
+			return opt
+		}
+		for _, p := range prefixes {
+			if p.Prefix != nil {
+				opt.Options.Add(&dhcpv6.OptIAPrefix{Prefix: p.Prefix})
+			}
+		}
+		return opt
+	default:
+		if b.index = s.freeIndexLocked(key); b.index == -1 {
+			s.log.Printf("no sub-prefix available for DUID %v, IAID %x", cid, ia.IaId)
+			opt.Options.Add(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoPrefixAvail})
+			return opt
+		}
+	}
+	// Solicits are answered without committing to a binding (RFC 8415,
+	// section 18.3.1).
+	if mt != dhcpv6.MessageTypeSolicit {
+		b.validUntil = s.timeNow().Add(s.validLifetime)
+		s.bindings[key] = b
+	}
+	idx := b.index
+	opt.T1 = s.preferredLifetime / 2
+	opt.T2 = s.preferredLifetime * 4 / 5
+	delegated := make(map[string]bool)
+	for _, p := range s.pool {
+		sub := s.subPrefix(p, idx)
+		delegated[sub.String()] = true
+		opt.Options.Add(&dhcpv6.OptIAPrefix{
+			PreferredLifetime: s.preferredLifetime,
+			ValidLifetime:     s.validLifetime,
+			Prefix:            &sub,
+		})
+	}
+	// Invalidate prefixes which the router still uses, but which are no
+	// longer delegated to it, e.g. after renumbering (RFC 8415, section
+	// 18.3.4).
+	for _, p := range ia.Options.Prefixes() {
+		if p.Prefix == nil || delegated[p.Prefix.String()] {
+			continue
+		}
+		opt.Options.Add(&dhcpv6.OptIAPrefix{Prefix: p.Prefix})
+	}
+	return opt
+}
+
+// freeIndexLocked returns the index of the first sub-prefix not bound to
+// another IA_PD, starting at a position derived from key, or -1 if all
+// sub-prefixes are in use. Index 0 is never returned. s.mu must be held.
+func (s *Server) freeIndexLocked(key string) int {
+	if s.size < 2 {
+		return -1
+	}
+	used := make(map[int]bool, len(s.bindings))
+	for _, b := range s.bindings {
+		used[b.index] = true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	start := int(h.Sum32() % uint32(s.size-1))
+	for i := 0; i < s.size-1; i++ {
+		idx := 1 + (start+i)%(s.size-1)
+		if !used[idx] {
+			return idx
+		}
+	}
+	return -1
+}
+
+// subPrefix returns the sub-prefix with index idx of p.
+func (s *Server) subPrefix(p net.IPNet, idx int) net.IPNet {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, p.IP.To16())
+	hi := binary.BigEndian.Uint64(ip[:8])
+	hi |= uint64(idx) << uint(64-s.prefixLength)
+	binary.BigEndian.PutUint64(ip[:8], hi)
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(s.prefixLength, 8*net.IPv6len)}
+}
+
+func bindingKey(cid *dhcpv6.Duid, iaid [4]byte) string {
+	var b bytes.Buffer
+	b.Write(iaid[:])
+	b.Write(cid.ToBytes())
+	return b.String()
+}
@@ -0,0 +1,260 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestServer(t *testing.T) {
+	pool := mustParseCIDR("2a02:168:4a00::/48")
+	s, err := NewServer(ServerConfig{
+		DUID:         dhcp6test.DefaultServerID.ToBytes(),
+		Prefixes:     []net.IPNet{pool},
+		PrefixLength: 56,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		if reply := s.respond(msg); reply != nil {
+			return []*dhcpv6.Message{reply}
+		}
+		return nil
+	}
+	newClient := func(mac byte) *Client {
+		duid := dhcpv6.Duid{
+			Type:          dhcpv6.DUID_LL,
+			HwType:        1, // Ethernet
+			LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, mac},
+		}
+		// The fake server only transports the messages, which s answers.
+		conn := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{})
+		for _, mt := range []dhcpv6.MessageType{
+			dhcpv6.MessageTypeSolicit,
+			dhcpv6.MessageTypeRequest,
+			dhcpv6.MessageTypeRenew,
+			dhcpv6.MessageTypeRebind,
+			dhcpv6.MessageTypeRelease,
+		} {
+			conn.Handle(mt, handler)
+		}
+		return newTestClientConfig(t, ClientConfig{
+			Conn: conn,
+			DUID: duid.ToBytes(),
+		})
+	}
+
+	obtain := func(c *Client) net.IPNet {
+		t.Helper()
+		cfg, err := c.ObtainOrRenewErr(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := len(cfg.Prefixes), 1; got != want {
+			t.Fatalf("unexpected number of prefixes: got %d, want %d", got, want)
+		}
+		return cfg.Prefixes[0]
+	}
+
+	c1, c2 := newClient(1), newClient(2)
+	p1, p2 := obtain(c1), obtain(c2)
+	for _, p := range []net.IPNet{p1, p2} {
+		if ones, _ := p.Mask.Size(); ones != 56 {
+			t.Errorf("%v: unexpected prefix length: got /%d, want /56", p.String(), ones)
+		}
+		if !pool.Contains(p.IP) {
+			t.Errorf("%v: not within pool %v", p.String(), pool.String())
+		}
+		if p.IP.Equal(pool.IP) {
+			t.Errorf("%v: first sub-prefix of the pool delegated", p.String())
+		}
+	}
+	if p1.String() == p2.String() {
+		t.Fatalf("%v delegated to both routers", p1.String())
+	}
+
+	cfg, err := c1.Renew(context.Background())
+	if err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	if diff := cmp.Diff([]net.IPNet{p1}, cfg.Prefixes); diff != "" {
+		t.Errorf("unexpected prefixes after Renew: diff (-want +got):\n%s", diff)
+	}
+
+	// Renumbering retains the position within the pool and invalidates the
+	// previous sub-prefix.
+	renumbered := mustParseCIDR("2001:db8:1::/48")
+	s.SetPrefixes([]net.IPNet{renumbered})
+	if _, err := c1.Renew(context.Background()); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	want := net.IPNet{
+		IP:   append(append(net.IP(nil), renumbered.IP[:6]...), p1.IP[6:]...),
+		Mask: p1.Mask,
+	}
+	var got []string
+	for _, p := range c1.lastReply.Options.IAPD()[0].Options.Prefixes() {
+		got = append(got, p.Prefix.String()+" "+p.ValidLifetime.String())
+	}
+	wantLifetimes := []string{
+		want.String() + " 2h0m0s",
+		p1.String() + " 0s",
+	}
+	if diff := cmp.Diff(wantLifetimes, got); diff != "" {
+		t.Errorf("unexpected prefixes after renumbering: diff (-want +got):\n%s", diff)
+	}
+
+	// Messages for other servers are discarded.
+	c3 := newClient(3)
+	c3.preferServer = &dhcpv6.Duid{Type: dhcpv6.DUID_UUID, Uuid: make([]byte, 16)}
+	msg, err := c3.newSolicit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.MessageType = dhcpv6.MessageTypeRequest
+	msg.AddOption(dhcpv6.OptServerID(*c3.preferServer))
+	if reply := s.respond(msg); reply != nil {
+		t.Errorf("Request for a different server unexpectedly answered: %v", reply.Summary())
+	}
+
+	// Released sub-prefixes become available again.
+	if _, _, err := c2.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	s.mu.Lock()
+	bindings := len(s.bindings)
+	s.mu.Unlock()
+	if got, want := bindings, 1; got != want {
+		t.Errorf("unexpected number of bindings after Release: got %d, want %d", got, want)
+	}
+}
+
+func TestServerBindings(t *testing.T) {
+	now := time.Now()
+	// A /49 pool contains a single sub-prefix which can be delegated.
+	s, err := NewServer(ServerConfig{
+		DUID:         dhcp6test.DefaultServerID.ToBytes(),
+		Prefixes:     []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+		PrefixLength: 49,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.timeNow = func() time.Time { return now }
+	delegated := mustParseCIDR("2a02:168:4a00:8000::/49")
+	respond := func(mt dhcpv6.MessageType, mac byte, prefixes ...net.IPNet) *dhcpv6.OptIAPD {
+		t.Helper()
+		msg := &dhcpv6.Message{MessageType: mt}
+		msg.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+			Type:          dhcpv6.DUID_LL,
+			HwType:        iana.HWTypeEthernet,
+			LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, mac},
+		}))
+		if mt == dhcpv6.MessageTypeRequest || mt == dhcpv6.MessageTypeRenew {
+			msg.AddOption(dhcpv6.OptServerID(dhcp6test.DefaultServerID))
+		}
+		iapd := &dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 1}}
+		for idx := range prefixes {
+			iapd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: &prefixes[idx]})
+		}
+		msg.AddOption(iapd)
+		reply := s.respond(msg)
+		if reply == nil {
+			t.Fatalf("%v unexpectedly not answered", mt)
+		}
+		return reply.Options.IAPD()[0]
+	}
+	summary := func(iapd *dhcpv6.OptIAPD) []string {
+		var got []string
+		if st := iapd.Options.Status(); st != nil {
+			got = append(got, st.StatusCode.String())
+		}
+		for _, p := range iapd.Options.Prefixes() {
+			got = append(got, p.Prefix.String()+" "+p.ValidLifetime.String())
+		}
+		return got
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		advance time.Duration
+		mt      dhcpv6.MessageType
+		mac     byte
+		want    []string
+	}{
+		{
+			desc: "Renew without binding",
+			mt:   dhcpv6.MessageTypeRenew,
+			mac:  1,
+			want: []string{iana.StatusNoBinding.String()},
+		},
+		{
+			desc: "Rebind without binding",
+			mt:   dhcpv6.MessageTypeRebind,
+			mac:  1,
+			want: []string{delegated.String() + " 0s"},
+		},
+		{
+			desc: "Request",
+			mt:   dhcpv6.MessageTypeRequest,
+			mac:  1,
+			want: []string{delegated.String() + " 2h0m0s"},
+		},
+		{
+			desc: "pool exhausted",
+			mt:   dhcpv6.MessageTypeRequest,
+			mac:  2,
+			want: []string{iana.StatusNoPrefixAvail.String()},
+		},
+		{
+			desc:    "Renew extends the binding",
+			advance: 1 * time.Hour,
+			mt:      dhcpv6.MessageTypeRenew,
+			mac:     1,
+			want:    []string{delegated.String() + " 2h0m0s"},
+		},
+		{
+			desc:    "binding still valid",
+			advance: 1 * time.Hour,
+			mt:      dhcpv6.MessageTypeRequest,
+			mac:     2,
+			want:    []string{iana.StatusNoPrefixAvail.String()},
+		},
+		{
+			desc:    "binding expired",
+			advance: 1 * time.Hour,
+			mt:      dhcpv6.MessageTypeRequest,
+			mac:     2,
+			want:    []string{delegated.String() + " 2h0m0s"},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			got := summary(respond(tt.mt, tt.mac, delegated))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected IA_PD: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
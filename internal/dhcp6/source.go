@@ -0,0 +1,181 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// sourceAddr is a candidate source address (RFC 6724, section 4).
+type sourceAddr struct {
+	IP         net.IP
+	Deprecated bool
+	Temporary  bool // RFC 8981 temporary address
+}
+
+// interfaceAddrs returns the usable IPv6 addresses of the interface with index
+// ifindex as source address candidates. Tentative addresses and addresses
+// which failed duplicate address detection are skipped.
+func interfaceAddrs(ifindex int) ([]sourceAddr, error) {
+	link, err := netlink.LinkByIndex(ifindex)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []sourceAddr
+	for _, addr := range addrs {
+		if addr.Flags&(unix.IFA_F_TENTATIVE|unix.IFA_F_DADFAILED) != 0 {
+			continue
+		}
+		candidates = append(candidates, sourceAddr{
+			IP:         addr.IP,
+			Deprecated: addr.Flags&unix.IFA_F_DEPRECATED != 0 || addr.PreferedLft == 0,
+			Temporary:  addr.Flags&unix.IFA_F_TEMPORARY != 0,
+		})
+	}
+	return candidates, nil
+}
+
+// selectSource returns the candidate to send to dst from, following the rules
+// of RFC 6724, section 5, which apply to addresses of a single interface:
+// prefer the destination itself (rule 1), an appropriate scope (rule 2),
+// non-deprecated addresses (rule 3), a matching label (rule 6) and the
+// longest matching prefix (rule 8). Contrary to rule 7, stable addresses are
+// preferred over temporary addresses, as servers and firewalls may
+// identify routers by address. selectSource returns nil if there are no
+// candidates.
+func selectSource(dst net.IP, candidates []sourceAddr) net.IP {
+	var best *sourceAddr
+	for idx := range candidates {
+		if best == nil || preferSource(dst, &candidates[idx], best) {
+			best = &candidates[idx]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.IP
+}
+
+// preferSource returns whether a is a better source address for dst than b.
+func preferSource(dst net.IP, a, b *sourceAddr) bool {
+	// Rule 1: prefer same address.
+	if a.IP.Equal(dst) || b.IP.Equal(dst) {
+		return a.IP.Equal(dst)
+	}
+	// Rule 2: prefer appropriate scope.
+	if sa, sb, sd := addrScope(a.IP), addrScope(b.IP), addrScope(dst); sa != sb {
+		if sa < sb {
+			return sa >= sd
+		}
+		return sb < sd
+	}
+	// Rule 3: avoid deprecated addresses.
+	if a.Deprecated != b.Deprecated {
+		return !a.Deprecated
+	}
+	// Rule 6: prefer matching label.
+	if la, lb, ld := addrLabel(a.IP), addrLabel(b.IP), addrLabel(dst); (la == ld) != (lb == ld) {
+		return la == ld
+	}
+	// Rule 7 (reversed): prefer stable addresses.
+	if a.Temporary != b.Temporary {
+		return !a.Temporary
+	}
+	// Rule 8: use longest matching prefix.
+	return commonPrefixLen(a.IP, dst) > commonPrefixLen(b.IP, dst)
+}
+
+// Address scopes (RFC 4291, section 2.7, and RFC 6724, section 3.1).
+const (
+	scopeLinkLocal = 0x2
+	scopeGlobal    = 0xe
+)
+
+func addrScope(ip net.IP) int {
+	ip = ip.To16()
+	switch {
+	case ip == nil:
+		return 0
+	case ip.IsMulticast():
+		return int(ip[1] & 0xf)
+	case ip.IsLoopback(), ip.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	default:
+		return scopeGlobal // including unique local addresses
+	}
+}
+
+// policyTable is the default policy table of RFC 6724, section 2.1, ordered
+// by descending prefix length.
+var policyTable = []struct {
+	prefix *net.IPNet
+	label  int
+}{
+	{mustCIDR("::1/128"), 0},
+	{mustCIDR("::ffff:0:0/96"), 4},
+	{mustCIDR("::/96"), 3},
+	{mustCIDR("2001::/32"), 5},
+	{mustCIDR("2002::/16"), 2},
+	{mustCIDR("3ffe::/16"), 12},
+	{mustCIDR("fec0::/10"), 11},
+	{mustCIDR("fc00::/7"), 13},
+	{mustCIDR("::/0"), 1},
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func addrLabel(ip net.IP) int {
+	for _, p := range policyTable {
+		if p.prefix.Contains(ip) {
+			return p.label
+		}
+	}
+	return 1
+}
+
+// commonPrefixLen returns the number of leading bits which a and b share.
+func commonPrefixLen(a, b net.IP) int {
+	a, b = a.To16(), b.To16()
+	if a == nil || b == nil {
+		return 0
+	}
+	n := 0
+	for idx := range a {
+		x := a[idx] ^ b[idx]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return n
+}
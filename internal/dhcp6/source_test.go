@@ -0,0 +1,74 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestSelectSource(t *testing.T) {
+	var (
+		linkLocal  = sourceAddr{IP: net.ParseIP("fe80::42:aff:fea5:966e")}
+		ula        = sourceAddr{IP: net.ParseIP("fd00::1")}
+		global     = sourceAddr{IP: net.ParseIP("2001:db8:1::1")}
+		nearby     = sourceAddr{IP: net.ParseIP("2001:db8:2::1")}
+		temporary  = sourceAddr{IP: net.ParseIP("2001:db8:2::2"), Temporary: true}
+		deprecated = sourceAddr{IP: net.ParseIP("2001:db8:2::3"), Deprecated: true}
+	)
+	for _, tt := range []struct {
+		name       string
+		dst        string
+		candidates []sourceAddr
+		want       net.IP
+	}{
+		{"no candidates", "2001:db8:2::547", nil, nil},
+		{"same address", "2001:db8:1::1", []sourceAddr{nearby, global}, global.IP},
+		{"global scope", "2001:db8:2::547", []sourceAddr{linkLocal, global}, global.IP},
+		{"link-local only", "2001:db8:2::547", []sourceAddr{linkLocal}, linkLocal.IP},
+		{"link-local scope", "fe80::1", []sourceAddr{global, linkLocal}, linkLocal.IP},
+		{"not deprecated", "2001:db8:2::547", []sourceAddr{deprecated, global}, global.IP},
+		{"matching label", "fd00:1::547", []sourceAddr{global, ula}, ula.IP},
+		{"stable", "2001:db8:2::547", []sourceAddr{temporary, nearby}, nearby.IP},
+		{"longest prefix", "2001:db8:2::547", []sourceAddr{global, nearby}, nearby.IP},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectSource(net.ParseIP(tt.dst), tt.candidates); !got.Equal(tt.want) {
+				t.Errorf("selectSource(%v) = %v, want %v", tt.dst, got, tt.want)
+			}
+		})
+	}
+
+	conn := &pktinfoConn{
+		src: linkLocal.IP,
+		addrs: func() ([]sourceAddr, error) {
+			return []sourceAddr{linkLocal, global}, nil
+		},
+	}
+	for _, tt := range []struct {
+		dst  net.Addr
+		want net.IP
+	}{
+		{&net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: 547}, linkLocal.IP},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 547}, linkLocal.IP},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8:2::547"), Port: 547}, global.IP},
+	} {
+		if got := conn.source(tt.dst); !got.Equal(tt.want) {
+			t.Errorf("source(%v) = %v, want %v", tt.dst, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// State is a snapshot of the client state for debugging, e.g. to be rendered
+// as JSON by a debug HTTP handler. DUIDs are hex-encoded in wire format.
+type State struct {
+	Interface string `json:"interface"`
+	DUID      string `json:"duid"`
+	ServerID  string `json:"server_id"` // of the current lease
+	// EphemeralDUID is true if DUID is a DUID-LLT which was generated
+	// without being persisted (see ClientConfig.DUIDPath), i.e. which changes
+	// whenever the client restarts.
+	EphemeralDUID bool `json:"ephemeral_duid"`
+
+	// Config is the current configuration, including the lifetimes of all
+	// leases and the time of the next Renew (Config.RenewAfter).
+	Config Config `json:"config"`
+	// Err is the error of the last exchange, if it failed.
+	Err string `json:"err,omitempty"`
+
+	// LastAdvertise and LastReply summarize the most recently received
+	// messages of these types (see dhcpv6.Message.Summary).
+	LastAdvertise string        `json:"last_advertise,omitempty"`
+	LastReply     string        `json:"last_reply,omitempty"`
+	LastExchange  ExchangeState `json:"last_exchange"`
+
+	AcquisitionDuration time.Duration `json:"acquisition_duration"`
+}
+
+// ExchangeState is the representation of an Exchange in State.
+type ExchangeState struct {
+	MessageType   string    `json:"message_type,omitempty"`
+	Sent          time.Time `json:"sent"`
+	SentBytes     int       `json:"sent_bytes"`
+	Transmissions int       `json:"transmissions"`
+	Received      time.Time `json:"received"`
+	ReceivedType  string    `json:"received_type,omitempty"`
+	ReceivedBytes int       `json:"received_bytes"`
+	ServerID      string    `json:"server_id,omitempty"`
+	InterfaceID   []byte    `json:"interface_id,omitempty"`
+	Err           string    `json:"err,omitempty"`
+}
+
+// StateSnapshot returns the current State. Like Config, it may be called
+// concurrently with the exchanges of the client.
+func (c *Client) StateSnapshot() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := State{
+		Interface:           c.interfaceName,
+		DUID:                hex.EncodeToString(c.duid.ToBytes()),
+		EphemeralDUID:       c.ephemeralDUID,
+		ServerID:            hex.EncodeToString(c.cfg.ServerID),
+		Config:              c.cfg,
+		Err:                 errString(c.err),
+		LastExchange:        exchangeState(c.lastExchange),
+		AcquisitionDuration: c.acquisition,
+	}
+	if c.lastAdvertise != nil {
+		st.LastAdvertise = c.lastAdvertise.Summary()
+	}
+	if c.lastReply != nil {
+		st.LastReply = c.lastReply.Summary()
+	}
+	return st
+}
+
+func exchangeState(ex Exchange) ExchangeState {
+	st := ExchangeState{
+		Sent:          ex.Sent,
+		SentBytes:     ex.SentBytes,
+		Transmissions: ex.Transmissions,
+		Received:      ex.Received,
+		ReceivedBytes: ex.ReceivedBytes,
+		InterfaceID:   ex.InterfaceID,
+		Err:           errString(ex.Err),
+	}
+	if ex.MessageType != dhcpv6.MessageTypeNone {
+		st.MessageType = ex.MessageType.String()
+	}
+	if ex.ReceivedType != dhcpv6.MessageTypeNone {
+		st.ReceivedType = ex.ReceivedType.String()
+	}
+	if ex.ServerID != nil {
+		st.ServerID = hex.EncodeToString(ex.ServerID.ToBytes())
+	}
+	return st
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func TestStateSnapshot(t *testing.T) {
+	prefix := mustParseCIDR("2a02:168:4a00::/48")
+	c := newTestClient(t, newTestServer(prefix))
+	if _, err := c.ObtainOrRenewErr(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := json.Marshal(c.StateSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.DUID, hex.EncodeToString(c.duid.ToBytes()); got != want {
+		t.Errorf("DUID: got %q, want %q", got, want)
+	}
+	if got, want := st.ServerID, hex.EncodeToString(dhcp6test.DefaultServerID.ToBytes()); got != want {
+		t.Errorf("ServerID: got %q, want %q", got, want)
+	}
+	if diff := cmp.Diff([]net.IPNet{prefix}, st.Config.Prefixes); diff != "" {
+		t.Errorf("unexpected prefixes: diff (-want +got):\n%s", diff)
+	}
+	if st.LastAdvertise == "" || st.LastReply == "" {
+		t.Errorf("missing message summaries: advertise %q, reply %q", st.LastAdvertise, st.LastReply)
+	}
+	want := ExchangeState{
+		MessageType:  "REQUEST",
+		ReceivedType: "REPLY",
+		ServerID:     st.ServerID,
+	}
+	opts := cmp.FilterPath(func(p cmp.Path) bool {
+		switch p.Last().String() {
+		case ".Sent", ".SentBytes", ".Transmissions", ".Received", ".ReceivedBytes":
+			return true
+		}
+		return false
+	}, cmp.Ignore())
+	if diff := cmp.Diff(want, st.LastExchange, opts); diff != "" {
+		t.Errorf("unexpected last exchange: diff (-want +got):\n%s", diff)
+	}
+	if st.Err != "" {
+		t.Errorf("Err: got %q, want empty", st.Err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp6
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// StatusError is returned when a server declined to fulfill a request by
+// sending a Status Code option (RFC 8415, section 21.13), e.g. NoPrefixAvail
+// when it has no prefix to delegate.
+type StatusError struct {
+	Code    iana.StatusCode
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("dhcp6: server returned status %v (%d)", e.Code, e.Code)
+	}
+	return fmt.Sprintf("dhcp6: server returned status %v (%d): %s", e.Code, e.Code, e.Message)
+}
+
+// ErrNoPrefixes is returned when a server replied with IA_PD options, but
+// none of them contains a usable prefix, e.g. because the server withdrew the
+// delegation by sending only expired prefixes. The client holds no
+// delegation in that case and should obtain a new lease.
+var ErrNoPrefixes = errors.New("dhcp6: IA_PD without usable prefixes")
+
+// bindingError is like statusError, but additionally returns ErrNoPrefixes if
+// msg contains IA_PD options without any usable prefix, and no IA_NA with a
+// valid address either: a server which only assigns addresses may answer the
+// IA_PD with NoPrefixAvail, which still results in a binding.
+func bindingError(msg *dhcpv6.Message) error {
+	if err := statusError(msg); err != nil {
+		return err
+	}
+	iapds := msg.Options.IAPD()
+	if len(iapds) == 0 {
+		return nil
+	}
+	for _, ia := range msg.Options.IANA() {
+		for _, addr := range ia.Options.Addresses() {
+			if addr.IPv6Addr != nil && addr.ValidLifetime != 0 {
+				return nil
+			}
+		}
+	}
+	for _, ia := range iapds {
+		for _, prefix := range ia.Options.Prefixes() {
+			if prefix.Prefix == nil || prefix.ValidLifetime == 0 {
+				continue
+			}
+			if validatePrefix(*prefix.Prefix) == nil {
+				return nil
+			}
+		}
+	}
+	return ErrNoPrefixes
+}
+
+// statusError returns a *StatusError if msg does not result in a binding: either
+// because of a top-level failure status, or because none of its identity
+// associations contain an address or prefix and one of them carries a failure
+// status. A failure status of an individual identity association is otherwise
+// not an error, as the remaining identity associations are still bound.
+func statusError(msg *dhcpv6.Message) error {
+	if sc := msg.Options.Status(); sc != nil && sc.StatusCode != iana.StatusSuccess {
+		return &StatusError{Code: sc.StatusCode, Message: sc.StatusMessage}
+	}
+	var failed *dhcpv6.OptStatusCode
+	for _, ia := range msg.Options.IANA() {
+		if len(ia.Options.Addresses()) > 0 {
+			return nil
+		}
+		if sc := ia.Options.Status(); sc != nil && sc.StatusCode != iana.StatusSuccess && failed == nil {
+			failed = sc
+		}
+	}
+	for _, ia := range msg.Options.IAPD() {
+		if len(ia.Options.Prefixes()) > 0 {
+			return nil
+		}
+		if sc := ia.Options.Status(); sc != nil && sc.StatusCode != iana.StatusSuccess && failed == nil {
+			failed = sc
+		}
+	}
+	if failed == nil {
+		return nil
+	}
+	return &StatusError{Code: failed.StatusCode, Message: failed.StatusMessage}
+}
+
+// hasStatus returns whether msg contains a Status Code option with code,
+// either at the top level or within an identity association.
+func hasStatus(msg *dhcpv6.Message, code iana.StatusCode) bool {
+	if sc := msg.Options.Status(); sc != nil && sc.StatusCode == code {
+		return true
+	}
+	for _, ia := range msg.Options.IANA() {
+		if sc := ia.Options.Status(); sc != nil && sc.StatusCode == code {
+			return true
+		}
+	}
+	for _, ia := range msg.Options.IAPD() {
+		if sc := ia.Options.Status(); sc != nil && sc.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
@@ -26,20 +26,67 @@ import (
 	"golang.org/x/net/ipv6"
 )
 
+// Prefix is a prefix to advertise.
+type Prefix struct {
+	Prefix net.IPNet
+
+	// PreferredUntil and ValidUntil, if non-zero, are the points in time at
+	// which the lifetimes of Prefix expire, e.g. those of a prefix delegated
+	// via DHCPv6. The remaining lifetimes are advertised, decreasing with
+	// every advertisement until the prefix is updated. If zero, Prefix is
+	// advertised with a preferred lifetime of 30 minutes and a valid lifetime
+	// of 2 hours.
+	PreferredUntil time.Time
+	ValidUntil     time.Time
+}
+
+// withdrawnRAs is the number of advertisements which announce a prefix that
+// is no longer advertised with zero lifetimes, so that hosts deprecate their
+// addresses from it (RFC 7084, section 4.3, L-13).
+const withdrawnRAs = 3
+
+type withdrawnPrefix struct {
+	prefix    net.IPNet
+	remaining int // number of advertisements
+}
+
 type Server struct {
-	pc     *ipv6.PacketConn
-	ifname string
+	pc      *ipv6.PacketConn
+	ifname  string
+	timeNow func() time.Time
 
-	mu       sync.Mutex
-	prefixes []net.IPNet
-	iface    *net.Interface
+	mu            sync.Mutex
+	prefixes      []Prefix
+	withdrawn     map[string]*withdrawnPrefix
+	dnsServers    []net.IP
+	searchDomains []string
+	iface         *net.Interface
 }
 
 func NewServer() (*Server, error) {
-	return &Server{}, nil
+	return &Server{
+		timeNow:   time.Now,
+		withdrawn: make(map[string]*withdrawnPrefix),
+	}, nil
 }
 
+// SetPrefixes is like UpdatePrefixes, advertising prefixes with the default
+// lifetimes.
 func (s *Server) SetPrefixes(prefixes []net.IPNet) {
+	var ps []Prefix
+	if prefixes != nil {
+		ps = make([]Prefix, len(prefixes))
+		for idx, prefix := range prefixes {
+			ps[idx] = Prefix{Prefix: prefix}
+		}
+	}
+	s.UpdatePrefixes(ps)
+}
+
+// UpdatePrefixes replaces the advertised prefixes and advertises them
+// immediately. Previously advertised prefixes which are not contained in
+// prefixes are withdrawn.
+func (s *Server) UpdatePrefixes(prefixes []Prefix) {
 	s.mu.Lock()
 	if s.ifname != "" {
 		var err error
@@ -50,13 +97,34 @@ func (s *Server) SetPrefixes(prefixes []net.IPNet) {
 			log.Fatal(err) // interface vanished
 		}
 	}
+	current := make(map[string]bool, len(prefixes))
+	for _, p := range prefixes {
+		key := p.Prefix.String()
+		current[key] = true
+		delete(s.withdrawn, key)
+	}
+	for _, p := range s.prefixes {
+		if key := p.Prefix.String(); !current[key] {
+			s.withdrawn[key] = &withdrawnPrefix{prefix: p.Prefix, remaining: withdrawnRAs}
+		}
+	}
 	s.prefixes = prefixes
 	s.mu.Unlock()
-	if s.iface != nil {
+	if s.pc != nil {
 		s.sendAdvertisement(nil)
 	}
 }
 
+// SetDNS replaces the DNS servers (RDNSS option) and search domains (DNSSL
+// option) which are advertised, RFC 8106. A nil servers advertises the
+// link-local address of the interface, a nil domains the search domain lan.
+func (s *Server) SetDNS(servers []net.IP, domains []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dnsServers = servers
+	s.searchDomains = domains
+}
+
 func (s *Server) Serve(ifname string, conn net.PacketConn) error {
 	var err error
 	s.ifname = ifname
@@ -137,12 +205,42 @@ func (s *Server) sendAdvertisement(addr net.Addr) error {
 		}
 	}
 
+	ra, err := s.advertisement(s.timeNow())
+	if err != nil {
+		return err
+	}
+	mb, err := ndp.MarshalMessage(ra)
+	if err != nil {
+		return err
+	}
+	log.Printf("sending to %s", addr)
+	if _, err := s.pc.WriteTo(mb, nil, addr); err != nil {
+		return err
+	}
+	for key, w := range s.withdrawn {
+		if w.remaining--; w.remaining <= 0 {
+			delete(s.withdrawn, key)
+		}
+	}
+	return nil
+}
+
+// advertisement returns the router advertisement to send at now. s.mu must be
+// held.
+func (s *Server) advertisement(now time.Time) (*ndp.RouterAdvertisement, error) {
 	var options []ndp.Option
 
-	if len(s.prefixes) > 0 {
+	if servers := s.dnsServers; servers != nil {
+		if len(servers) > 0 {
+			options = append(options, &ndp.RecursiveDNSServer{
+				Lifetime: 30 * time.Minute,
+				Servers:  servers,
+			})
+		}
+	} else if len(s.prefixes) > 0 {
 		addrs, err := s.iface.Addrs()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var linkLocal net.IP
 		for _, addr := range addrs {
@@ -163,30 +261,40 @@ func (s *Server) sendAdvertisement(addr net.Addr) error {
 		}
 	}
 
+	// The router is only a default router while one of its prefixes is valid
+	// (RFC 7084, section 4.1), e.g. not after the DHCPv6 lease expired.
+	valid := len(s.prefixes) == 0
 	for _, prefix := range s.prefixes {
-		ones, _ := prefix.Mask.Size()
-		// Use the first /64 subnet within larger prefixes
-		if ones < 64 {
-			ones = 64
+		preferredLifetime, validLifetime := 30*time.Minute, 2*time.Hour
+		if !prefix.ValidUntil.IsZero() {
+			validLifetime = remaining(now, prefix.ValidUntil)
+			preferredLifetime = remaining(now, prefix.PreferredUntil)
+			if preferredLifetime > validLifetime {
+				preferredLifetime = validLifetime
+			}
+		}
+		if validLifetime > 0 {
+			valid = true
 		}
+		options = append(options, prefixInformation(prefix.Prefix, preferredLifetime, validLifetime))
+	}
+	for _, w := range s.withdrawn {
+		options = append(options, prefixInformation(w.prefix, 0, 0))
+	}
 
-		options = append(options, &ndp.PrefixInformation{
-			PrefixLength:                   uint8(ones),
-			OnLink:                         true,
-			AutonomousAddressConfiguration: true,
-			ValidLifetime:                  2 * time.Hour,
-			PreferredLifetime:              30 * time.Minute,
-			Prefix:                         prefix.IP,
+	domains := s.searchDomains
+	if domains == nil {
+		// TODO: single source of truth for search domain name
+		domains = []string{"lan"}
+	}
+	if len(domains) > 0 {
+		options = append(options, &ndp.DNSSearchList{
+			// TODO: audit all lifetimes and express them in relation to each other
+			Lifetime:    20 * time.Minute,
+			DomainNames: domains,
 		})
 	}
-
 	options = append(options,
-		&ndp.DNSSearchList{
-			// TODO: audit all lifetimes and express them in relation to each other
-			Lifetime: 20 * time.Minute,
-			// TODO: single source of truth for search domain name
-			DomainNames: []string{"lan"},
-		},
 		ndp.NewMTU(uint32(s.iface.MTU)),
 		&ndp.LinkLayerAddress{
 			Direction: ndp.Source,
@@ -196,17 +304,35 @@ func (s *Server) sendAdvertisement(addr net.Addr) error {
 
 	ra := &ndp.RouterAdvertisement{
 		CurrentHopLimit: 64,
-		RouterLifetime:  30 * time.Minute,
 		Options:         options,
 	}
+	if valid {
+		ra.RouterLifetime = 30 * time.Minute
+	}
+	return ra, nil
+}
 
-	mb, err := ndp.MarshalMessage(ra)
-	if err != nil {
-		return err
+// remaining returns the lifetime which remains at now until the point in time
+// until, in whole seconds, or 0 if until passed.
+func remaining(now, until time.Time) time.Duration {
+	if d := until.Sub(now).Truncate(time.Second); d > 0 {
+		return d
 	}
-	log.Printf("sending to %s", addr)
-	if _, err := s.pc.WriteTo(mb, nil, addr); err != nil {
-		return err
+	return 0
+}
+
+func prefixInformation(prefix net.IPNet, preferred, valid time.Duration) *ndp.PrefixInformation {
+	ones, _ := prefix.Mask.Size()
+	// Use the first /64 subnet within larger prefixes
+	if ones < 64 {
+		ones = 64
+	}
+	return &ndp.PrefixInformation{
+		PrefixLength:                   uint8(ones),
+		OnLink:                         true,
+		AutonomousAddressConfiguration: true,
+		ValidLifetime:                  valid,
+		PreferredLifetime:              preferred,
+		Prefix:                         prefix.IP,
 	}
-	return nil
 }
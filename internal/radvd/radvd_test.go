@@ -0,0 +1,148 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package radvd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/ndp"
+)
+
+func mustParseCIDR(s string) net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *n
+}
+
+func prefixInformations(ra *ndp.RouterAdvertisement) []*ndp.PrefixInformation {
+	var pis []*ndp.PrefixInformation
+	for _, opt := range ra.Options {
+		if pi, ok := opt.(*ndp.PrefixInformation); ok {
+			pis = append(pis, pi)
+		}
+	}
+	return pis
+}
+
+func TestAdvertisement(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.iface = &net.Interface{
+		Name:         "lan0",
+		MTU:          1500,
+		HardwareAddr: net.HardwareAddr{0x02, 0x73, 0x53, 0x00, 0xca, 0xfe},
+	}
+	now := time.Now()
+	delegated := mustParseCIDR("2a02:168:4a00::/48")
+	s.UpdatePrefixes([]Prefix{
+		{
+			Prefix:         delegated,
+			PreferredUntil: now.Add(1 * time.Hour),
+			ValidUntil:     now.Add(2 * time.Hour),
+		},
+	})
+	s.SetDNS([]net.IP{net.ParseIP("2001:db8::53")}, []string{"example.net"})
+
+	// The remaining lifetimes decrease over time.
+	ra, err := s.advertisement(now.Add(10 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ra.RouterLifetime, 30*time.Minute; got != want {
+		t.Errorf("unexpected router lifetime: got %v, want %v", got, want)
+	}
+	want := []*ndp.PrefixInformation{
+		{
+			PrefixLength:                   64,
+			OnLink:                         true,
+			AutonomousAddressConfiguration: true,
+			PreferredLifetime:              50 * time.Minute,
+			ValidLifetime:                  110 * time.Minute,
+			Prefix:                         delegated.IP,
+		},
+	}
+	if diff := cmp.Diff(want, prefixInformations(ra)); diff != "" {
+		t.Errorf("unexpected prefix information: diff (-want +got):\n%s", diff)
+	}
+	var rdnss *ndp.RecursiveDNSServer
+	var dnssl *ndp.DNSSearchList
+	for _, opt := range ra.Options {
+		switch o := opt.(type) {
+		case *ndp.RecursiveDNSServer:
+			rdnss = o
+		case *ndp.DNSSearchList:
+			dnssl = o
+		}
+	}
+	if rdnss == nil || len(rdnss.Servers) != 1 || !rdnss.Servers[0].Equal(net.ParseIP("2001:db8::53")) {
+		t.Errorf("unexpected RDNSS option: %+v", rdnss)
+	}
+	if dnssl == nil || !cmp.Equal(dnssl.DomainNames, []string{"example.net"}) {
+		t.Errorf("unexpected DNSSL option: %+v", dnssl)
+	}
+
+	// A new prefix replaces the old one, which is withdrawn.
+	renumbered := mustParseCIDR("2a02:168:4b00::/48")
+	s.SetPrefixes([]net.IPNet{renumbered})
+	ra, err = s.advertisement(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []*ndp.PrefixInformation{
+		{
+			PrefixLength:                   64,
+			OnLink:                         true,
+			AutonomousAddressConfiguration: true,
+			PreferredLifetime:              30 * time.Minute,
+			ValidLifetime:                  2 * time.Hour,
+			Prefix:                         renumbered.IP,
+		},
+		{
+			PrefixLength:                   64,
+			OnLink:                         true,
+			AutonomousAddressConfiguration: true,
+			Prefix:                         delegated.IP,
+		},
+	}
+	if diff := cmp.Diff(want, prefixInformations(ra)); diff != "" {
+		t.Errorf("unexpected prefix information: diff (-want +got):\n%s", diff)
+	}
+
+	// Once all prefixes expired, the router is no longer a default router.
+	s.UpdatePrefixes([]Prefix{
+		{
+			Prefix:         renumbered,
+			PreferredUntil: now.Add(1 * time.Hour),
+			ValidUntil:     now.Add(2 * time.Hour),
+		},
+	})
+	ra, err = s.advertisement(now.Add(3 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ra.RouterLifetime; got != 0 {
+		t.Errorf("unexpected router lifetime after expiry: got %v, want 0", got)
+	}
+	if pi := prefixInformations(ra)[0]; pi.ValidLifetime != 0 || pi.PreferredLifetime != 0 {
+		t.Errorf("unexpected lifetimes after expiry: %+v", pi)
+	}
+}
@@ -128,17 +128,29 @@ func pcapwrite(w *pcapgo.Writer, ip layer, udp *layers.UDP, b []byte) error {
 type packetConn struct {
 	pcapr *pcapgo.Reader
 	pcapw *pcapgo.Writer
+
+	// unanswered is the number of written packets for which no packet was
+	// read yet.
+	unanswered int
 }
 
 // NewPCAP returns a net.PacketConn which replays packets from pcap file input,
-// writing packets to pcap file output (if non-empty).
+// writing packets to pcap file output (if non-empty). Each written packet is
+// answered with one packet from input: reading more packets than were
+// written fails with a timeout error, as if the read deadline expired.
 //
 // See https://en.wikipedia.org/wiki/Pcap for details on pcap.
 func NewPacketConn(input, output string) (net.PacketConn, error) {
 	pcapr, pcapw, err := pcapopen(input, output)
-	return &packetConn{pcapr, pcapw}, err
+	return &packetConn{pcapr: pcapr, pcapw: pcapw}, err
 }
 
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
 func (r *packetConn) LocalAddr() net.Addr                { return nil }
 func (r *packetConn) Close() error                       { return nil }
 func (r *packetConn) SetDeadline(t time.Time) error      { return nil }
@@ -146,6 +158,7 @@ func (r *packetConn) SetReadDeadline(t time.Time) error  { return nil }
 func (r *packetConn) SetWriteDeadline(t time.Time) error { return nil }
 
 func (r *packetConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	r.unanswered++
 	if r.pcapw == nil {
 		return len(b), nil
 	}
@@ -167,6 +180,10 @@ func (r *packetConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
 }
 
 func (r *packetConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	if r.unanswered == 0 {
+		return 0, nil, timeoutError{}
+	}
+	r.unanswered--
 	l, ip, err := readFrom(r.pcapr, buf)
 	return l, &net.IPAddr{IP: ip}, err
 }
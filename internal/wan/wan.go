@@ -0,0 +1,313 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wan manages the DHCPv4 and DHCPv6 clients of an uplink.
+package wan
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/rtr7/router7/internal/dhcp4"
+	"github.com/rtr7/router7/internal/dhcp6"
+)
+
+// Config contains configuration for New.
+type Config struct {
+	// DHCP4 is the DHCPv4 client of the WAN interface. It must not be used
+	// for exchanges while Uplink.Run runs.
+	DHCP4 *dhcp4.Client
+
+	// DHCP6 configures the DHCPv6 client of the WAN interface, which New
+	// creates (see Uplink.DHCP6). Its OnConfigChange callback is still
+	// called.
+	//
+	// Either DHCP4 or DHCP6 may be nil if the uplink does not provide the
+	// address family, but not both.
+	DHCP6 *dhcp6.ClientConfig
+
+	// OnStatusChange, if non-nil, is called with the new status whenever an
+	// address family comes up or goes down, or the configuration of a family
+	// changes (e.g. different DNS servers). Calls are serialized.
+	OnStatusChange func(Status)
+
+	// Logger receives the log messages of both clients' loops. It defaults
+	// to dhcp6.StdLogger(nil, false).
+	Logger dhcp6.Logger
+}
+
+// Status describes which address families of the WAN interface are up.
+type Status struct {
+	IPv4 bool `json:"ipv4"` // whether the DHCPv4 client holds a lease
+	IPv6 bool `json:"ipv6"` // whether the DHCPv6 client holds a lease
+
+	Config4 dhcp4.Config `json:"config4"` // zero unless IPv4
+	Config6 dhcp6.Config `json:"config6"` // zero unless IPv6
+
+	// DNS contains the DNS servers of both leases, IPv6 servers first, without
+	// duplicates.
+	DNS []string `json:"dns"`
+}
+
+// Up returns whether at least one address family is up.
+func (s Status) Up() bool { return s.IPv4 || s.IPv6 }
+
+// String returns "down", "v4", "v6" or "both".
+func (s Status) String() string {
+	switch {
+	case s.IPv4 && s.IPv6:
+		return "both"
+	case s.IPv4:
+		return "v4"
+	case s.IPv6:
+		return "v6"
+	default:
+		return "down"
+	}
+}
+
+// v4Client is implemented by *dhcp4.Client.
+type v4Client interface {
+	ObtainOrRenewErr(ctx context.Context) (dhcp4.Config, error)
+}
+
+// Uplink manages the DHCPv4 and DHCPv6 clients of one WAN interface: Run
+// keeps both leases current and Status reports their combined status. An
+// address family which is not available on the uplink (e.g. no DHCPv4
+// server answers) is reported as down, without affecting the other family.
+type Uplink struct {
+	v4             v4Client // nil if Config.DHCP4 is nil
+	v6             *dhcp6.Client
+	onStatusChange func(Status)
+	log            dhcp6.Logger
+	retryBackoff   backoff.Backoff // for the DHCPv4 loop
+	timeNow        func() time.Time
+
+	mu      sync.Mutex
+	config4 *dhcp4.Config // nil unless IPv4 is up
+	config6 *dhcp6.Config // nil unless IPv6 is up
+
+	notifyMu   sync.Mutex
+	lastStatus *Status // of the last OnStatusChange call
+}
+
+// New returns an Uplink managing the DHCPv4 client of cfg and a DHCPv6
+// client created from cfg.DHCP6, whose OnConfigChange callback is wrapped to
+// track the DHCPv6 lease.
+func New(cfg Config) (*Uplink, error) {
+	if cfg.DHCP4 == nil && cfg.DHCP6 == nil {
+		return nil, errors.New("wan: Config requires DHCP4 or DHCP6")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = dhcp6.StdLogger(nil, false)
+	}
+	w := &Uplink{
+		onStatusChange: cfg.OnStatusChange,
+		log:            logger,
+		timeNow:        time.Now,
+	}
+	w.retryBackoff = backoff.Backoff{
+		Factor: 2,
+		Jitter: true,
+		Min:    10 * time.Second,
+		Max:    5 * time.Minute,
+	}
+	if cfg.DHCP4 != nil {
+		w.v4 = cfg.DHCP4
+	}
+	if cfg.DHCP6 != nil {
+		cfg6 := *cfg.DHCP6
+		// An expired or released lease is reported as the empty Config.
+		onConfigChange := cfg6.OnConfigChange
+		cfg6.OnConfigChange = func(cfg dhcp6.Config) {
+			w.setConfig6(cfg)
+			if onConfigChange != nil {
+				onConfigChange(cfg)
+			}
+		}
+		c, err := dhcp6.NewClient(cfg6)
+		if err != nil {
+			return nil, err
+		}
+		w.v6 = c
+	}
+	return w, nil
+}
+
+// DHCP6 returns the DHCPv6 client created by New, or nil if Config.DHCP6 was
+// nil. While Run runs, only the methods of the client which may be called
+// concurrently with Client.Run (e.g. Config and ForceRenew) may be used. The
+// caller should Close the client once Run returned.
+func (w *Uplink) DHCP6() *dhcp6.Client { return w.v6 }
+
+// Status returns the current status. It may be called concurrently with Run.
+func (w *Uplink) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var st Status
+	if cfg := w.config6; cfg != nil {
+		st.IPv6 = true
+		st.Config6 = *cfg
+		st.DNS = appendNew(st.DNS, cfg.DNS)
+	}
+	if cfg := w.config4; cfg != nil {
+		st.IPv4 = true
+		st.Config4 = *cfg
+		st.DNS = appendNew(st.DNS, cfg.DNS)
+	}
+	return st
+}
+
+// appendNew appends the elements of add which dst does not contain yet.
+func appendNew(dst, add []string) []string {
+	for _, s := range add {
+		found := false
+		for _, d := range dst {
+			if d == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, s)
+		}
+	}
+	return dst
+}
+
+// Run runs the DHCPv6 client (see dhcp6.Client.Run) and a corresponding loop
+// for the DHCPv4 client until ctx is done, and then returns ctx.Err().
+func (w *Uplink) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	if w.v6 != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.v6.Run(ctx); err != nil && err != ctx.Err() {
+				w.log.Printf("DHCPv6: %v", err)
+			}
+		}()
+	}
+	if w.v4 != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run4(ctx)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// run4 obtains a DHCPv4 lease and renews it at Config.RenewAfter until ctx is
+// done. Failed exchanges are retried with exponential backoff; IPv4 is
+// reported as down after a failed exchange once the lease expired.
+func (w *Uplink) run4(ctx context.Context) {
+	retry := w.retryBackoff
+	for {
+		cfg, err := w.v4.ObtainOrRenewErr(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		var wait time.Duration
+		if err != nil {
+			w.log.Printf("%v", err)
+			w.expire4()
+			wait = retry.Duration()
+		} else {
+			retry.Reset()
+			w.setConfig4(&cfg)
+			wait = cfg.RenewAfter.Sub(w.timeNow())
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return
+		}
+	}
+}
+
+// expire4 discards the DHCPv4 lease if it expired. A lease without expiry
+// is discarded after the first failed exchange.
+func (w *Uplink) expire4() {
+	w.mu.Lock()
+	cfg := w.config4
+	w.mu.Unlock()
+	if cfg == nil {
+		return
+	}
+	if !cfg.LeaseExpiry.IsZero() && w.timeNow().Before(cfg.LeaseExpiry) {
+		return
+	}
+	w.setConfig4(nil)
+}
+
+func (w *Uplink) setConfig4(cfg *dhcp4.Config) {
+	w.mu.Lock()
+	w.config4 = cfg
+	w.mu.Unlock()
+	w.notify()
+}
+
+// setConfig6 records cfg, the current DHCPv6 configuration. IPv6 is up if the
+// lease contains prefixes or addresses.
+func (w *Uplink) setConfig6(cfg dhcp6.Config) {
+	w.mu.Lock()
+	if len(cfg.Prefixes) > 0 || len(cfg.Addresses) > 0 {
+		w.config6 = &cfg
+	} else {
+		w.config6 = nil
+	}
+	w.mu.Unlock()
+	w.notify()
+}
+
+// notify calls OnStatusChange if the status changed since the last call,
+// ignoring the points in time which move with every renewal.
+func (w *Uplink) notify() {
+	if w.onStatusChange == nil {
+		return
+	}
+	w.notifyMu.Lock()
+	defer w.notifyMu.Unlock()
+	st := w.Status()
+	if last := w.lastStatus; last != nil && equalStatus(*last, st) {
+		return
+	}
+	w.lastStatus = &st
+	w.onStatusChange(st)
+}
+
+// equalStatus returns whether a and b are equal, apart from the timers of the
+// DHCPv4 lease. The DHCPv6 configuration is only updated via OnConfigChange,
+// i.e. when it changed in more than its timers.
+func equalStatus(a, b Status) bool {
+	a.Config4.RenewAfter, a.Config4.LeaseExpiry = time.Time{}, time.Time{}
+	b.Config4.RenewAfter, b.Config4.LeaseExpiry = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
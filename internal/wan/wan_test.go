@@ -0,0 +1,239 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/rtr7/router7/internal/dhcp4"
+	"github.com/rtr7/router7/internal/dhcp6"
+	"github.com/rtr7/router7/internal/dhcp6/dhcp6test"
+)
+
+func mustParseCIDR(s string) net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *n
+}
+
+// clientConfig returns a DHCPv6 client configuration for exchanges with srv.
+func clientConfig(srv *dhcp6test.Server) dhcp6.ClientConfig {
+	return dhcp6.ClientConfig{
+		InterfaceName: "lo",
+		LocalAddr:     &net.UDPAddr{IP: net.ParseIP("fe80::42:aff:fea5:966e"), Port: dhcpv6.DefaultClientPort},
+		HardwareAddr:  net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		RapidCommit:   true,
+		Conn:          srv,
+	}
+}
+
+// fakeV4 fails the first failures exchanges, and then grants cfg.
+type fakeV4 struct {
+	mu       sync.Mutex
+	failures int
+	cfg      dhcp4.Config
+}
+
+func (f *fakeV4) ObtainOrRenewErr(ctx context.Context) (dhcp4.Config, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return dhcp4.Config{}, errors.New("DHCP: timeout (server(s) unreachable)")
+	}
+	cfg := f.cfg
+	cfg.RenewAfter = time.Now().Add(1 * time.Hour)
+	return cfg, nil
+}
+
+func TestUplink(t *testing.T) {
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:    []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+		RapidCommit: true,
+	})
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		replies := srv.Respond(msg)
+		for _, reply := range replies {
+			reply.AddOption(dhcpv6.OptDNS(net.ParseIP("2001:db8::53")))
+		}
+		return replies
+	})
+	var changes []dhcp6.Config
+	cfg6 := clientConfig(srv)
+	cfg6.OnConfigChange = func(cfg dhcp6.Config) { changes = append(changes, cfg) }
+	statuses := make(chan Status, 10)
+	w, err := New(Config{
+		DHCP6:          &cfg6,
+		OnStatusChange: func(st Status) { statuses <- st },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := w.DHCP6()
+	defer c.Close()
+	// New only accepts *dhcp4.Client, which cannot be faked.
+	w.v4 = &fakeV4{
+		failures: 2,
+		cfg: dhcp4.Config{
+			ClientIP: "192.0.2.10",
+			DNS:      []string{"192.0.2.53", "2001:db8::53"},
+		},
+	}
+	w.retryBackoff.Min = 10 * time.Millisecond
+	w.retryBackoff.Max = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- w.Run(ctx) }()
+	var got []string
+	for len(got) < 2 {
+		select {
+		case st := <-statuses:
+			got = append(got, st.String())
+		case err := <-errc:
+			t.Fatalf("Run returned unexpectedly: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for status changes, got %v", got)
+		}
+	}
+	// Either family may come up first.
+	if got[0] != "v4" && got[0] != "v6" || got[1] != "both" {
+		t.Errorf("unexpected status changes: got %v, want [v4 both] or [v6 both]", got)
+	}
+	st := w.Status()
+	if diff := cmp.Diff([]string{"2001:db8::53", "192.0.2.53"}, st.DNS); diff != "" {
+		t.Errorf("unexpected DNS servers: diff (-want +got):\n%s", diff)
+	}
+	if got, want := st.Config4.ClientIP, "192.0.2.10"; got != want {
+		t.Errorf("unexpected IPv4 address: got %v, want %v", got, want)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("Run: got %v, want %v", err, context.Canceled)
+	}
+	select {
+	case st := <-statuses:
+		t.Errorf("unexpected status change: %v", st)
+	default:
+	}
+
+	// Releasing the DHCPv6 lease takes IPv6 down, and the callback of the
+	// client is still called.
+	if _, _, err := c.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := (<-statuses).String(), "v4"; got != want {
+		t.Errorf("unexpected status after Release: got %v, want %v", got, want)
+	}
+	if len(changes) != 2 || len(changes[1].Prefixes) != 0 {
+		t.Errorf("ClientConfig.OnConfigChange: got %+v, want the lease and the empty Config", changes)
+	}
+}
+
+func TestUplinkConcurrent(t *testing.T) {
+	// Each Solicit delegates a different prefix, so that every forced
+	// renewal changes the configuration.
+	srv := dhcp6test.NewFakeServer(dhcp6test.ServerConfig{
+		Prefixes:    []net.IPNet{mustParseCIDR("2a02:168:4a00::/48")},
+		RapidCommit: true,
+	})
+	var solicits int
+	srv.Handle(dhcpv6.MessageTypeSolicit, func(msg *dhcpv6.Message) []*dhcpv6.Message {
+		solicits++
+		prefix := mustParseCIDR(fmt.Sprintf("2a02:168:%x::/48", solicits))
+		replies := srv.Respond(msg)
+		for _, reply := range replies {
+			for _, iapd := range reply.Options.IAPD() {
+				for _, p := range iapd.Options.Prefixes() {
+					p.Prefix = &prefix
+				}
+			}
+		}
+		return replies
+	})
+	var changes int
+	cfg6 := clientConfig(srv)
+	cfg6.OnConfigChange = func(dhcp6.Config) { changes++ } // called by Run only
+	statuses := make(chan Status, 10)
+	w, err := New(Config{
+		DHCP6:          &cfg6,
+		OnStatusChange: func(st Status) { statuses <- st },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := w.DHCP6()
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- w.Run(ctx) }()
+	// Run with -race to detect unsynchronized accesses between Run and the
+	// methods which may be called concurrently with it.
+	var last string
+	for i := 0; i < 3; i++ {
+		select {
+		case st := <-statuses:
+			if got := st.Config6.Prefixes[0].String(); got == last {
+				t.Fatalf("status change %d: prefix %v did not change", i, got)
+			} else {
+				last = got
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for status change %d", i)
+		}
+		_ = w.Status()
+		_ = c.Config()
+		c.ForceRenew()
+	}
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("Run: got %v, want %v", err, context.Canceled)
+	}
+	if changes < 3 {
+		t.Errorf("ClientConfig.OnConfigChange calls: got %d, want at least 3", changes)
+	}
+}
+
+func TestUplinkExpire4(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Fatalf("New without clients unexpectedly succeeded")
+	}
+	now := time.Now()
+	w := &Uplink{timeNow: func() time.Time { return now }}
+	w.setConfig4(&dhcp4.Config{LeaseExpiry: now.Add(time.Minute)})
+	w.expire4()
+	if !w.Status().IPv4 {
+		t.Fatalf("IPv4 down before the lease expired")
+	}
+	now = now.Add(time.Minute)
+	w.expire4()
+	if st := w.Status(); st.IPv4 || st.Up() {
+		t.Fatalf("IPv4 still up after the lease expired: %v", st)
+	}
+}